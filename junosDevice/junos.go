@@ -1,17 +1,40 @@
 package junosDevice
 
 import (
+	"bufio"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"os"
 	"regexp"
+	"strings"
 	"time"
 
 	"github.com/ipcjk/mlxsh/routerDevice"
 )
 
+/* junosCommandRewrite is shared by the cli and the NETCONF driver */
+var junosCommandRewrite = map[string]string{
+	"mlxsh_log":        "show log messages",
+	"mlxsh_audit":      "show logging",
+	"mlxsh_chassis":    "show chassis hardware",
+	"mlxsh_route":      "show route table inet.0",
+	"mlxsh_route6":     "show route table inet6.0",
+	"mlxsh_include":    "match",
+	"mlxsh_pipe":       "|",
+	"mlxsh_route_sum":  "show route summary table inet.0",
+	"mlxsh_route6_sum": "show route summary table inet6.0",
+	"mlxsh_bgp":        "show bgp summary",
+	"mlxsh_bgp6":       "show bgp summary",
+	"mlxsh_bgpn":       "show bgp neighbor",
+	"mlxsh_bgpn6":      "show bgp neighbor",
+	"mlxsh_vlans":      "show vlans",
+}
+
 type junosDevice struct {
 	RTC router.RunTimeConfig
 	router.Router
+	confirmCheck func() error
 }
 
 /*
@@ -19,7 +42,9 @@ JunosDevice returns a new
 junosDevice object, has a init struct of type Router.RunTimeConfig
 */
 func JunosDevice(Config router.RunTimeConfig) *junosDevice {
-	var configureErrors = `(?i)(invalid command|unknown|Warning|Error|not found)`
+	/* only diagnostics of the load, the echoed configuration may contain any word */
+	var configureErrors = `(?m)^\s*(error:|warning:|syntax error|terminal:\d+:\(\d+\) syntax error)`
+	var execErrors = `(?m)^\s*(syntax error|unknown command\.|error: )`
 
 	/* Fill our config with defaults for ssh and timesouts */
 	router.GenerateDefaults(&Config)
@@ -29,24 +54,10 @@ func JunosDevice(Config router.RunTimeConfig) *junosDevice {
 	return &junosDevice{
 		RTC: Config,
 		Router: router.Router{
-			CommandRewrite: map[string]string{
-				"mlxsh_log":        "show log messages",
-				"mlxsh_audit":      "show logging",
-				"mlxsh_chassis":    "show chassis hardware",
-				"mlxsh_route":      "show route table inet.0",
-				"mlxsh_route6":     "show route table inet6.0",
-				"mlxsh_include":    "match",
-				"mlxsh_pipe":       "|",
-				"mlxsh_route_sum":  "show route summary table inet.0",
-				"mlxsh_route6_sum": "show route summary table inet6.0",
-				"mlxsh_bgp":        "show bgp summary",
-				"mlxsh_bgp6":       "show bgp summary",
-				"mlxsh_bgpn":       "show bgp neighbor",
-				"mlxsh_bgpn6":      "show bgp neighbor",
-				"mlxsh_vlans":      "show vlans",
-			},
+			CommandRewrite:     junosCommandRewrite,
 			PromptModes:        make(map[string]string),
 			ErrorMatches:       regexp.MustCompile(configureErrors),
+			ExecErrorMatches:   regexp.MustCompile(execErrors),
 			PromptDetect:       `[@?\.\d\w-]+> ?$`,
 			PromptReadTriggers: []string{">"},
 			PromptReplacements: map[string][]string{
@@ -56,14 +67,14 @@ func JunosDevice(Config router.RunTimeConfig) *junosDevice {
 }
 
 func (b *junosDevice) Connect() (err error) {
-	if err = b.Router.SetupSSH(b.RTC.ConnectionAddr, b.RTC.SSHClientConfig, false); err != nil {
+	if err = b.Router.SetupSSH(b.RTC, false); err != nil {
 		return err
 	}
 
 	/* JunOS  uses `> ` for prompt */
 	prompt, err := b.Router.ReadTill(b.RTC, b.PromptReadTriggers)
 	if err := b.DetectSetPrompt(prompt); err != nil {
-		return fmt.Errorf("detect prompt: %s", err)
+		return fmt.Errorf("detect prompt: %w", err)
 	}
 
 	if _, err = b.skipPageDisplayMode(); err != nil {
@@ -91,9 +102,10 @@ func (b *junosDevice) ConfigureTerminalMode() error {
 		return err
 	}
 
-	_, err := b.ReadTill(b.RTC, []string{"[edit]"})
+	/* [edit] is followed by the configuration prompt, read both */
+	_, err := b.ReadTill(b.RTC, []string{b.SSHConfigPrompt})
 	if err != nil {
-		return fmt.Errorf("Cant find configure prompt: %s", err)
+		return router.Errorf(router.ErrPromptDetect, b.RTC.Hostname, "Cant find configure prompt: %w", err)
 	}
 
 	if b.RTC.Debug {
@@ -104,7 +116,7 @@ func (b *junosDevice) ConfigureTerminalMode() error {
 
 func (b *junosDevice) ExecPrivilegedMode(command string) error {
 	if err := b.SwitchMode("sshEnabled"); err != nil {
-		return fmt.Errorf("Cant switch to privileged mode: %s", err)
+		return fmt.Errorf("Cant switch to privileged mode: %w", err)
 	}
 
 	if err := b.write(command + "\n"); err != nil {
@@ -112,14 +124,14 @@ func (b *junosDevice) ExecPrivilegedMode(command string) error {
 	}
 	_, err := b.ReadTillEnabledPrompt(b.RTC)
 	if err != nil {
-		return fmt.Errorf("Cant find  privileged mode: %s", err)
+		return fmt.Errorf("Cant find  privileged mode: %w", err)
 	}
 	return nil
 }
 
 func (b *junosDevice) skipPageDisplayMode() (string, error) {
 	if err := b.SwitchMode("sshEnabled"); err != nil {
-		return "", fmt.Errorf("Cant switch to enabled mode to execute terminal-length: %s", err)
+		return "", fmt.Errorf("Cant switch to enabled mode to execute terminal-length: %w", err)
 	}
 
 	if err := b.write("set cli screen-length 0\n"); err != nil {
@@ -147,6 +159,9 @@ func (b *junosDevice) SwitchMode(targetMode string) error {
 			if err := b.write("exit configuration-mode\n"); err != nil {
 				return err
 			}
+			if _, err := b.ReadTillEnabledPrompt(b.RTC); err != nil {
+				return router.Errorf(router.ErrPromptDetect, b.RTC.Hostname, "Cant leave configuration mode: %w", err)
+			}
 		}
 	}
 	b.PromptMode = targetMode
@@ -165,24 +180,62 @@ func (b *junosDevice) rollback() (err error) {
 
 	_, err = b.ReadTill(b.RTC, []string{"load complete"})
 	if err != nil {
-		return fmt.Errorf("Cant rollback configuration after failed commit %s", err)
+		return fmt.Errorf("Cant rollback configuration after failed commit %w", err)
 	}
 	return
 }
 
+/*
+CommitConfiguration runs a commit check first and rolls back on failure. With ConfirmMinutes
+set, it commits confirmed, runs the post-checks and the confirm check and only then confirms the
+commit. If a post-check fails or mlxsh loses the device, Junos reverts the configuration on its own.
+*/
 func (b *junosDevice) CommitConfiguration() (err error) {
 	/* Juniper needs a commit  */
 	if err = b.SwitchMode("sshConfig"); err != nil {
 		return err
 	}
 
-	if err := b.Write(b.RTC, "commit comment \"mlxsh change\" and-quit\n"); err != nil {
+	if err = b.commitCheck(); err != nil {
+		if rollbackErr := b.rollback(); rollbackErr != nil && b.RTC.Debug {
+			fmt.Fprintf(b.RTC.W, "%s\n", rollbackErr)
+		}
 		return err
 	}
 
-	_, err = b.ReadTill(b.RTC, []string{"Exiting configuration mode"})
-	if err != nil {
-		return fmt.Errorf("Commit not completed or not successful: %s", err)
+	comment := commitComment(b.RTC.CommitComment)
+
+	if b.RTC.ConfirmMinutes > 0 {
+		if err := b.write(fmt.Sprintf("commit confirmed %d comment %s\n", b.RTC.ConfirmMinutes, comment)); err != nil {
+			return err
+		}
+
+		val, err := b.ReadTill(b.RTC, []string{"commit complete", "error:"})
+		if err != nil || !strings.Contains(val, "commit complete") {
+			return router.Errorf(router.ErrCommitFailed, b.RTC.Hostname, "Commit confirmed not completed or not successful: %s %s", err, strings.TrimSpace(val))
+		}
+
+		if _, err = b.ReadTill(b.RTC, []string{b.SSHConfigPrompt}); err != nil {
+			return router.Errorf(router.ErrPromptDetect, b.RTC.Hostname, "Cant find configure prompt after commit: %w", err)
+		}
+
+		if err = b.runPostChecks(); err != nil {
+			return fmt.Errorf("%w, configuration will be rolled back by Junos in %d minutes", err, b.RTC.ConfirmMinutes)
+		}
+
+		if b.confirmCheck != nil {
+			if err = b.confirmCheck(); err != nil {
+				return fmt.Errorf("%w, configuration will be rolled back by Junos in %d minutes", err, b.RTC.ConfirmMinutes)
+			}
+			/* the checks run in exec mode, commit from the configuration mode again */
+			if err = b.SwitchMode("sshConfig"); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err = b.commitAndQuit(comment); err != nil {
+		return router.Errorf(router.ErrCommitFailed, b.RTC.Hostname, "Commit not completed or not successful: %w", err)
 	}
 
 	/* give Juniper 1 second to settle down */
@@ -191,24 +244,354 @@ func (b *junosDevice) CommitConfiguration() (err error) {
 	return
 }
 
+/*SetConfirmCheck sets a check, that runs after the post-checks of a commit confirmed */
+func (b *junosDevice) SetConfirmCheck(check func() error) {
+	b.confirmCheck = check
+}
+
+/*Rollback loads and commits the previous configuration, e.g. after failed post-checks */
+func (b *junosDevice) Rollback() (err error) {
+	if err = b.SwitchMode("sshConfig"); err != nil {
+		return err
+	}
+
+	if err = b.write("rollback 1\n"); err != nil {
+		return err
+	}
+
+	if _, err = b.ReadTill(b.RTC, []string{"load complete"}); err != nil {
+		return fmt.Errorf("Cant load rollback 1: %w", err)
+	}
+
+	if err = b.commitAndQuit(`"mlxsh rollback"`); err != nil {
+		return router.Errorf(router.ErrCommitFailed, b.RTC.Hostname, "Rollback commit not completed or not successful: %w", err)
+	}
+
+	return
+}
+
+/*
+commitAndQuit commits with the quoted comment and leaves the configuration mode. It reads
+till the enabled prompt, a failed commit stays at the configuration prompt.
+*/
+func (b *junosDevice) commitAndQuit(comment string) error {
+	if err := b.write("commit comment " + comment + " and-quit\n"); err != nil {
+		return err
+	}
+
+	val, err := b.ReadTill(b.RTC, []string{b.SSHEnabledPrompt, b.SSHConfigPrompt})
+	if err != nil {
+		return err
+	}
+
+	if !strings.Contains(val, "Exiting configuration mode") {
+		return fmt.Errorf("%s", strings.TrimSpace(val))
+	}
+	b.PromptMode = "sshEnabled"
+
+	return nil
+}
+
+func (b *junosDevice) commitCheck() error {
+	if err := b.write("commit check\n"); err != nil {
+		return err
+	}
+
+	val, err := b.ReadTill(b.RTC, []string{"configuration check succeeds", "error:"})
+	if err != nil {
+		return router.Errorf(router.ErrCommitFailed, b.RTC.Hostname, "Commit check not completed: %w", err)
+	}
+
+	/* read the rest of the output till the prompt returns */
+	rest, err := b.ReadTill(b.RTC, []string{b.SSHConfigPrompt})
+	if err != nil {
+		return router.Errorf(router.ErrPromptDetect, b.RTC.Hostname, "Cant find configure prompt after commit check: %w", err)
+	}
+
+	if !strings.Contains(val, "configuration check succeeds") {
+		return router.Errorf(router.ErrConfigRejected, b.RTC.Hostname, "Commit check failed: %s", strings.TrimSpace(val+rest))
+	}
+
+	return nil
+}
+
+/*
+runPostChecks runs the commands from PostCheckFile (or the ;-separated command line)
+from the configuration mode and fails on the first command that returns an error
+*/
+func (b *junosDevice) runPostChecks() error {
+	var postChecks = regexp.MustCompile(`(?i)(syntax error|unknown command|error:)`)
+
+	commands, err := postCheckCommands(b.RTC.PostCheckFile)
+	if err != nil {
+		return err
+	}
+
+	for _, line := range commands {
+		if err := b.write("run " + line + "\n"); err != nil {
+			return err
+		}
+
+		val, err := b.ReadTill(b.RTC, []string{b.SSHConfigPrompt})
+		if err != nil {
+			return fmt.Errorf("Post-check %s not completed: %w", line, err)
+		}
+		if b.RTC.Stream == nil {
+			fmt.Fprintf(b.RTC.W, "%s\n", val)
+		}
+
+		if postChecks.MatchString(val) {
+			return fmt.Errorf("Post-check %s failed", line)
+		}
+	}
+
+	return nil
+}
+
+/* postCheckCommands reads the post-check commands from a file or a ;-separated command line */
+func postCheckCommands(postCheckFile string) ([]string, error) {
+	var commands []string
+
+	if postCheckFile == "" {
+		return nil, nil
+	}
+
+	source, err := ioutil.ReadFile(postCheckFile)
+	if err != nil && os.IsNotExist(err) {
+		source = []byte(strings.Replace(postCheckFile, ";", "\n", -1))
+	} else if err != nil {
+		return nil, fmt.Errorf("Cant open post-check file: %s", err)
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(string(source)))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		commands = append(commands, line)
+	}
+
+	return commands, nil
+}
+
+/* commitComment quotes the comment for the Junos cli, defaults to "mlxsh change" */
+func commitComment(comment string) string {
+	if comment == "" {
+		comment = "mlxsh change"
+	}
+	return `"` + strings.Replace(comment, `"`, `\"`, -1) + `"`
+}
+
+/*
+PasteConfiguration loads the configuration with load set|merge|replace|override terminal,
+so whole stanzas in curly-brace, set or xml format can be pushed at once
+*/
 func (b *junosDevice) PasteConfiguration(configuration io.Reader) (err error) {
+	source, err := ioutil.ReadAll(configuration)
+	if err != nil {
+		return err
+	}
+
+	format := b.RTC.ConfigFormat
+	if format == "" {
+		format = DetectConfigFormat(string(source))
+	}
+
+	command, err := LoadTerminalCommand(format, b.RTC.LoadAction)
+	if err != nil {
+		return err
+	}
 
 	if err = b.SwitchMode("sshConfig"); err != nil {
 		return err
 	}
 
-	return b.Router.PasteConfiguration(b.RTC, configuration)
+	if err := b.write(command + "\n"); err != nil {
+		return err
+	}
 
+	if _, err = b.ReadTill(b.RTC, []string{"^D at a new line to end input"}); err != nil {
+		return router.Errorf(router.ErrPromptDetect, b.RTC.Hostname, "Cant start loading from terminal: %w", err)
+	}
+
+	input := strings.TrimRight(string(source), "\n") + "\n"
+	if err := b.write(input); err != nil {
+		return err
+	}
+
+	/* Ctrl-D on a new line ends the terminal input */
+	if err := b.write("\x04"); err != nil {
+		return err
+	}
+
+	val, err := b.ReadTill(b.RTC, []string{"load complete"})
+	if err != nil {
+		return router.Errorf(router.ErrConfigRejected, b.RTC.Hostname, "Load not completed: %w", err)
+	}
+
+	if b.RTC.Debug {
+		fmt.Fprintf(b.RTC.W, "Captured %s\n", val)
+	}
+
+	if _, err = b.ReadTill(b.RTC, []string{b.SSHConfigPrompt}); err != nil {
+		return router.Errorf(router.ErrPromptDetect, b.RTC.Hostname, "Cant find configure prompt after load: %w", err)
+	}
+
+	if b.ErrorMatches.MatchString(val) {
+		if err := b.rollback(); err != nil && b.RTC.Debug {
+			fmt.Fprintf(b.RTC.W, "%s\n", err)
+		}
+		return router.Errorf(router.ErrConfigRejected, b.RTC.Hostname, "Invalid configuration, %s failed: %s", command, strings.TrimSpace(val))
+	}
+
+	fmt.Fprint(b.RTC.W, "+\n")
+
+	return nil
+}
+
+/*
+DetectConfigFormat guesses the format of a Junos configuration from its first
+statement: xml for an element, set for set-style commands, else text (curly-brace)
+*/
+func DetectConfigFormat(configuration string) string {
+	scanner := bufio.NewScanner(strings.NewReader(configuration))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "<") {
+			return "xml"
+		}
+
+		switch strings.Fields(line)[0] {
+		case "set", "delete", "activate", "deactivate", "rename", "insert", "annotate", "protect", "unprotect", "copy", "replace":
+			return "set"
+		}
+
+		return "text"
+	}
+
+	return "set"
+}
+
+/*
+LoadTerminalCommand returns the load command for a configuration format (set, text, xml)
+and a load action (merge, replace, override), e.g. load replace terminal
+*/
+func LoadTerminalCommand(format, action string) (string, error) {
+	action, err := loadAction(format, action)
+	if err != nil {
+		return "", err
+	}
+
+	if format == "set" {
+		return "load set terminal", nil
+	}
+	return "load " + action + " terminal", nil
+}
+
+/*
+loadAction checks the load action for a configuration format, defaults to merge.
+Set commands can only be merged, the cli and the NETCONF driver both reject anything else.
+*/
+func loadAction(format, action string) (string, error) {
+	if action == "" {
+		action = "merge"
+	}
+
+	switch action {
+	case "merge", "replace", "override", "update":
+	default:
+		return "", fmt.Errorf("Unknown load action: %s", action)
+	}
+
+	switch format {
+	case "set":
+		if action != "merge" {
+			return "", fmt.Errorf("Load action %s needs text or xml format", action)
+		}
+	case "text", "xml":
+	default:
+		return "", fmt.Errorf("Unknown configuration format: %s", format)
+	}
+
+	return action, nil
 }
 
 func (b *junosDevice) RunCommands(commands io.Reader) (err error) {
 	if err = b.SwitchMode("sshEnabled"); err != nil {
-		return fmt.Errorf("Cant switch to privileged mode: %s", err)
+		return fmt.Errorf("Cant switch to privileged mode: %w", err)
 	}
 
 	return b.Router.RunCommands(b.RTC, commands)
 }
 
+/*DryRun writes the command stream for exec or config mode without connecting */
+func (b *junosDevice) DryRun(execMode bool, input io.Reader) error {
+	return router.DryRunSteps(b, execMode, input)
+}
+
+/*DryRunExec writes the commands, that RunCommands would send */
+func (b *junosDevice) DryRunExec(commands io.Reader) error {
+	return b.DryRunCommands(b.RTC, commands)
+}
+
+/*DryRunLogin writes the connection and the cli settings after the login */
+func (b *junosDevice) DryRunLogin() {
+	b.DryRunConnect(b.RTC)
+	b.DryRunMode(b.RTC, "set cli screen-length 0")
+}
+
+/*DryRunPaste writes the load command and the configuration */
+func (b *junosDevice) DryRunPaste(configuration io.Reader) error {
+	source, err := ioutil.ReadAll(configuration)
+	if err != nil {
+		return err
+	}
+
+	format := b.RTC.ConfigFormat
+	if format == "" {
+		format = DetectConfigFormat(string(source))
+	}
+
+	command, err := LoadTerminalCommand(format, b.RTC.LoadAction)
+	if err != nil {
+		return err
+	}
+
+	b.DryRunMode(b.RTC, "edit", command)
+	if err := b.DryRunConfiguration(b.RTC, strings.NewReader(string(source))); err != nil {
+		return err
+	}
+	b.DryRunMode(b.RTC, "^D")
+
+	return nil
+}
+
+/*DryRunCommit writes the commit check, the confirmed commit with its post-checks and the commit */
+func (b *junosDevice) DryRunCommit() error {
+	b.DryRunMode(b.RTC, "commit check")
+
+	comment := commitComment(b.RTC.CommitComment)
+	if b.RTC.ConfirmMinutes > 0 {
+		b.DryRunMode(b.RTC, fmt.Sprintf("commit confirmed %d comment %s", b.RTC.ConfirmMinutes, comment))
+
+		commands, err := postCheckCommands(b.RTC.PostCheckFile)
+		if err != nil {
+			return err
+		}
+		for _, command := range commands {
+			b.DryRunMode(b.RTC, "run "+command)
+		}
+	}
+	b.DryRunMode(b.RTC, "commit comment "+comment+" and-quit")
+
+	return nil
+}
+
 func (b *junosDevice) Close() {
 	b.Router.Close()
 }
@@ -1,10 +1,15 @@
 package junosDevice_test
 
 import (
+	"bufio"
 	"bytes"
+	"errors"
 	"github.com/ipcjk/mlxsh/junosDevice"
 	"github.com/ipcjk/mlxsh/libhost"
+	"github.com/ipcjk/mlxsh/libnetconf"
 	"github.com/ipcjk/mlxsh/routerDevice"
+	"io"
+	"strings"
 	"testing"
 	"time"
 )
@@ -81,3 +86,371 @@ func TestDetectPrompt(t *testing.T) {
 	}
 
 }
+
+func TestNetconfConnect(t *testing.T) {
+	var Config = libhost.HostConfig{
+		DeviceType: "junos",
+		Transport:  "netconf",
+		Hostname:   "localhost",
+		Username:   "user",
+		Password:   "password",
+		SSHPort:    9131,
+	}
+
+	singleRouter := junosDevice.JunosNetconfDevice(
+		router.RunTimeConfig{HostConfig: Config, Debug: true, W: new(bytes.Buffer)})
+
+	if singleRouter.RTC.ReadTimeout < time.Second*15 {
+		t.Error("ReadTimeout for NETCONF commits is too short")
+	}
+
+	if err := singleRouter.Connect(); err == nil {
+		t.Error("Logged into localhost with default settings, this cant be true!")
+	}
+
+}
+
+func TestDetectConfigFormat(t *testing.T) {
+	var formats = map[string]string{
+		"# comment\nset system host-name rt1\n":                      "set",
+		"delete interfaces ge-0/0/1 disable":                         "set",
+		"system {\n    host-name rt1;\n}\n":                          "text",
+		"interfaces {\n ge-0/0/1 {\n  disable;\n }\n}":               "text",
+		"<configuration><system><host-name>rt1</host-name></system>": "xml",
+		"": "set",
+	}
+
+	for configuration, format := range formats {
+		if detected := junosDevice.DetectConfigFormat(configuration); detected != format {
+			t.Errorf("Detected %s instead of %s for %q", detected, format, configuration)
+		}
+	}
+}
+
+func TestLoadTerminalCommand(t *testing.T) {
+	var commands = []struct{ format, action, command string }{
+		{"set", "", "load set terminal"},
+		{"text", "", "load merge terminal"},
+		{"text", "replace", "load replace terminal"},
+		{"text", "override", "load override terminal"},
+		{"xml", "merge", "load merge terminal"},
+	}
+
+	for _, c := range commands {
+		command, err := junosDevice.LoadTerminalCommand(c.format, c.action)
+		if err != nil || command != c.command {
+			t.Errorf("Expected %s for %s/%s, got %s (%v)", c.command, c.format, c.action, command, err)
+		}
+	}
+
+	if _, err := junosDevice.LoadTerminalCommand("set", "override"); err == nil {
+		t.Error("Override with set format should not be possible")
+	}
+
+	if _, err := junosDevice.LoadTerminalCommand("json", ""); err == nil {
+		t.Error("Unknown format accepted")
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	var buffer = new(bytes.Buffer)
+	var Config = libhost.HostConfig{
+		DeviceType:     "junos",
+		Hostname:       "mx1",
+		Username:       "noc",
+		ConfirmMinutes: 5,
+		CommitComment:  "CHG-4711",
+		PostCheckFile:  "show bgp summary",
+	}
+
+	singleRouter := junosDevice.JunosDevice(router.RunTimeConfig{HostConfig: Config, W: buffer})
+
+	if err := singleRouter.DryRun(false, strings.NewReader("system {\n host-name mx1;\n}\n")); err != nil {
+		t.Errorf("Dry-run failed: %s", err)
+	}
+
+	for _, expected := range []string{"[mode] load merge terminal", "+ system {", "[mode] commit check",
+		"[mode] commit confirmed 5 comment \"CHG-4711\"", "[mode] run show bgp summary", "[mode] commit comment \"CHG-4711\" and-quit"} {
+		if !strings.Contains(buffer.String(), expected) {
+			t.Errorf("Dry-run output is missing %q: %s", expected, buffer.String())
+		}
+	}
+}
+
+/*
+netconfServer answers every rpc with ok in base:1.0 framing and records it,
+rpcs containing fail get an rpc-error
+*/
+func netconfServer(fail string) (*libnetconf.Session, *[]string) {
+	clientReader, serverWriter := io.Pipe()
+	serverReader, clientWriter := io.Pipe()
+	rpcs := new([]string)
+
+	go func() {
+		r := bufio.NewReader(serverReader)
+		for {
+			var message string
+			for !strings.HasSuffix(message, "]]>]]>") {
+				b, err := r.ReadByte()
+				if err != nil {
+					return
+				}
+				message += string(b)
+			}
+			*rpcs = append(*rpcs, message)
+
+			reply := "<ok/>"
+			if fail != "" && strings.Contains(message, fail) {
+				reply = "<rpc-error><error-severity>error</error-severity><error-message>" + fail + " failed</error-message></rpc-error>"
+			}
+			io.WriteString(serverWriter, "<rpc-reply>"+reply+"</rpc-reply>]]>]]>")
+		}
+	}()
+
+	return libnetconf.NewSession(clientReader, clientWriter), rpcs
+}
+
+func TestNetconfRollback(t *testing.T) {
+	for _, fail := range []string{"", "rollback=", "mlxsh rollback"} {
+		singleRouter := junosDevice.JunosNetconfDevice(router.RunTimeConfig{
+			HostConfig: libhost.HostConfig{Hostname: "mx1"}, W: new(bytes.Buffer)})
+
+		var rpcs *[]string
+		singleRouter.Netconf, rpcs = netconfServer(fail)
+
+		err := singleRouter.Rollback()
+		if fail == "" && err != nil {
+			t.Errorf("Rollback failed: %s", err)
+		}
+		if fail != "" && !errors.Is(err, router.ErrCommitFailed) {
+			t.Errorf("Failed rollback at %s is not a commit error: %v", fail, err)
+		}
+
+		if last := (*rpcs)[len(*rpcs)-1]; !strings.Contains(last, "<unlock>") {
+			t.Errorf("Candidate not unlocked after rollback with %q: %s", fail, last)
+		}
+	}
+}
+
+/*
+junosTerminal fakes a Junos terminal, that sends the answers of the script for a line
+one after the other. Lines end with a newline or a Ctrl-D, the received lines are recorded.
+*/
+func junosTerminal(t *testing.T, script func(line string) []string) (io.WriteCloser, io.Reader, *[]string) {
+	deviceReader, stdin := io.Pipe()
+	stdout, deviceWriter := io.Pipe()
+	lines := new([]string)
+
+	t.Cleanup(func() {
+		stdin.Close()
+		deviceWriter.Close()
+	})
+
+	go func() {
+		r := bufio.NewReader(deviceReader)
+		var line string
+		for {
+			b, err := r.ReadByte()
+			if err != nil {
+				return
+			}
+			line += string(b)
+			if b != '\n' && b != '\x04' {
+				continue
+			}
+			*lines = append(*lines, line)
+
+			for _, answer := range script(line) {
+				io.WriteString(deviceWriter, answer)
+			}
+			line = ""
+		}
+	}()
+
+	return stdin, stdout, lines
+}
+
+/*
+loadTerminal fakes a Junos configuration terminal, it answers the Ctrl-D at the end of
+the input with the echoed input and the diagnostic
+*/
+func loadTerminal(t *testing.T, diagnostic string) (io.WriteCloser, io.Reader, *[]string) {
+	var echo string
+
+	return junosTerminal(t, func(line string) []string {
+		switch {
+		case line == "\x04":
+			return []string{echo + diagnostic + "load complete\n", "\n[edit]\nnoc@mx1# "}
+		case strings.HasPrefix(line, "load "):
+			return []string{line + "[Type ^D at a new line to end input]\n"}
+		case strings.HasPrefix(line, "rollback"):
+			return []string{line + "load complete\n\n[edit]\nnoc@mx1# "}
+		}
+		echo += line
+		return nil
+	})
+}
+
+func TestPasteConfiguration(t *testing.T) {
+	var configuration = "set interfaces ge-0/0/0 description \"Error: unknown peer not found\"\n"
+	var diagnostics = []struct {
+		diagnostic string
+		rejected   bool
+	}{
+		{"", false},
+		{"terminal:1:(5) syntax error: interfacs\n", true},
+		{"  [edit interfaces]\n    'ge-0/0/99'\n      syntax error\n", true},
+		{"error: configuration database locked by root\n", true},
+		{"warning: statement not found\n", true},
+	}
+
+	for _, d := range diagnostics {
+		var lines *[]string
+		singleRouter := junosDevice.JunosDevice(router.RunTimeConfig{
+			HostConfig: libhost.HostConfig{Hostname: "mx1"}, W: new(bytes.Buffer)})
+		singleRouter.SSHStdinPipe, singleRouter.SSHStdoutPipe, lines = loadTerminal(t, d.diagnostic)
+		singleRouter.SSHConfigPrompt = "noc@mx1#"
+		singleRouter.PromptMode = "sshConfig"
+
+		err := singleRouter.PasteConfiguration(strings.NewReader(configuration))
+		if !d.rejected && err != nil {
+			t.Errorf("Configuration with %q rejected: %s", d.diagnostic, err)
+		}
+		if d.rejected && !errors.Is(err, router.ErrConfigRejected) {
+			t.Errorf("Diagnostic %q not rejected: %v", d.diagnostic, err)
+		}
+
+		rolledBack := strings.HasPrefix((*lines)[len(*lines)-1], "rollback")
+		if rolledBack != d.rejected {
+			t.Errorf("Rollback %t after diagnostic %q", rolledBack, d.diagnostic)
+		}
+	}
+}
+
+func TestSetFormatLoadAction(t *testing.T) {
+	var configuration = "set system host-name mx1\n"
+
+	for _, action := range []string{"replace", "override"} {
+		var lines *[]string
+		cli := junosDevice.JunosDevice(router.RunTimeConfig{
+			HostConfig: libhost.HostConfig{Hostname: "mx1", LoadAction: action}, W: new(bytes.Buffer)})
+		cli.SSHStdinPipe, cli.SSHStdoutPipe, lines = loadTerminal(t, "")
+		cli.SSHConfigPrompt = "noc@mx1#"
+		cli.PromptMode = "sshConfig"
+
+		if err := cli.PasteConfiguration(strings.NewReader(configuration)); err == nil {
+			t.Errorf("cli loaded set commands with %s", action)
+		}
+		if len(*lines) > 0 {
+			t.Errorf("cli sent %q for set commands with %s", *lines, action)
+		}
+
+		var rpcs *[]string
+		netconf := junosDevice.JunosNetconfDevice(router.RunTimeConfig{
+			HostConfig: libhost.HostConfig{Hostname: "mx1", LoadAction: action}, W: new(bytes.Buffer)})
+		netconf.Netconf, rpcs = netconfServer("")
+
+		if err := netconf.PasteConfiguration(strings.NewReader(configuration)); err == nil {
+			t.Errorf("NETCONF loaded set commands with %s", action)
+		}
+		if len(*rpcs) > 0 {
+			t.Errorf("NETCONF sent %q for set commands with %s", *rpcs, action)
+		}
+	}
+}
+
+func TestConfirmCheck(t *testing.T) {
+	checkFailed := errors.New("post-check bgp failed")
+
+	for _, checkErr := range []error{nil, checkFailed} {
+		var rpcs *[]string
+		var checked bool
+		singleRouter := junosDevice.JunosNetconfDevice(router.RunTimeConfig{
+			HostConfig: libhost.HostConfig{Hostname: "mx1", ConfirmMinutes: 5}, W: new(bytes.Buffer)})
+		singleRouter.Netconf, rpcs = netconfServer("")
+		singleRouter.SetConfirmCheck(func() error {
+			checked = true
+			return checkErr
+		})
+
+		err := singleRouter.CommitConfiguration()
+		if !checked {
+			t.Error("Confirm check did not run")
+		}
+		if !errors.Is(err, checkErr) {
+			t.Errorf("Expected %v, got %v", checkErr, err)
+		}
+
+		var commits int
+		for _, rpc := range *rpcs {
+			if strings.Contains(rpc, "<commit-configuration>") {
+				commits++
+			}
+		}
+		if checkErr == nil && commits != 2 {
+			t.Errorf("Commit not confirmed after the check: %d commits", commits)
+		}
+		if checkErr != nil && commits != 1 {
+			t.Errorf("Commit confirmed after a failed check: %d commits", commits)
+		}
+	}
+}
+
+func TestCLIConfirmCheck(t *testing.T) {
+	var lines *[]string
+	singleRouter := junosDevice.JunosDevice(router.RunTimeConfig{
+		HostConfig: libhost.HostConfig{Hostname: "mx1", ConfirmMinutes: 5}, W: new(bytes.Buffer)})
+	singleRouter.SSHStdinPipe, singleRouter.SSHStdoutPipe, lines = junosTerminal(t, func(line string) []string {
+		command := strings.TrimSpace(line)
+		switch {
+		case command == "commit check":
+			return []string{line + "configuration check succeeds\n", "\n[edit]\nnoc@mx1# "}
+		case strings.HasPrefix(command, "commit confirmed"):
+			return []string{line + "commit confirmed will be automatically rolled back in 5 minutes unless confirmed\ncommit complete\n", "\n[edit]\nnoc@mx1# "}
+		case command == "exit configuration-mode":
+			return []string{line + "Exiting configuration mode\n\nnoc@mx1> "}
+		case command == "show bgp summary":
+			return []string{line + "192.0.2.1  65001  Establ\n\nnoc@mx1> "}
+		case command == "show route summary":
+			return []string{line + "inet.0: 812345 destinations\n\nnoc@mx1> "}
+		case command == "edit":
+			return []string{line + "Entering configuration mode\n\n[edit]\nnoc@mx1# "}
+		case strings.HasSuffix(command, "and-quit"):
+			return []string{line + "commit complete\nExiting configuration mode\n\nnoc@mx1> "}
+		}
+		return []string{line + "\nnoc@mx1# "}
+	})
+	singleRouter.SSHEnabledPrompt = "noc@mx1>"
+	singleRouter.SSHConfigPrompt = "noc@mx1#"
+	singleRouter.PromptMode = "sshConfig"
+
+	var outputs []router.CommandOutput
+	singleRouter.SetConfirmCheck(func() error {
+		err := singleRouter.RunCommands(strings.NewReader("show bgp summary\nshow route summary\n"))
+		outputs = singleRouter.CommandOutputs()
+		return err
+	})
+
+	/* a missed prompt blocks the read of the pipe, so give up after a while */
+	done := make(chan error, 1)
+	go func() {
+		done <- singleRouter.CommitConfiguration()
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Commit failed: %s", err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("Commit hangs, a prompt was not read")
+	}
+
+	if len(outputs) != 2 || outputs[0].Output != "192.0.2.1  65001  Establ" || outputs[1].Output != "inet.0: 812345 destinations" {
+		t.Errorf("Confirm check read the wrong output: %+v", outputs)
+	}
+
+	if last := (*lines)[len(*lines)-1]; !strings.HasSuffix(last, "and-quit\n") || singleRouter.PromptMode != "sshEnabled" {
+		t.Errorf("Commit not confirmed from the configuration mode: %q in %s", last, singleRouter.PromptMode)
+	}
+}
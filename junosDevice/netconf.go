@@ -0,0 +1,324 @@
+package junosDevice
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/ipcjk/mlxsh/libnetconf"
+	"github.com/ipcjk/mlxsh/routerDevice"
+)
+
+type junosNetconfDevice struct {
+	RTC router.RunTimeConfig
+	router.Router
+	Netconf      *libnetconf.Session
+	locked       bool
+	confirmCheck func() error
+}
+
+/*
+JunosNetconfDevice returns a new junosNetconfDevice object, that talks NETCONF
+over the SSH netconf subsystem instead of scraping the cli
+*/
+func JunosNetconfDevice(Config router.RunTimeConfig) *junosNetconfDevice {
+	/* Fill our config with defaults for ssh and timesouts */
+	router.GenerateDefaults(&Config)
+
+	/* commits on bigger boxes take their time */
+	if Config.ReadTimeout < time.Second*15 {
+		Config.ReadTimeout = time.Second * 15
+	}
+
+	return &junosNetconfDevice{
+		RTC: Config,
+		Router: router.Router{
+			CommandRewrite: junosCommandRewrite,
+			PromptModes:    make(map[string]string),
+		}}
+}
+
+func (b *junosNetconfDevice) Connect() (err error) {
+	if err = b.DialSSH(b.RTC); err != nil {
+		return err
+	}
+
+	if b.SSHSession, err = b.SSHConnection.NewSession(); err != nil {
+		return err
+	}
+
+	if b.SSHStdinPipe, err = b.SSHSession.StdinPipe(); err != nil {
+		return err
+	}
+
+	if b.SSHStdoutPipe, err = b.SSHSession.StdoutPipe(); err != nil {
+		return err
+	}
+
+	if err = b.SSHSession.RequestSubsystem("netconf"); err != nil {
+		return router.Errorf(router.ErrDial, b.RTC.Hostname, "request for netconf subsystem failed: %w", err)
+	}
+
+	b.Netconf = libnetconf.NewSession(b.SSHStdoutPipe, b.SSHStdinPipe)
+	b.Netconf.Timeout = b.RTC.ReadTimeout
+	b.Netconf.Closer = b.SSHConnection
+
+	if err = b.Netconf.Hello(); err != nil {
+		return router.Errorf(router.ErrDial, b.RTC.Hostname, "NETCONF hello failed: %w", err)
+	}
+
+	if b.RTC.Debug {
+		fmt.Fprintf(b.RTC.W, "NETCONF session %d with %d capabilities\n", b.Netconf.SessionID, len(b.Netconf.Capabilities))
+	}
+
+	return
+}
+
+func (b *junosNetconfDevice) ConfigureTerminalMode() error {
+	if err := b.Netconf.Lock("candidate"); err != nil {
+		return router.Errorf(router.ErrConfigRejected, b.RTC.Hostname, "Cant lock candidate configuration: %w", err)
+	}
+	b.locked = true
+
+	if b.RTC.Debug {
+		fmt.Fprint(b.RTC.W, "Configuration mode on")
+	}
+	return nil
+}
+
+func (b *junosNetconfDevice) PasteConfiguration(configuration io.Reader) (err error) {
+	source, err := ioutil.ReadAll(configuration)
+	if err != nil {
+		return err
+	}
+
+	format := b.RTC.ConfigFormat
+	if format == "" {
+		format = DetectConfigFormat(string(source))
+	}
+
+	action, err := loadAction(format, b.RTC.LoadAction)
+	if err != nil {
+		return err
+	}
+
+	if err = b.Netconf.LoadConfiguration(format, action, string(source)); err != nil {
+		b.discard()
+		return router.Errorf(router.ErrConfigRejected, b.RTC.Hostname, "Invalid configuration: %w", err)
+	}
+
+	fmt.Fprint(b.RTC.W, "+\n")
+
+	return nil
+}
+
+/*
+CommitConfiguration validates the candidate first, with ConfirmMinutes set it commits
+confirmed, runs the post-checks and the confirm check and only then confirms the commit
+*/
+func (b *junosNetconfDevice) CommitConfiguration() (err error) {
+	comment := b.RTC.CommitComment
+	if comment == "" {
+		comment = "mlxsh change"
+	}
+
+	if err = b.Netconf.Validate("candidate"); err != nil {
+		b.discard()
+		return router.Errorf(router.ErrConfigRejected, b.RTC.Hostname, "Commit check failed: %w", err)
+	}
+
+	if b.RTC.ConfirmMinutes > 0 {
+		if err = b.Netconf.CommitConfiguration(b.RTC.ConfirmMinutes, comment); err != nil {
+			b.discard()
+			return router.Errorf(router.ErrCommitFailed, b.RTC.Hostname, "Commit confirmed not completed or not successful: %w", err)
+		}
+
+		if err = b.runPostChecks(); err != nil {
+			b.unlock()
+			return fmt.Errorf("%w, configuration will be rolled back by Junos in %d minutes", err, b.RTC.ConfirmMinutes)
+		}
+
+		if b.confirmCheck != nil {
+			if err = b.confirmCheck(); err != nil {
+				b.unlock()
+				return fmt.Errorf("%w, configuration will be rolled back by Junos in %d minutes", err, b.RTC.ConfirmMinutes)
+			}
+		}
+	}
+
+	if err = b.Netconf.CommitConfiguration(0, comment); err != nil {
+		b.discard()
+		return router.Errorf(router.ErrCommitFailed, b.RTC.Hostname, "Commit not completed or not successful: %w", err)
+	}
+
+	return b.unlock()
+}
+
+/*SetConfirmCheck sets a check, that runs after the post-checks of a commit confirmed */
+func (b *junosNetconfDevice) SetConfirmCheck(check func() error) {
+	b.confirmCheck = check
+}
+
+/*Rollback loads and commits the previous configuration, e.g. after failed post-checks */
+func (b *junosNetconfDevice) Rollback() (err error) {
+	if err = b.Netconf.Lock("candidate"); err != nil {
+		return router.Errorf(router.ErrCommitFailed, b.RTC.Hostname, "Cant lock candidate configuration for rollback: %w", err)
+	}
+	b.locked = true
+
+	if err = b.Netconf.LoadRollback(1); err != nil {
+		b.discard()
+		return router.Errorf(router.ErrCommitFailed, b.RTC.Hostname, "Cant load rollback 1: %w", err)
+	}
+
+	if err = b.Netconf.CommitConfiguration(0, "mlxsh rollback"); err != nil {
+		b.discard()
+		return router.Errorf(router.ErrCommitFailed, b.RTC.Hostname, "Rollback commit not successful: %w", err)
+	}
+
+	return b.unlock()
+}
+
+func (b *junosNetconfDevice) runPostChecks() error {
+	commands, err := postCheckCommands(b.RTC.PostCheckFile)
+	if err != nil {
+		return err
+	}
+
+	for _, line := range commands {
+		val, err := b.Netconf.Command(line)
+		if err != nil {
+			return fmt.Errorf("Post-check %s failed: %w", line, err)
+		}
+		fmt.Fprintf(b.RTC.W, "%s\n", val)
+	}
+
+	return nil
+}
+
+/*RunCommands runs cli commands through the command rpc, lines starting with < are sent as raw rpc */
+func (b *junosNetconfDevice) RunCommands(commands io.Reader) (err error) {
+	var failed []string
+
+	scanner := bufio.NewScanner(commands)
+	for scanner.Scan() {
+		var val string
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		line = b.RewriteCommand(line)
+
+		if strings.HasPrefix(line, "<") {
+			val, err = b.Netconf.Exec(line)
+		} else {
+			val, err = b.Netconf.Command(line)
+		}
+		if err != nil {
+			err = router.Errorf(router.ErrCommandFailed, b.RTC.Hostname, "Command %s failed: %w", line, err)
+		}
+		b.RecordOutput(line, val, err)
+		if err != nil && b.RTC.ErrorPolicy != router.PolicyContinue {
+			return err
+		} else if err != nil {
+			fmt.Fprintf(b.RTC.W, "%s\n", err)
+			failed = append(failed, line)
+			continue
+		}
+		fmt.Fprintf(b.RTC.W, "%s\n", val)
+	}
+
+	if len(failed) > 0 {
+		return router.Errorf(router.ErrCommandFailed, b.RTC.Hostname, "%d commands failed: %s", len(failed), strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+/*DryRun writes the rpcs for exec or config mode without connecting */
+func (b *junosNetconfDevice) DryRun(execMode bool, input io.Reader) error {
+	return router.DryRunSteps(b, execMode, input)
+}
+
+/*DryRunExec writes the commands, that RunCommands would send */
+func (b *junosNetconfDevice) DryRunExec(commands io.Reader) error {
+	return b.DryRunCommands(b.RTC, commands)
+}
+
+/*DryRunLogin writes the connection and the hello */
+func (b *junosNetconfDevice) DryRunLogin() {
+	b.DryRunConnect(b.RTC)
+	b.DryRunMode(b.RTC, "<hello>")
+}
+
+/*DryRunPaste writes the lock and the load of the configuration */
+func (b *junosNetconfDevice) DryRunPaste(configuration io.Reader) error {
+	source, err := ioutil.ReadAll(configuration)
+	if err != nil {
+		return err
+	}
+
+	format := b.RTC.ConfigFormat
+	if format == "" {
+		format = DetectConfigFormat(string(source))
+	}
+
+	action, err := loadAction(format, b.RTC.LoadAction)
+	if err != nil {
+		return err
+	}
+	if format == "set" {
+		action = "set"
+	}
+
+	b.DryRunMode(b.RTC, "<lock> candidate", fmt.Sprintf("<load-configuration format=%q action=%q>", format, action))
+	return b.DryRunConfiguration(b.RTC, strings.NewReader(string(source)))
+}
+
+/*DryRunCommit writes the validation, the confirmed commit with its post-checks, the commit and the unlock */
+func (b *junosNetconfDevice) DryRunCommit() error {
+	b.DryRunMode(b.RTC, "<validate> candidate")
+
+	if b.RTC.ConfirmMinutes > 0 {
+		b.DryRunMode(b.RTC, fmt.Sprintf("<commit-configuration> confirmed %d minutes", b.RTC.ConfirmMinutes))
+
+		commands, err := postCheckCommands(b.RTC.PostCheckFile)
+		if err != nil {
+			return err
+		}
+		for _, command := range commands {
+			b.DryRunMode(b.RTC, "<command> "+command)
+		}
+	}
+	b.DryRunMode(b.RTC, "<commit-configuration>", "<unlock> candidate")
+
+	return nil
+}
+
+/* discard throws away the candidate changes after a failure and releases the lock */
+func (b *junosNetconfDevice) discard() {
+	if err := b.Netconf.DiscardChanges(); err != nil && b.RTC.Debug {
+		fmt.Fprintf(b.RTC.W, "Cant discard changes: %s\n", err)
+	}
+	b.unlock()
+}
+
+func (b *junosNetconfDevice) unlock() error {
+	if !b.locked {
+		return nil
+	}
+	b.locked = false
+	return b.Netconf.Unlock("candidate")
+}
+
+func (b *junosNetconfDevice) Close() {
+	if b.Netconf != nil {
+		b.unlock()
+		b.Netconf.CloseSession()
+	}
+	b.Router.Close()
+}
@@ -0,0 +1,266 @@
+package libcheck
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v1"
+)
+
+/*
+Check runs an exec command and matches its output. Match is a regular
+expression, its first capture group is the value of the check, with Count
+the number of matches is the value. Expect compares the value, e.g. ">= 4",
+"== Established" or "absent". Compare is only used for post checks and
+compares with the pre check of the same name: no-drop, no-increase or equal.
+*/
+type Check struct {
+	Name    string `yaml:"name"`
+	Command string `yaml:"command"`
+	Match   string `yaml:"match"`
+	Count   bool   `yaml:"count"`
+	Expect  string `yaml:"expect"`
+	Compare string `yaml:"compare"`
+
+	pattern *regexp.Regexp
+}
+
+/*Bundle is a set of checks, that run before and after a change */
+type Bundle struct {
+	PreChecks  []Check `yaml:"pre_checks"`
+	PostChecks []Check `yaml:"post_checks"`
+}
+
+/*Result is the outcome of a single check */
+type Result struct {
+	Name    string
+	Command string
+	Value   string
+	Passed  bool
+	Reason  string
+}
+
+var operators = []string{">=", "<=", "==", "!=", ">", "<"}
+
+/*LoadBundle reads a check bundle from a yaml reader source and validates it */
+func LoadBundle(r io.Reader) (Bundle, error) {
+	var bundle Bundle
+
+	source, err := ioutil.ReadAll(r)
+	if err != nil {
+		return bundle, fmt.Errorf("Cant read from yaml source: %s", err)
+	}
+
+	if err = yaml.Unmarshal(source, &bundle); err != nil {
+		return bundle, fmt.Errorf("Cant parse yaml source: %s", err)
+	}
+
+	for x := range bundle.PreChecks {
+		if err := bundle.PreChecks[x].Compile(); err != nil {
+			return bundle, err
+		}
+		if bundle.PreChecks[x].Compare != "" {
+			return bundle, fmt.Errorf("Check %s: compare is only possible for post checks", bundle.PreChecks[x].Name)
+		}
+	}
+
+	for x := range bundle.PostChecks {
+		if err := bundle.PostChecks[x].Compile(); err != nil {
+			return bundle, err
+		}
+	}
+
+	return bundle, nil
+}
+
+/*Compile validates the check and compiles its regular expression */
+func (c *Check) Compile() (err error) {
+	if c.Command == "" {
+		return fmt.Errorf("Check %s has no command", c.Name)
+	}
+
+	if c.Name == "" {
+		c.Name = c.Command
+	}
+
+	if c.Match == "" {
+		return fmt.Errorf("Check %s has no match", c.Name)
+	}
+
+	if c.pattern, err = regexp.Compile(c.Match); err != nil {
+		return fmt.Errorf("Check %s has no valid match: %s", c.Name, err)
+	}
+
+	if c.Expect != "" && c.Expect != "present" && c.Expect != "absent" {
+		if op, _ := splitExpect(c.Expect); op == "" {
+			return fmt.Errorf("Check %s has no valid expect: %s", c.Name, c.Expect)
+		}
+	}
+
+	switch c.Compare {
+	case "", "no-drop", "no-increase", "equal":
+	default:
+		return fmt.Errorf("Check %s has no valid compare: %s, use no-drop, no-increase or equal", c.Name, c.Compare)
+	}
+
+	return nil
+}
+
+/* splitExpect splits "<op> <value>" into operator and value */
+func splitExpect(expect string) (string, string) {
+	expect = strings.TrimSpace(expect)
+	for _, op := range operators {
+		if strings.HasPrefix(expect, op) {
+			return op, strings.TrimSpace(strings.TrimPrefix(expect, op))
+		}
+	}
+	return "", ""
+}
+
+/* number parses a value like 1,024 or 12.5 */
+func number(value string) (float64, bool) {
+	n, err := strconv.ParseFloat(strings.Replace(strings.TrimSpace(value), ",", "", -1), 64)
+	return n, err == nil
+}
+
+/* compare applies the operator on two values, numbers are compared as numbers */
+func compare(value, op, expected string) bool {
+	a, aNumeric := number(value)
+	b, bNumeric := number(expected)
+
+	if aNumeric && bNumeric {
+		switch op {
+		case ">=":
+			return a >= b
+		case "<=":
+			return a <= b
+		case "==":
+			return a == b
+		case "!=":
+			return a != b
+		case ">":
+			return a > b
+		case "<":
+			return a < b
+		}
+	}
+
+	switch op {
+	case "==":
+		return value == expected
+	case "!=":
+		return value != expected
+	}
+	return false
+}
+
+/*Evaluate matches the command output of a check and returns its result */
+func Evaluate(c Check, output string) Result {
+	result := Result{Name: c.Name, Command: c.Command}
+
+	if c.pattern == nil {
+		if err := c.Compile(); err != nil {
+			result.Reason = err.Error()
+			return result
+		}
+	}
+
+	matches := c.pattern.FindAllStringSubmatch(output, -1)
+
+	switch {
+	case c.Count:
+		result.Value = strconv.Itoa(len(matches))
+	case len(matches) > 0 && len(matches[0]) > 1:
+		result.Value = strings.TrimSpace(matches[0][1])
+	case len(matches) > 0:
+		result.Value = "present"
+	default:
+		result.Value = "absent"
+	}
+
+	switch c.Expect {
+	case "":
+		result.Passed = c.Count || len(matches) > 0
+		if !result.Passed {
+			result.Reason = "no match for " + c.Match
+		}
+	case "present", "absent":
+		result.Passed = (len(matches) > 0) == (c.Expect == "present")
+		if !result.Passed {
+			result.Reason = "expected " + c.Expect
+		}
+	default:
+		op, expected := splitExpect(c.Expect)
+		result.Passed = len(matches) > 0 || c.Count
+		result.Passed = result.Passed && compare(result.Value, op, expected)
+		if !result.Passed {
+			result.Reason = "expected " + c.Expect
+		}
+	}
+
+	return result
+}
+
+/*CompareResults checks a post result against the pre result of the same check */
+func CompareResults(c Check, pre, post Result) Result {
+	if c.Compare == "" || !post.Passed {
+		return post
+	}
+
+	var op string
+	switch c.Compare {
+	case "no-drop":
+		op = ">="
+	case "no-increase":
+		op = "<="
+	case "equal":
+		op = "=="
+	}
+
+	if !compare(post.Value, op, pre.Value) {
+		post.Passed = false
+		post.Reason = fmt.Sprintf("%s: was %s before", c.Compare, pre.Value)
+	}
+
+	return post
+}
+
+/*Failed returns true, if any of the results did not pass */
+func Failed(results []Result) bool {
+	for _, r := range results {
+		if !r.Passed {
+			return true
+		}
+	}
+	return false
+}
+
+/*Diff returns a line per post result with the pre and post values */
+func Diff(pre, post []Result) []string {
+	var lines []string
+
+	preValues := make(map[string]string)
+	for _, r := range pre {
+		preValues[r.Name] = r.Value
+	}
+
+	for _, r := range post {
+		state := "ok"
+		if !r.Passed {
+			state = "FAILED " + r.Reason
+		}
+
+		before, ok := preValues[r.Name]
+		if !ok {
+			before = "-"
+		}
+
+		lines = append(lines, fmt.Sprintf("%-30s %12s -> %-12s %s", r.Name, before, r.Value, state))
+	}
+
+	return lines
+}
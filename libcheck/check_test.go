@@ -0,0 +1,202 @@
+package libcheck_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/ipcjk/mlxsh/libcheck"
+	"github.com/ipcjk/mlxsh/routerDevice"
+)
+
+var bundleYaml = `
+pre_checks:
+  - name: bgp established
+    command: show ip bgp summary
+    match: '(?m)^\s*\S+\s+\d+\s+ESTAB'
+    count: true
+  - name: chassis
+    command: show chassis
+    match: 'Power \d+: (\w+)'
+    expect: "== Installed"
+post_checks:
+  - name: bgp established
+    command: show ip bgp summary
+    match: '(?m)^\s*\S+\s+\d+\s+ESTAB'
+    count: true
+    compare: no-drop
+  - name: errors
+    command: show logging
+    match: 'BGP.*DOWN'
+    expect: absent
+`
+
+var bgpBefore = `  Number of Neighbors Configured: 3, UP: 3
+  Neighbor Address  AS#         State   Time          Rt:Accepted Filtered Sent     ToSend
+  192.0.2.1         65001       ESTAB   10d 2h40m     1000        0        20       0
+  192.0.2.2         65002       ESTAB   10d 2h40m     2000        0        20       0
+  192.0.2.3         65003       ESTAB   10d 2h40m     3000        0        20       0
+`
+
+var bgpAfter = `  Number of Neighbors Configured: 3, UP: 2
+  Neighbor Address  AS#         State   Time          Rt:Accepted Filtered Sent     ToSend
+  192.0.2.1         65001       ESTAB   10d 2h40m     1000        0        20       0
+  192.0.2.2         65002       IDLE    0h0m1s        0           0        0        0
+  192.0.2.3         65003       ESTAB   10d 2h40m     3000        0        20       0
+`
+
+func TestBundle(t *testing.T) {
+	bundle, err := libcheck.LoadBundle(strings.NewReader(bundleYaml))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pre := libcheck.Evaluate(bundle.PreChecks[0], bgpBefore)
+	if !pre.Passed || pre.Value != "3" {
+		t.Errorf("Wrong pre check result: %+v", pre)
+	}
+
+	post := libcheck.CompareResults(bundle.PostChecks[0], pre, libcheck.Evaluate(bundle.PostChecks[0], bgpAfter))
+	if post.Passed || post.Value != "2" {
+		t.Errorf("Dropped neighbor not detected: %+v", post)
+	}
+
+	same := libcheck.CompareResults(bundle.PostChecks[0], pre, libcheck.Evaluate(bundle.PostChecks[0], bgpBefore))
+	if !same.Passed {
+		t.Errorf("Unchanged neighbors failed: %+v", same)
+	}
+
+	chassis := libcheck.Evaluate(bundle.PreChecks[1], "Power 1: Installed (OK)\nPower 2: Installed (OK)")
+	if !chassis.Passed || chassis.Value != "Installed" {
+		t.Errorf("Wrong capture result: %+v", chassis)
+	}
+
+	logs := libcheck.Evaluate(bundle.PostChecks[1], "BGP: Peer 192.0.2.2 DOWN (Hold timer expired)")
+	if logs.Passed {
+		t.Errorf("Absent match passed: %+v", logs)
+	}
+
+	results := []libcheck.Result{post, logs}
+	if !libcheck.Failed(results) {
+		t.Error("Failed results not detected")
+	}
+
+	diff := libcheck.Diff([]libcheck.Result{pre}, results)
+	if len(diff) != 2 || !strings.Contains(diff[0], "3 -> 2") || !strings.Contains(diff[0], "no-drop") {
+		t.Errorf("Wrong diff: %s", strings.Join(diff, "\n"))
+	}
+}
+
+func TestExpect(t *testing.T) {
+	var expects = []struct {
+		expect string
+		output string
+		passed bool
+	}{
+		{">= 4", "routes: 1,024", true},
+		{"< 100", "routes: 1,024", false},
+		{"== 1024", "routes: 1,024", true},
+		{"!= 0", "routes: 0", false},
+		{"> 0", "no routes", false},
+	}
+
+	for _, e := range expects {
+		check := libcheck.Check{Command: "show ip route summary", Match: `routes: ([\d,]+)`, Expect: e.expect}
+		if result := libcheck.Evaluate(check, e.output); result.Passed != e.passed {
+			t.Errorf("%s on %s: expected %v, got %+v", e.expect, e.output, e.passed, result)
+		}
+	}
+}
+
+func TestBundleErrors(t *testing.T) {
+	for _, source := range []string{
+		"pre_checks:\n  - command: show version\n",
+		"pre_checks:\n  - command: show version\n    match: '('\n",
+		"pre_checks:\n  - command: show version\n    match: x\n    expect: '~ 3'\n",
+		"pre_checks:\n  - command: show version\n    match: x\n    compare: no-drop\n",
+		"post_checks:\n  - command: show version\n    match: x\n    compare: more\n",
+	} {
+		if _, err := libcheck.LoadBundle(strings.NewReader(source)); err == nil {
+			t.Errorf("Invalid bundle accepted: %s", source)
+		}
+	}
+}
+
+/* terminal answers commands with echo and prompt and records their output like a router module */
+type terminal struct {
+	w       *bytes.Buffer
+	answers map[string]string
+	outputs []router.CommandOutput
+}
+
+func (t *terminal) RunCommands(r io.Reader) error {
+	content, _ := ioutil.ReadAll(r)
+	command := strings.TrimSpace(string(content))
+	answer, ok := t.answers[command]
+	if !ok {
+		return router.Errorf(router.ErrCommandFailed, "rt1", "Command failed: %s", command)
+	}
+	fmt.Fprintf(t.w, "SSH@rt1#%s\n%sSSH@rt1#", command, answer)
+	t.outputs = append(t.outputs, router.CommandOutput{Command: command, Output: strings.TrimSpace(answer)})
+	return nil
+}
+
+func (t *terminal) CommandOutputs() []router.CommandOutput {
+	return t.outputs
+}
+
+func TestRun(t *testing.T) {
+	bundle, err := libcheck.LoadBundle(strings.NewReader(`
+pre_checks:
+  - name: bgp established
+    command: show ip bgp summary
+    match: '(?m)^\s*\S+\s+\d+\s+ESTAB'
+    count: true
+post_checks:
+  - name: bgp established
+    command: show ip bgp summary
+    match: '(?m)^\s*\S+\s+\d+\s+ESTAB'
+    count: true
+    compare: no-drop
+  - name: default route
+    command: show ip route 0.0.0.0/0
+    match: '0\.0\.0\.0/0'
+    expect: present
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buffer := bytes.NewBufferString("banner\n")
+	device := &terminal{w: buffer, answers: map[string]string{
+		"show ip bgp summary":     bgpBefore,
+		"show ip route 0.0.0.0/0": "No routes found\n",
+	}}
+
+	pre, err := libcheck.Run(device, buffer, bundle.PreChecks, nil)
+	if err != nil || pre[0].Value != "3" {
+		t.Fatalf("Pre-checks failed: %v %+v", err, pre)
+	}
+
+	/* the default route is only in the echoed command */
+	post, err := libcheck.Run(device, buffer, bundle.PostChecks, pre)
+	if !errors.Is(err, libcheck.ErrFailed) {
+		t.Errorf("Failed post-check not returned as ErrFailed: %v", err)
+	}
+	if len(post) != 2 || !post[0].Passed || post[1].Passed || post[1].Value != "absent" {
+		t.Errorf("Wrong post results: %+v", post)
+	}
+
+	if buffer.String() != "banner\n" {
+		t.Errorf("Raw output not cut from the buffer: %q", buffer.String())
+	}
+
+	_, err = libcheck.Run(device, buffer, []libcheck.Check{{Name: "log", Command: "show log"}}, nil)
+	if errors.Is(err, libcheck.ErrFailed) || !errors.Is(err, router.ErrCommandFailed) {
+		t.Errorf("Failed command is not a command error: %v", err)
+	}
+}
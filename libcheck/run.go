@@ -0,0 +1,42 @@
+package libcheck
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/ipcjk/mlxsh/routerDevice"
+)
+
+/*ErrFailed is returned by Run, if a check did not pass, check it with errors.Is */
+var ErrFailed = errors.New("checks failed")
+
+/*
+Run runs the exec command of every check on a router module and evaluates its
+output without echo and prompt, the raw output is cut from the buffer of the
+module. Post checks are compared with the pre results of the same name.
+*/
+func Run(r router.Commander, buffer *bytes.Buffer, checks []Check, pre []Result) ([]Result, error) {
+	var results []Result
+
+	for _, c := range checks {
+		output, err := router.RunCommand(r, buffer, c.Command)
+		if err != nil {
+			return results, fmt.Errorf("Check %s: %w", c.Name, err)
+		}
+
+		result := Evaluate(c, output)
+		for _, p := range pre {
+			if p.Name == c.Name {
+				result = CompareResults(c, p, result)
+			}
+		}
+		results = append(results, result)
+	}
+
+	if Failed(results) {
+		return results, ErrFailed
+	}
+
+	return results, nil
+}
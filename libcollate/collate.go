@@ -0,0 +1,235 @@
+package libcollate
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+/*Masked replaces the masked parts of an output */
+const Masked = "<masked>"
+
+/* diffContext is the number of unchanged lines around a change, maxDiffCells limits the lcs table */
+const (
+	diffContext  = 3
+	maxDiffCells = 4000000
+)
+
+/*DefaultMasks mask times, dates, uptimes and counters of seconds, that differ on every host */
+var DefaultMasks = []*regexp.Regexp{
+	regexp.MustCompile(`\b\d{1,2}:\d{2}:\d{2}(\.\d+)?\b`),
+	regexp.MustCompile(`\b\d{4}-\d{2}-\d{2}\b`),
+	regexp.MustCompile(`(?i)\b(mon|tue|wed|thu|fri|sat|sun)\b\s+(jan|feb|mar|apr|may|jun|jul|aug|sep|oct|nov|dec)\b\s+\d{1,2}`),
+	regexp.MustCompile(`(?i)\b(up\s*time|uptime)\b.*`),
+	regexp.MustCompile(`\b\d+[dw]\s?\d+h(\d+m)?(\d+s)?\b`),
+}
+
+/*Group is an output and the hosts, that produced it */
+type Group struct {
+	Hosts  []string
+	Output string
+}
+
+/*Normalize replaces every match of the masks with Masked */
+func Normalize(output string, masks []*regexp.Regexp) string {
+	for _, mask := range masks {
+		output = mask.ReplaceAllString(output, Masked)
+	}
+	return output
+}
+
+/*
+Collate groups the hosts by their normalized output. The largest group comes
+first, groups of the same size are sorted by their first hostname.
+*/
+func Collate(outputs map[string]string, masks []*regexp.Regexp) []Group {
+	var groups []Group
+	index := make(map[string]int)
+
+	for host, output := range outputs {
+		output = Normalize(output, masks)
+		if x, ok := index[output]; ok {
+			groups[x].Hosts = append(groups[x].Hosts, host)
+			continue
+		}
+		index[output] = len(groups)
+		groups = append(groups, Group{Hosts: []string{host}, Output: output})
+	}
+
+	for x := range groups {
+		sort.Strings(groups[x].Hosts)
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		if len(groups[i].Hosts) != len(groups[j].Hosts) {
+			return len(groups[i].Hosts) > len(groups[j].Hosts)
+		}
+		return groups[i].Hosts[0] < groups[j].Hosts[0]
+	})
+
+	return groups
+}
+
+/* edit is a line of the edit script, kind is ' ', '-' or '+' */
+type edit struct {
+	kind byte
+	line string
+}
+
+/*Diff returns a unified diff from a to b, it is empty for equal outputs */
+func Diff(a, b, nameA, nameB string) string {
+	if a == b {
+		return ""
+	}
+
+	edits := editScript(strings.Split(a, "\n"), strings.Split(b, "\n"))
+
+	var diff strings.Builder
+	fmt.Fprintf(&diff, "--- %s\n+++ %s\n", nameA, nameB)
+
+	for start := 0; start < len(edits); {
+		/* find the next change and the end of its hunk */
+		for start < len(edits) && edits[start].kind == ' ' {
+			start++
+		}
+		if start == len(edits) {
+			break
+		}
+
+		end, unchanged := start, 0
+		for x := start; x < len(edits) && unchanged <= 2*diffContext; x++ {
+			if edits[x].kind == ' ' {
+				unchanged++
+			} else {
+				unchanged, end = 0, x+1
+			}
+		}
+
+		from, to := start-diffContext, end+diffContext
+		if from < 0 {
+			from = 0
+		}
+		if to > len(edits) {
+			to = len(edits)
+		}
+
+		writeHunk(&diff, edits, from, to)
+		start = to
+	}
+
+	return diff.String()
+}
+
+/* writeHunk writes the edits from..to with a hunk header */
+func writeHunk(diff *strings.Builder, edits []edit, from, to int) {
+	var lineA, lineB, countA, countB int
+
+	for _, e := range edits[:from] {
+		if e.kind != '+' {
+			lineA++
+		}
+		if e.kind != '-' {
+			lineB++
+		}
+	}
+
+	for _, e := range edits[from:to] {
+		if e.kind != '+' {
+			countA++
+		}
+		if e.kind != '-' {
+			countB++
+		}
+	}
+
+	/* unified diffs count from one, empty ranges point to the line before */
+	if countA > 0 {
+		lineA++
+	}
+	if countB > 0 {
+		lineB++
+	}
+
+	fmt.Fprintf(diff, "@@ -%d,%d +%d,%d @@\n", lineA, countA, lineB, countB)
+	for _, e := range edits[from:to] {
+		fmt.Fprintf(diff, "%c%s\n", e.kind, e.line)
+	}
+}
+
+/*
+editScript returns the edits from a to b along their longest common subsequence.
+Common head and tail are cut first, a middle, that is too large, is replaced as a whole.
+*/
+func editScript(a, b []string) []edit {
+	var head, tail []edit
+
+	for len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+		head = append(head, edit{' ', a[0]})
+		a, b = a[1:], b[1:]
+	}
+
+	for len(a) > 0 && len(b) > 0 && a[len(a)-1] == b[len(b)-1] {
+		tail = append([]edit{{' ', a[len(a)-1]}}, tail...)
+		a, b = a[:len(a)-1], b[:len(b)-1]
+	}
+
+	var middle []edit
+	if (len(a)+1)*(len(b)+1) > maxDiffCells {
+		for _, line := range a {
+			middle = append(middle, edit{'-', line})
+		}
+		for _, line := range b {
+			middle = append(middle, edit{'+', line})
+		}
+	} else {
+		middle = lcsEdits(a, b)
+	}
+
+	return append(append(head, middle...), tail...)
+}
+
+/* lcsEdits builds the edit script from a table of common subsequence lengths */
+func lcsEdits(a, b []string) []edit {
+	var edits []edit
+	lcs := make([][]int, len(a)+1)
+	for x := range lcs {
+		lcs[x] = make([]int, len(b)+1)
+	}
+
+	for x := len(a) - 1; x >= 0; x-- {
+		for y := len(b) - 1; y >= 0; y-- {
+			if a[x] == b[y] {
+				lcs[x][y] = lcs[x+1][y+1] + 1
+			} else if lcs[x+1][y] >= lcs[x][y+1] {
+				lcs[x][y] = lcs[x+1][y]
+			} else {
+				lcs[x][y] = lcs[x][y+1]
+			}
+		}
+	}
+
+	x, y := 0, 0
+	for x < len(a) && y < len(b) {
+		switch {
+		case a[x] == b[y]:
+			edits = append(edits, edit{' ', a[x]})
+			x, y = x+1, y+1
+		case lcs[x+1][y] >= lcs[x][y+1]:
+			edits = append(edits, edit{'-', a[x]})
+			x++
+		default:
+			edits = append(edits, edit{'+', b[y]})
+			y++
+		}
+	}
+
+	for ; x < len(a); x++ {
+		edits = append(edits, edit{'-', a[x]})
+	}
+	for ; y < len(b); y++ {
+		edits = append(edits, edit{'+', b[y]})
+	}
+
+	return edits
+}
@@ -0,0 +1,71 @@
+package libcollate_test
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/ipcjk/mlxsh/libcollate"
+)
+
+var version = `System: NetIron MLX (Serial #: BGD2502G00A)
+IronWare : Version 5.8.0fT163 Copyright (c) 1996-2015 Brocade Communications Systems, Inc.
+Compiled on Apr 24 2015 at 10:55:32 labeled as xmprm05800f
+System uptime is 120 days 4 hours 27 minutes 31 seconds`
+
+func TestCollate(t *testing.T) {
+	outputs := map[string]string{
+		"mlx1": version,
+		"mlx2": strings.Replace(version, "120 days", "98 days", 1),
+		"mlx3": strings.Replace(version, "5.8.0fT163", "5.9.0bT163", 1),
+		"mlx4": version,
+	}
+
+	groups := libcollate.Collate(outputs, nil)
+	if len(groups) != 3 || strings.Join(groups[0].Hosts, ",") != "mlx1,mlx4" {
+		t.Errorf("Wrong groups without masks: %+v", groups)
+	}
+
+	groups = libcollate.Collate(outputs, libcollate.DefaultMasks)
+	if len(groups) != 2 || strings.Join(groups[0].Hosts, ",") != "mlx1,mlx2,mlx4" || groups[1].Hosts[0] != "mlx3" {
+		t.Errorf("Wrong groups with default masks: %+v", groups)
+	}
+	if strings.Contains(groups[0].Output, "120 days") || !strings.Contains(groups[0].Output, libcollate.Masked) {
+		t.Errorf("Uptime not masked: %s", groups[0].Output)
+	}
+
+	masks := []*regexp.Regexp{regexp.MustCompile(`Version \S+`)}
+	if groups = libcollate.Collate(outputs, masks); len(groups) != 2 || len(groups[0].Hosts) != 3 {
+		t.Errorf("Wrong groups with version mask: %+v", groups)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	a := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n14\n15"
+	b := "1\n2\n3\n4\nfive\n6\n7\n8\n9\n10\n11\n12\n13\n14\n15\n16"
+
+	expected := `--- majority
++++ mlx3
+@@ -2,7 +2,7 @@
+ 2
+ 3
+ 4
+-5
++five
+ 6
+ 7
+ 8
+@@ -13,3 +13,4 @@
+ 13
+ 14
+ 15
++16
+`
+	if diff := libcollate.Diff(a, b, "majority", "mlx3"); diff != expected {
+		t.Errorf("Wrong diff:\n%s\nexpected\n%s", diff, expected)
+	}
+
+	if diff := libcollate.Diff(a, a, "a", "b"); diff != "" {
+		t.Errorf("Diff of equal outputs: %s", diff)
+	}
+}
@@ -16,23 +16,36 @@ HostConfig represents the host structure,
 that is being imported from the yaml configuration
 */
 type HostConfig struct {
+	Answers         []string          `yaml:"Answers"`
+	ArchiveDir      string            `yaml:"ArchiveDir"`
+	BackupConfig    bool              `yaml:"BackupConfig"`
+	Classify        []string          `yaml:"Classify"`
+	CommitComment   string            `yaml:"CommitComment"`
 	ConfigFile      string            `yaml:"ConfigFile"`
+	ConfigFormat    string            `yaml:"ConfigFormat"`
+	ConfirmMinutes  int               `yaml:"ConfirmMinutes"`
 	DeviceType      string            `yaml:"DeviceType"`
 	EnablePassword  string            `yaml:"EnablePassword"`
+	ErrorPolicy     string            `yaml:"ErrorPolicy"`
 	ExecMode        bool              `yaml:"ExecMode"`
 	Filename        string            `yaml:"FileName"`
 	Hostname        string            `yaml:"Hostname"`
 	KeyFile         string            `yaml:"KeyFile"`
 	KnownHosts      string            `yaml:"KnownHosts"`
 	Labels          map[string]string `yaml:"Labels"`
+	LoadAction      string            `yaml:"LoadAction"`
 	Password        string            `yaml:"Password"`
+	PostCheckFile   string            `yaml:"PostCheckFile"`
 	ReadTimeout     time.Duration     `yaml:"Readtimeout"`
+	Rollback        string            `yaml:"Rollback"`
 	ScriptFile      string            `yaml:"ScriptFile"`
 	SpeedMode       bool              `yaml:"SpeedMode"`
 	SSHIP           string            `yaml:"SSHIP"`
 	SSHPort         int               `yaml:"SSHPort"`
 	StrictHostCheck bool              `yaml:"StrictHostCheck"`
+	Transport       string            `yaml:"Transport"`
 	Username        string            `yaml:"Username"`
+	Vars            map[string]string `yaml:"Vars"`
 	WriteTimeout    time.Duration     `yaml:"Writetimeout"`
 }
 
@@ -0,0 +1,327 @@
+package libnetconf
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* Capabilities for the base protocol versions */
+const (
+	CapBase10 = "urn:ietf:params:netconf:base:1.0"
+	CapBase11 = "urn:ietf:params:netconf:base:1.1"
+)
+
+/* endOfMessage is the delimiter of the base:1.0 framing */
+const endOfMessage = "]]>]]>"
+
+/*
+Session is a NETCONF session on top of a reader and writer, e.g. the
+stdin and stdout pipes of a SSH netconf subsystem
+*/
+type Session struct {
+	/* Capabilities and the session id announced by the server hello */
+	Capabilities []string
+	SessionID    int
+
+	/* Timeout closes Closer, if a reply does not arrive in time */
+	Timeout time.Duration
+	Closer  io.Closer
+
+	r         *bufio.Reader
+	w         io.Writer
+	chunked   bool
+	messageID int
+	mu        sync.Mutex
+}
+
+/*RPCError is a single rpc-error element of a rpc-reply */
+type RPCError struct {
+	Type     string `xml:"error-type"`
+	Tag      string `xml:"error-tag"`
+	Severity string `xml:"error-severity"`
+	Path     string `xml:"error-path"`
+	Message  string `xml:"error-message"`
+}
+
+func (e RPCError) Error() string {
+	message := strings.TrimSpace(e.Message)
+	if message == "" {
+		message = e.Tag
+	}
+	return fmt.Sprintf("netconf %s error: %s", e.Type, message)
+}
+
+type hello struct {
+	XMLName      xml.Name `xml:"hello"`
+	Capabilities []string `xml:"capabilities>capability"`
+	SessionID    int      `xml:"session-id"`
+}
+
+type rpcReply struct {
+	XMLName xml.Name   `xml:"rpc-reply"`
+	Errors  []RPCError `xml:"rpc-error"`
+	Output  string     `xml:"output"`
+	/* Junos nests errors of load and commit into its result elements */
+	LoadErrors   []RPCError `xml:"load-configuration-results>rpc-error"`
+	CommitErrors []RPCError `xml:"commit-results>rpc-error"`
+}
+
+/*NewSession returns a new NETCONF session, Hello needs to be called before any rpc */
+func NewSession(r io.Reader, w io.Writer) *Session {
+	return &Session{r: bufio.NewReader(r), w: w}
+}
+
+/*
+Hello exchanges the capabilities with the server. If both sides
+support base:1.1 the session switches to chunked framing.
+*/
+func (s *Session) Hello() error {
+	clientHello := `<?xml version="1.0" encoding="UTF-8"?><hello xmlns="urn:ietf:params:xml:ns:netconf:base:1.0"><capabilities>` +
+		`<capability>` + CapBase10 + `</capability><capability>` + CapBase11 + `</capability></capabilities></hello>`
+
+	/* Hello messages always use the base:1.0 framing */
+	if _, err := io.WriteString(s.w, clientHello+endOfMessage); err != nil {
+		return fmt.Errorf("Cant send hello: %s", err)
+	}
+
+	message, err := s.readMessage()
+	if err != nil {
+		return fmt.Errorf("Cant read server hello: %s", err)
+	}
+
+	var serverHello hello
+	if err := xml.Unmarshal([]byte(message), &serverHello); err != nil {
+		return fmt.Errorf("Cant parse server hello: %s", err)
+	}
+
+	s.Capabilities = serverHello.Capabilities
+	s.SessionID = serverHello.SessionID
+
+	for _, capability := range s.Capabilities {
+		if strings.TrimSpace(capability) == CapBase11 {
+			s.chunked = true
+		}
+	}
+
+	return nil
+}
+
+/*HasCapability returns true, if the server announced the capability (prefix) */
+func (s *Session) HasCapability(capability string) bool {
+	for _, c := range s.Capabilities {
+		if strings.HasPrefix(strings.TrimSpace(c), capability) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Exec sends a rpc and returns the raw rpc-reply. Replies that carry
+an rpc-error with severity error are returned as error.
+*/
+func (s *Session) Exec(rpc string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.messageID++
+	message := fmt.Sprintf(`<rpc message-id="%d" xmlns="urn:ietf:params:xml:ns:netconf:base:1.0">%s</rpc>`, s.messageID, rpc)
+
+	if err := s.writeMessage(message); err != nil {
+		return "", fmt.Errorf("Cant send rpc: %s", err)
+	}
+
+	reply, err := s.readMessage()
+	if err != nil {
+		return "", fmt.Errorf("Cant read rpc-reply: %s", err)
+	}
+
+	var parsed rpcReply
+	if err := xml.Unmarshal([]byte(reply), &parsed); err != nil {
+		return reply, fmt.Errorf("Cant parse rpc-reply: %s", err)
+	}
+
+	for _, rpcErr := range append(append(parsed.Errors, parsed.LoadErrors...), parsed.CommitErrors...) {
+		if rpcErr.Severity == "" || rpcErr.Severity == "error" {
+			return reply, rpcErr
+		}
+	}
+
+	return reply, nil
+}
+
+/*Command runs a cli command on Junos and returns its text output */
+func (s *Session) Command(command string) (string, error) {
+	reply, err := s.Exec(`<command format="text">` + escape(command) + `</command>`)
+	if err != nil {
+		return "", err
+	}
+
+	var parsed rpcReply
+	if err := xml.Unmarshal([]byte(reply), &parsed); err != nil {
+		return "", fmt.Errorf("Cant parse rpc-reply: %s", err)
+	}
+
+	return parsed.Output, nil
+}
+
+/*Lock locks a datastore, e.g. candidate */
+func (s *Session) Lock(target string) error {
+	_, err := s.Exec("<lock><target><" + target + "/></target></lock>")
+	return err
+}
+
+/*Unlock unlocks a datastore, e.g. candidate */
+func (s *Session) Unlock(target string) error {
+	_, err := s.Exec("<unlock><target><" + target + "/></target></unlock>")
+	return err
+}
+
+/*
+LoadConfiguration loads configuration into the Junos candidate. format is one of text,
+set or xml, action one of merge, replace, override or update. Set format implies the set action.
+*/
+func (s *Session) LoadConfiguration(format, action, configuration string) error {
+	var rpc string
+
+	if action == "" {
+		action = "merge"
+	}
+
+	switch format {
+	case "set":
+		rpc = `<load-configuration action="set" format="text"><configuration-set>` + escape(configuration) + `</configuration-set></load-configuration>`
+	case "text":
+		rpc = `<load-configuration action="` + action + `" format="text"><configuration-text>` + escape(configuration) + `</configuration-text></load-configuration>`
+	case "xml":
+		/* xml is sent as it is, it needs the <configuration> root element */
+		rpc = `<load-configuration action="` + action + `" format="xml">` + configuration + `</load-configuration>`
+	default:
+		return fmt.Errorf("Unknown configuration format: %s", format)
+	}
+
+	_, err := s.Exec(rpc)
+	return err
+}
+
+/*LoadRollback loads the nth previous committed configuration into the candidate */
+func (s *Session) LoadRollback(n int) error {
+	_, err := s.Exec(`<load-configuration rollback="` + strconv.Itoa(n) + `"/>`)
+	return err
+}
+
+/*
+CommitConfiguration commits the candidate. With confirmMinutes > 0 the
+commit is confirmed and reverts, if not confirmed by another commit in time.
+*/
+func (s *Session) CommitConfiguration(confirmMinutes int, comment string) error {
+	var rpc = "<commit-configuration>"
+
+	if confirmMinutes > 0 {
+		rpc += "<confirmed/><confirm-timeout>" + strconv.Itoa(confirmMinutes) + "</confirm-timeout>"
+	}
+	if comment != "" {
+		rpc += "<log>" + escape(comment) + "</log>"
+	}
+	rpc += "</commit-configuration>"
+
+	_, err := s.Exec(rpc)
+	return err
+}
+
+/*Validate checks a datastore, e.g. candidate, like a commit check */
+func (s *Session) Validate(source string) error {
+	_, err := s.Exec("<validate><source><" + source + "/></source></validate>")
+	return err
+}
+
+/*DiscardChanges throws away all uncommitted changes in the candidate */
+func (s *Session) DiscardChanges() error {
+	_, err := s.Exec("<discard-changes/>")
+	return err
+}
+
+/*CloseSession ends the NETCONF session gracefully */
+func (s *Session) CloseSession() error {
+	_, err := s.Exec("<close-session/>")
+	return err
+}
+
+func (s *Session) writeMessage(message string) error {
+	if s.chunked {
+		_, err := fmt.Fprintf(s.w, "\n#%d\n%s\n##\n", len(message), message)
+		return err
+	}
+	_, err := io.WriteString(s.w, message+endOfMessage)
+	return err
+}
+
+func (s *Session) readMessage() (string, error) {
+	if s.Timeout > 0 && s.Closer != nil {
+		timer := time.AfterFunc(s.Timeout, func() {
+			s.Closer.Close()
+		})
+		defer timer.Stop()
+	}
+
+	if s.chunked {
+		return s.readChunked()
+	}
+
+	var message bytes.Buffer
+	for {
+		b, err := s.r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		message.WriteByte(b)
+		if b == '>' && bytes.HasSuffix(message.Bytes(), []byte(endOfMessage)) {
+			return strings.TrimSpace(strings.TrimSuffix(message.String(), endOfMessage)), nil
+		}
+	}
+}
+
+/* readChunked reads a message in base:1.1 chunked framing, RFC 6242 section 4.2 */
+func (s *Session) readChunked() (string, error) {
+	var message bytes.Buffer
+
+	for {
+		header, err := s.r.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+
+		header = strings.TrimSpace(header)
+		if header == "" {
+			continue
+		}
+		if header == "##" {
+			return message.String(), nil
+		}
+		if !strings.HasPrefix(header, "#") {
+			return "", fmt.Errorf("Invalid chunk header: %q", header)
+		}
+
+		size, err := strconv.Atoi(header[1:])
+		if err != nil || size <= 0 {
+			return "", fmt.Errorf("Invalid chunk size: %q", header)
+		}
+
+		if _, err := io.CopyN(&message, s.r, int64(size)); err != nil {
+			return "", err
+		}
+	}
+}
+
+func escape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
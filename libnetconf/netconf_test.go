@@ -0,0 +1,213 @@
+package libnetconf_test
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/ipcjk/mlxsh/libnetconf"
+)
+
+/*
+fakeServer is a local NETCONF server, that answers the Junos rpcs used
+by mlxsh and records every rpc it received
+*/
+type fakeServer struct {
+	chunked bool
+	rpcs    []string
+	r       *bufio.Reader
+	w       io.Writer
+}
+
+func startFakeServer(t *testing.T, base11 bool) (*libnetconf.Session, *fakeServer, chan struct{}) {
+	clientReader, serverWriter := io.Pipe()
+	serverReader, clientWriter := io.Pipe()
+
+	server := &fakeServer{r: bufio.NewReader(serverReader), w: serverWriter}
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		defer serverWriter.Close()
+
+		capabilities := "<capability>" + libnetconf.CapBase10 + "</capability>"
+		if base11 {
+			capabilities += "<capability>" + libnetconf.CapBase11 + "</capability>"
+		}
+		/* pipes are not buffered, both sides send their hello at the same time */
+		go io.WriteString(serverWriter, `<hello><capabilities>`+capabilities+`</capabilities><session-id>4711</session-id></hello>]]>]]>`)
+
+		if _, err := server.read(); err != nil {
+			t.Errorf("Fake server cant read client hello: %s", err)
+			return
+		}
+		server.chunked = base11
+
+		for {
+			rpc, err := server.read()
+			if err != nil {
+				return
+			}
+			server.rpcs = append(server.rpcs, rpc)
+
+			var reply = "<ok/>"
+			switch {
+			case strings.Contains(rpc, "<command"):
+				reply = "<output>Hostname: fake-mx\nModel: mx204\n</output>"
+			case strings.Contains(rpc, "bogus"):
+				reply = `<load-configuration-results><rpc-error><error-type>protocol</error-type><error-severity>error</error-severity>` +
+					`<error-message>syntax error</error-message></rpc-error></load-configuration-results>`
+			case strings.Contains(rpc, "warning-only"):
+				reply = `<rpc-error><error-severity>warning</error-severity><error-message>statement not found</error-message></rpc-error><ok/>`
+			}
+			server.write(`<rpc-reply message-id="1">` + reply + `</rpc-reply>`)
+
+			if strings.Contains(rpc, "<close-session/>") {
+				return
+			}
+		}
+	}()
+
+	return libnetconf.NewSession(clientReader, clientWriter), server, done
+}
+
+func (f *fakeServer) read() (string, error) {
+	if !f.chunked {
+		var message string
+		for !strings.HasSuffix(message, "]]>]]>") {
+			b, err := f.r.ReadByte()
+			if err != nil {
+				return "", err
+			}
+			message += string(b)
+		}
+		return strings.TrimSuffix(message, "]]>]]>"), nil
+	}
+
+	var message string
+	for {
+		header, err := f.r.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		header = strings.TrimSpace(header)
+		if header == "" {
+			continue
+		}
+		if header == "##" {
+			return message, nil
+		}
+		size, err := strconv.Atoi(header[1:])
+		if err != nil {
+			return "", err
+		}
+		chunk := make([]byte, size)
+		if _, err := io.ReadFull(f.r, chunk); err != nil {
+			return "", err
+		}
+		message += string(chunk)
+	}
+}
+
+func (f *fakeServer) write(message string) {
+	if f.chunked {
+		/* split into two chunks to test the reassembly */
+		half := len(message) / 2
+		fmt.Fprintf(f.w, "\n#%d\n%s\n#%d\n%s\n##\n", half, message[:half], len(message)-half, message[half:])
+		return
+	}
+	io.WriteString(f.w, message+"]]>]]>")
+}
+
+func TestSessionBase10(t *testing.T) {
+	session, server, done := startFakeServer(t, false)
+
+	if err := session.Hello(); err != nil {
+		t.Fatalf("Hello failed: %s", err)
+	}
+
+	if session.SessionID != 4711 {
+		t.Errorf("Wrong session id: %d", session.SessionID)
+	}
+
+	if session.HasCapability(libnetconf.CapBase11) {
+		t.Error("Server did not announce base:1.1")
+	}
+
+	output, err := session.Command("show version")
+	if err != nil {
+		t.Errorf("Command failed: %s", err)
+	}
+	if !strings.Contains(output, "fake-mx") {
+		t.Errorf("Wrong command output: %s", output)
+	}
+
+	if err := session.CloseSession(); err != nil {
+		t.Errorf("Close session failed: %s", err)
+	}
+	<-done
+
+	if !strings.Contains(server.rpcs[0], `<command format="text">show version</command>`) {
+		t.Errorf("Wrong command rpc: %s", server.rpcs[0])
+	}
+}
+
+func TestSessionConfigurationBase11(t *testing.T) {
+	session, server, done := startFakeServer(t, true)
+
+	if err := session.Hello(); err != nil {
+		t.Fatalf("Hello failed: %s", err)
+	}
+
+	if err := session.Lock("candidate"); err != nil {
+		t.Errorf("Lock failed: %s", err)
+	}
+
+	if err := session.LoadConfiguration("set", "", "set system host-name fake-mx\nset interfaces lo0 unit 0"); err != nil {
+		t.Errorf("Load failed: %s", err)
+	}
+
+	if err := session.LoadConfiguration("text", "replace", "system { bogus; }"); err == nil {
+		t.Error("Load error in load-configuration-results not detected")
+	} else if !strings.Contains(err.Error(), "syntax error") {
+		t.Errorf("Wrong load error: %s", err)
+	}
+
+	if err := session.LoadConfiguration("xml", "merge", "<configuration><warning-only/></configuration>"); err != nil {
+		t.Errorf("Warning was returned as error: %s", err)
+	}
+
+	if err := session.Validate("candidate"); err != nil {
+		t.Errorf("Validate failed: %s", err)
+	}
+
+	if err := session.CommitConfiguration(5, "mlxsh <test>"); err != nil {
+		t.Errorf("Commit failed: %s", err)
+	}
+
+	if err := session.Unlock("candidate"); err != nil {
+		t.Errorf("Unlock failed: %s", err)
+	}
+
+	session.CloseSession()
+	<-done
+
+	expected := []string{
+		"<lock><target><candidate/></target></lock>",
+		`<load-configuration action="set" format="text"><configuration-set>set system host-name fake-mx`,
+		`<load-configuration action="replace" format="text"><configuration-text>system { bogus; }</configuration-text>`,
+		`<load-configuration action="merge" format="xml"><configuration><warning-only/></configuration></load-configuration>`,
+		"<validate><source><candidate/></source></validate>",
+		"<commit-configuration><confirmed/><confirm-timeout>5</confirm-timeout><log>mlxsh &lt;test&gt;</log></commit-configuration>",
+		"<unlock><target><candidate/></target></unlock>",
+	}
+
+	for x := range expected {
+		if !strings.Contains(server.rpcs[x], expected[x]) {
+			t.Errorf("rpc %d: expected %s, got %s", x, expected[x], server.rpcs[x])
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package libparse_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ipcjk/mlxsh/libparse"
+)
+
+var netironBgp = `  BGP4 Summary
+  Router ID: 10.0.0.1   Local AS Number: 65001
+  Confederation Identifier: not configured
+  Number of Neighbors Configured: 2, UP: 1
+  Number of Routes Installed: 100, Uses 8600 bytes
+  Neighbor Address  AS#         State   Time          Rt:Accepted Filtered Sent     ToSend
+  192.0.2.1         65002       ESTAB   10d 2h 3m     100         0        5        0
+  192.0.2.2         65003       CONN    0h 0m 2s      0           0        0        0
+SSH@mlx1#`
+
+var junosBgp = `Threading mode: BGP I/O
+Groups: 2 Peers: 3 Down peers: 1
+Table          Tot Paths  Act Paths Suppressed    History Damp State    Pending
+inet.0
+                     120         80          0          0          0          0
+Peer                     AS      InPkt     OutPkt    OutQ   Flaps Last Up/Dwn State|#Active/Received/Accepted/Damped...
+192.0.2.1             65002      12345      12340       0       1     1w2d3h Establ
+  inet.0: 80/120/118/0
+198.51.100.2          65003          0          0       0       0       2:03 Active
+203.0.113.9           65004        500        498       0       0      3d4h 0/0/0/0            0/0/0/0`
+
+func TestBundledTemplates(t *testing.T) {
+	var tests = []struct {
+		platform, command, output string
+		rows                      [][]interface{}
+	}{
+		{"netiron", "sh ip bgp sum", netironBgp, [][]interface{}{
+			{"10.0.0.1", "65001", "192.0.2.1", "65002", "ESTAB", "10d 2h 3m", "100", "0", "5", "0"},
+			{"10.0.0.1", "65001", "192.0.2.2", "65003", "CONN", "0h 0m 2s", "0", "0", "0", "0"},
+		}},
+		{"junos", "show bgp summary", junosBgp, [][]interface{}{
+			{"192.0.2.1", "65002", "12345", "12340", "0", "1", "1w2d3h", "Establ", "80", "120", "118", "0"},
+			{"198.51.100.2", "65003", "0", "0", "0", "0", "2:03", "Active", "", "", "", ""},
+			{"203.0.113.9", "65004", "500", "498", "0", "0", "3d4h", "", "0", "0", "0", "0"},
+		}},
+		{"netiron", "show interfaces brief", `Port    Link    State   Dupl Speed Trunk Tag Priori MAC            Name           Type
+1/1     Up      Forward Full 10G   None  No  level0 cc4e.24b4.8e10 uplink core    default
+1/2     Disable None    None None  None  No  level0 cc4e.24b4.8e11                default`, [][]interface{}{
+			{"1/1", "Up", "Forward", "Full", "10G", "None", "No", "level0", "cc4e.24b4.8e10", "uplink core", "default"},
+			{"1/2", "Disable", "None", "None", "None", "None", "No", "level0", "cc4e.24b4.8e11", "", "default"},
+		}},
+		{"netiron", "show version", `System: NetIron MLX (Serial #: BGD2502G00A,  Part #: 40-1000363-04)
+IronWare : Version 5.8.0fT163 Copyright (c) 1996-2015 Brocade Communications Systems, Inc.
+IronWare : Version 5.8.0fT177 Copyright (c) 1996-2015 Brocade Communications Systems, Inc.
+System uptime is 120 days 4 hours 27 minutes 31 seconds`, [][]interface{}{
+			{"NetIron MLX", "BGD2502G00A", "5.8.0fT163", "120 days 4 hours 27 minutes 31 seconds"},
+		}},
+		{"netiron", "show ip cache", `Total number of cache entries: 2
+      IP Address         Next Hop        MAC              Type  Port  Vlan  Pri
+1     192.0.2.1          DIRECT          0000.0000.0000   PU    n/a         0
+2     198.51.100.7       192.0.2.9       cc4e.24b4.8e10   DF    1/1   10    0`, [][]interface{}{
+			{"192.0.2.1", "DIRECT", "0000.0000.0000", "PU", "n/a", "", "0"},
+			{"198.51.100.7", "192.0.2.9", "cc4e.24b4.8e10", "DF", "1/1", "10", "0"},
+		}},
+		{"slx", "show ip interface brief", `Interface                      IP-Address      Vrf                              Status                 Protocol
+=========                      ==========      ===                              ======                 ========
+Ethernet 0/1                   10.1.1.1        default-vrf                      up                     up
+Ethernet 0/2                   unassigned      default-vrf                      admin down             down`, [][]interface{}{
+			{"Ethernet 0/1", "10.1.1.1", "default-vrf", "up", "up"},
+			{"Ethernet 0/2", "unassigned", "default-vrf", "admin down", "down"},
+		}},
+	}
+
+	for _, test := range tests {
+		template := libparse.Lookup(test.platform, test.command)
+		if template == nil {
+			t.Errorf("No template for %s %s", test.platform, test.command)
+			continue
+		}
+
+		table, err := template.Parse(test.output)
+		if err != nil {
+			t.Errorf("%s: %s", test.command, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(table.Rows, test.rows) {
+			t.Errorf("%s: wrong rows\n%q\nexpected\n%q", test.command, table.Rows, test.rows)
+		}
+	}
+
+	if libparse.Lookup("junos", "show ip bgp summary") != nil || libparse.Lookup("netiron", "show ip bgp neighbors") != nil {
+		t.Error("Template found for the wrong command")
+	}
+}
+
+func TestTemplateOptions(t *testing.T) {
+	template, err := libparse.ParseTemplate(strings.NewReader(`# vlans with their ports
+Value Required VLAN (\d+)
+Value List PORTS (\S+)
+Value Fillup DOMAIN (\S+)
+
+Start
+  ^vlan -> Continue.Record
+  ^vlan ${VLAN}
+  ^\s+port ${PORTS}
+  ^domain ${DOMAIN}
+  ^error -> Error "device failed"
+  ^end -> Record End
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	table, err := template.Parse("vlan 10\n port 1/1\n port 1/2\nvlan 20\n port 1/3\ndomain core\nend\nvlan 30\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := [][]interface{}{
+		{"10", []string{"1/1", "1/2"}, "core"},
+		{"20", []string{"1/3"}, "core"},
+	}
+	if !reflect.DeepEqual(table.Rows, expected) {
+		t.Errorf("Wrong rows: %q", table.Rows)
+	}
+
+	if _, err := template.Parse("vlan 10\nerror\n"); err == nil || !strings.Contains(err.Error(), "device failed") {
+		t.Errorf("Error rule did not fail: %v", err)
+	}
+}
+
+func TestTemplateErrors(t *testing.T) {
+	for _, source := range []string{
+		"Start\n  ^foo\n",
+		"Value X (\\d+)\n\n  ^${X}\n",
+		"Value X (\\d+)\n\nState\n  ^${X}\n",
+		"Value X (\\d+)\n\nStart\n  ^${Y}\n",
+		"Value X (\\d+)\n\nStart\n  ^${X} -> Missing\n",
+		"Value X (\\d+)\n\nStart\n  ^${X} -> Continue Start\n",
+		"Value Bogus X (\\d+)\n\nStart\n  ^${X}\n",
+	} {
+		if _, err := libparse.ParseTemplate(strings.NewReader(source)); err == nil {
+			t.Errorf("Invalid template accepted: %q", source)
+		}
+	}
+}
+
+func TestCommandRegexp(t *testing.T) {
+	command := libparse.CommandRegexp("sh[[ow]] ip b[[gp]] s[[ummary]]")
+	for _, c := range []string{"show ip bgp summary", "sh ip b s", "sho  ip bg summ"} {
+		if !command.MatchString(c) {
+			t.Errorf("%q does not match", c)
+		}
+	}
+	for _, c := range []string{"show ip bgp summaryx", "s ip bgp summary", "show ip bgp summary | include x"} {
+		if command.MatchString(c) {
+			t.Errorf("%q matches", c)
+		}
+	}
+}
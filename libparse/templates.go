@@ -0,0 +1,195 @@
+package libparse
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+/*
+bundled is a template for the command of a platform. The command uses the
+abbreviations of the ntc-templates index, e.g. "sh[[ow]]" matches sh, sho and show.
+*/
+type bundled struct {
+	platform string
+	command  string
+	source   string
+}
+
+var bundledTemplates = []bundled{
+	{"netiron", "sh[[ow]] ip b[[gp]] s[[ummary]]", netironBgpSummary},
+	{"netiron", "sh[[ow]] int[[erfaces]] b[[rief]]", netironInterfacesBrief},
+	{"netiron", "sh[[ow]] ver[[sion]]", netironVersion},
+	{"netiron", "sh[[ow]] ip cac[[he]]", netironIPCache},
+	{"junos", "sh[[ow]] bgp su[[mmary]]", junosBgpSummary},
+	{"slx", "sh[[ow]] ip b[[gp]] s[[ummary]]", netironBgpSummary},
+	{"slx", "sh[[ow]] ip int[[erface]] b[[rief]]", slxIPInterfaceBrief},
+	{"slx", "sh[[ow]] ver[[sion]]", slxVersion},
+}
+
+/* entry is a bundled template with its compiled command */
+type entry struct {
+	platform string
+	command  *regexp.Regexp
+	template *Template
+}
+
+var entries = compileBundled()
+
+/* compileBundled compiles the bundled templates, an invalid one is a bug */
+func compileBundled() (compiled []entry) {
+	for _, b := range bundledTemplates {
+		t, err := ParseTemplate(strings.NewReader(b.source))
+		if err != nil {
+			panic(fmt.Sprintf("bundled template %s %s: %s", b.platform, b.command, err))
+		}
+		compiled = append(compiled, entry{b.platform, CommandRegexp(b.command), t})
+	}
+	return
+}
+
+/*
+CommandRegexp compiles a command with abbreviations like "sh[[ow]] ver[[sion]]",
+words are separated by any whitespace.
+*/
+func CommandRegexp(command string) *regexp.Regexp {
+	var words []string
+
+	for _, word := range strings.Fields(command) {
+		var pattern string
+		if open := strings.Index(word, "[["); open >= 0 && strings.HasSuffix(word, "]]") {
+			pattern = regexp.QuoteMeta(word[:open])
+			optional := word[open+2 : len(word)-2]
+			for _, c := range optional {
+				pattern += "(?:" + regexp.QuoteMeta(string(c))
+			}
+			pattern += strings.Repeat(")?", len(optional))
+		} else {
+			pattern = regexp.QuoteMeta(word)
+		}
+		words = append(words, pattern)
+	}
+
+	return regexp.MustCompile(`^\s*` + strings.Join(words, `\s+`) + `\s*$`)
+}
+
+/*Lookup returns the bundled template for the command on the platform, nil if there is none */
+func Lookup(platform, command string) *Template {
+	for _, e := range entries {
+		if e.platform == platform && e.command.MatchString(command) {
+			return e.template
+		}
+	}
+	return nil
+}
+
+const netironBgpSummary = `Value Filldown ROUTER_ID (\S+)
+Value Filldown LOCAL_AS (\d+)
+Value Required NEIGHBOR (\S+)
+Value REMOTE_AS (\d+)
+Value STATE (\S+)
+Value UPTIME (.+?)
+Value ACCEPTED (\d+)
+Value FILTERED (\d+)
+Value SENT (\d+)
+Value TO_SEND (\d+)
+
+Start
+  ^\s*Router ID:\s+${ROUTER_ID}\s+Local AS Number:\s+${LOCAL_AS}
+  ^\s*Neighbor Address\s+ -> Neighbors
+
+Neighbors
+  ^\s*${NEIGHBOR}\s+${REMOTE_AS}\s+${STATE}\s+${UPTIME}\s+${ACCEPTED}\s+${FILTERED}\s+${SENT}\s+${TO_SEND}\s*$$ -> Record
+`
+
+const netironInterfacesBrief = `Value Required PORT (\S+)
+Value LINK (\S+)
+Value STATE (\S+)
+Value DUPLEX (\S+)
+Value SPEED (\S+)
+Value TRUNK (\S+)
+Value TAG (\S+)
+Value PRIORITY (\S+)
+Value MAC ([0-9a-fA-F]{4}\.[0-9a-fA-F]{4}\.[0-9a-fA-F]{4})
+Value NAME (.*?)
+Value TYPE (\S+)
+
+Start
+  ^\s*Port\s+Link\s+ -> Interfaces
+
+Interfaces
+  ^\s*${PORT}\s+${LINK}\s+${STATE}\s+${DUPLEX}\s+${SPEED}\s+${TRUNK}\s+${TAG}\s+${PRIORITY}\s+${MAC}\s+${NAME}\s*${TYPE}\s*$$ -> Record
+`
+
+const netironVersion = `Value PLATFORM (.+?)
+Value SERIAL (\w+)
+Value VERSION (\S+)
+Value UPTIME (.+?)
+
+Start
+  ^\s*System:\s+${PLATFORM}\s+\(Serial #:\s+${SERIAL}
+  ^\s*IronWare\s*:\s*Version\s+${VERSION}\s -> Uptime
+
+Uptime
+  ^\s*(?:System|The system)\s+uptime is\s+${UPTIME}\s*$$
+`
+
+const netironIPCache = `Value Required IP_ADDRESS (\d+\.\d+\.\d+\.\d+)
+Value NEXT_HOP (\S+)
+Value MAC (\S+)
+Value TYPE (\S+)
+Value PORT (\S+)
+Value VLAN (\d*)
+Value PRIORITY (\d+)
+
+Start
+  ^\s*\d+\s+${IP_ADDRESS}\s+${NEXT_HOP}\s+${MAC}\s+${TYPE}\s+${PORT}\s+${VLAN}\s*${PRIORITY}\s*$$ -> Record
+`
+
+const junosBgpSummary = `Value Required PEER (\S+)
+Value REMOTE_AS (\d+)
+Value INPUT_PACKETS (\d+)
+Value OUTPUT_PACKETS (\d+)
+Value OUTPUT_QUEUE (\d+)
+Value FLAPS (\d+)
+Value LAST_UP_DOWN (\S+)
+Value STATE (\S+)
+Value ACTIVE (\d+)
+Value RECEIVED (\d+)
+Value ACCEPTED (\d+)
+Value DAMPED (\d+)
+
+Start
+  ^Peer\s+AS\s+InPkt -> Peers
+
+Peers
+  ^[0-9a-fA-F.:]+\s+\d+\s+\d+ -> Continue.Record
+  ^${PEER}\s+${REMOTE_AS}\s+${INPUT_PACKETS}\s+${OUTPUT_PACKETS}\s+${OUTPUT_QUEUE}\s+${FLAPS}\s+${LAST_UP_DOWN}\s+${ACTIVE}/${RECEIVED}/${ACCEPTED}/${DAMPED}
+  ^${PEER}\s+${REMOTE_AS}\s+${INPUT_PACKETS}\s+${OUTPUT_PACKETS}\s+${OUTPUT_QUEUE}\s+${FLAPS}\s+${LAST_UP_DOWN}\s+${STATE}\s*$$
+  ^\s+inet6?\.0:\s+${ACTIVE}/${RECEIVED}/${ACCEPTED}/${DAMPED}
+`
+
+const slxIPInterfaceBrief = `Value Required INTERFACE (\S+\s+\S+)
+Value IP_ADDRESS (\S+)
+Value VRF (\S+)
+Value STATUS (.+?)
+Value PROTOCOL (\S+)
+
+Start
+  ^={3,} -> Interfaces
+
+Interfaces
+  ^${INTERFACE}\s+${IP_ADDRESS}\s+${VRF}\s+${STATUS}\s+${PROTOCOL}\s*$$ -> Record
+`
+
+const slxVersion = `Value VERSION (\S+)
+Value FIRMWARE (\S+)
+Value KERNEL (\S+)
+Value UPTIME (.+?)
+
+Start
+  ^SLX-OS Operating System Version:\s+${VERSION}
+  ^Firmware name:\s+${FIRMWARE}
+  ^Kernel:\s+${KERNEL}
+  ^System Uptime:\s+${UPTIME}\s*$$
+`
@@ -0,0 +1,446 @@
+package libparse
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+/*
+Template is a TextFSM template. Values declare the columns of the table, the
+rules of the states match lines, assign values and record rows.
+*/
+type Template struct {
+	values []templateValue
+	states map[string][]rule
+}
+
+/* templateValue is a "Value [Options] Name (regex)" line */
+type templateValue struct {
+	name                                  string
+	regex                                 string
+	filldown, key, required, list, fillup bool
+}
+
+/* rule is a "^regex -> LineOp.RecordOp NewState" line of a state */
+type rule struct {
+	match    *regexp.Regexp
+	source   string
+	lineOp   string
+	recordOp string
+	newState string
+	errorMsg string
+}
+
+var (
+	valueLine  = regexp.MustCompile(`^Value\s+(?:(\S+)\s+)?(\w+)\s+(\(.*\))\s*$`)
+	stateLine  = regexp.MustCompile(`^\w+$`)
+	actionLine = regexp.MustCompile(`^(.*?)\s+->\s*(.*)$`)
+	varRef     = regexp.MustCompile(`\$\$|\$\{(\w+)\}|\$(\w+)`)
+)
+
+/*ParseTemplate reads a template in TextFSM syntax */
+func ParseTemplate(r io.Reader) (*Template, error) {
+	t := &Template{states: make(map[string][]rule)}
+	scanner := bufio.NewScanner(r)
+	var line int
+	var state string
+
+	/* the values come first, a blank line ends them */
+	for scanner.Scan() {
+		line++
+		text := strings.TrimRight(scanner.Text(), " \t")
+		if strings.HasPrefix(strings.TrimSpace(text), "#") {
+			continue
+		}
+		if text == "" {
+			if len(t.values) > 0 {
+				break
+			}
+			continue
+		}
+		if err := t.addValue(text); err != nil {
+			return nil, fmt.Errorf("Template line %d: %s", line, err)
+		}
+	}
+
+	if len(t.values) == 0 {
+		return nil, fmt.Errorf("Template has no values")
+	}
+
+	for scanner.Scan() {
+		line++
+		text := strings.TrimRight(scanner.Text(), " \t")
+		switch {
+		case text == "", strings.HasPrefix(strings.TrimSpace(text), "#"):
+		case stateLine.MatchString(text):
+			if _, ok := t.states[text]; ok {
+				return nil, fmt.Errorf("Template line %d: state %s is declared twice", line, text)
+			}
+			if text == "End" {
+				return nil, fmt.Errorf("Template line %d: state End is reserved", line)
+			}
+			state = text
+			t.states[state] = nil
+		case state == "":
+			return nil, fmt.Errorf("Template line %d: rule outside of a state", line)
+		default:
+			r, err := t.parseRule(strings.TrimSpace(text))
+			if err != nil {
+				return nil, fmt.Errorf("Template line %d: %s", line, err)
+			}
+			t.states[state] = append(t.states[state], r)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return t, t.validate()
+}
+
+/* addValue parses a Value line */
+func (t *Template) addValue(text string) error {
+	m := valueLine.FindStringSubmatch(text)
+	if m == nil {
+		return fmt.Errorf("%q is no value, use 'Value [Options] Name (regex)'", text)
+	}
+
+	v := templateValue{name: m[2], regex: m[3]}
+	if _, err := regexp.Compile(v.regex); err != nil {
+		return fmt.Errorf("value %s has no valid regex: %s", v.name, err)
+	}
+
+	if m[1] != "" {
+		for _, option := range strings.Split(m[1], ",") {
+			switch option {
+			case "Filldown":
+				v.filldown = true
+			case "Key":
+				v.key = true
+			case "Required":
+				v.required = true
+			case "List":
+				v.list = true
+			case "Fillup":
+				v.fillup = true
+			default:
+				return fmt.Errorf("value %s has the unknown option %s", v.name, option)
+			}
+		}
+	}
+
+	if t.index(v.name) >= 0 {
+		return fmt.Errorf("value %s is declared twice", v.name)
+	}
+	t.values = append(t.values, v)
+
+	return nil
+}
+
+/* parseRule parses a rule and replaces the value names in its regex */
+func (t *Template) parseRule(text string) (rule, error) {
+	var r rule
+	var err error
+
+	if !strings.HasPrefix(text, "^") {
+		return r, fmt.Errorf("rule %q needs to start with ^", text)
+	}
+
+	r.source = text
+	if m := actionLine.FindStringSubmatch(text); m != nil {
+		r.source = m[1]
+		if err = r.parseAction(m[2]); err != nil {
+			return r, err
+		}
+	}
+
+	var unknown string
+	expanded := varRef.ReplaceAllStringFunc(r.source, func(ref string) string {
+		if ref == "$$" {
+			return "$"
+		}
+		name := strings.Trim(ref, "${}")
+		x := t.index(name)
+		if x < 0 {
+			unknown = name
+			return ref
+		}
+		return "(?P<" + name + ">" + t.values[x].regex[1:]
+	})
+
+	if unknown != "" {
+		return r, fmt.Errorf("rule %q uses the unknown value %s", text, unknown)
+	}
+
+	if r.match, err = regexp.Compile(expanded); err != nil {
+		return r, fmt.Errorf("rule %q has no valid regex: %s", text, err)
+	}
+
+	return r, nil
+}
+
+/* parseAction parses "Next.Record NewState", "Record", "NewState" or "Error message" */
+func (r *rule) parseAction(action string) error {
+	fields := strings.Fields(action)
+	if len(fields) == 0 {
+		return fmt.Errorf("rule %q has an empty action", r.source)
+	}
+
+	if fields[0] == "Error" {
+		r.lineOp = "Error"
+		r.errorMsg = strings.Trim(strings.TrimSpace(strings.TrimPrefix(action, "Error")), `"`)
+		return nil
+	}
+
+	if len(fields) > 2 {
+		return fmt.Errorf("rule %q has too many actions", r.source)
+	}
+
+	operations := strings.SplitN(fields[0], ".", 2)
+	switch {
+	case isLineOp(operations[0]) && len(operations) == 2 && isRecordOp(operations[1]):
+		r.lineOp, r.recordOp = operations[0], operations[1]
+	case isLineOp(operations[0]) && len(operations) == 1:
+		r.lineOp = operations[0]
+	case isRecordOp(operations[0]) && len(operations) == 1:
+		r.recordOp = operations[0]
+	case len(fields) == 1 && stateLine.MatchString(fields[0]):
+		r.newState = fields[0]
+	default:
+		return fmt.Errorf("rule %q has the unknown action %s", r.source, fields[0])
+	}
+
+	if len(fields) == 2 {
+		r.newState = fields[1]
+	}
+
+	if r.lineOp == "Continue" && r.newState != "" {
+		return fmt.Errorf("rule %q can not change the state with Continue", r.source)
+	}
+
+	return nil
+}
+
+func isLineOp(op string) bool {
+	return op == "Next" || op == "Continue"
+}
+
+func isRecordOp(op string) bool {
+	return op == "Record" || op == "NoRecord" || op == "Clear" || op == "Clearall"
+}
+
+/* validate checks, that Start exists and all new states are declared */
+func (t *Template) validate() error {
+	if _, ok := t.states["Start"]; !ok {
+		return fmt.Errorf("Template has no Start state")
+	}
+
+	if rules, ok := t.states["EOF"]; ok && len(rules) > 0 {
+		return fmt.Errorf("Template state EOF has to be empty")
+	}
+
+	for name, rules := range t.states {
+		for _, r := range rules {
+			if _, ok := t.states[r.newState]; r.newState != "" && r.newState != "End" && !ok {
+				return fmt.Errorf("Template state %s uses the unknown state %s", name, r.newState)
+			}
+		}
+	}
+
+	return nil
+}
+
+/* index returns the column of a value or -1 */
+func (t *Template) index(name string) int {
+	for x := range t.values {
+		if t.values[x].name == name {
+			return x
+		}
+	}
+	return -1
+}
+
+/*Header returns the names of the values, they are the columns of the table */
+func (t *Template) Header() []string {
+	var header []string
+	for _, v := range t.values {
+		header = append(header, v.name)
+	}
+	return header
+}
+
+/*
+Table holds the rows of a parsed output. A cell is a string, or a []string for
+List values.
+*/
+type Table struct {
+	Header []string
+	Rows   [][]interface{}
+}
+
+/* parser is the state of a single run of a template */
+type parser struct {
+	*Template
+	current []interface{}
+	table   Table
+}
+
+/*Parse runs the state machine of the template over the output and returns the recorded rows */
+func (t *Template) Parse(output string) (Table, error) {
+	p := &parser{Template: t, table: Table{Header: t.Header()}}
+	p.clear(true)
+
+	state := "Start"
+	for _, line := range strings.Split(strings.Replace(output, "\r", "", -1), "\n") {
+		var err error
+		if state, err = p.line(state, line); err != nil {
+			return p.table, err
+		}
+		if state == "End" || state == "EOF" {
+			break
+		}
+	}
+
+	/* an implicit record at the end, unless the template has an EOF state */
+	if _, ok := t.states["EOF"]; state != "End" && !ok {
+		p.record()
+	}
+
+	return p.table, nil
+}
+
+/* line runs the rules of the state on a line and returns the next state */
+func (p *parser) line(state, line string) (string, error) {
+	for _, r := range p.states[state] {
+		m := r.match.FindStringSubmatchIndex(line)
+		if m == nil {
+			continue
+		}
+
+		for x, name := range r.match.SubexpNames() {
+			if column := p.index(name); name != "" && column >= 0 && m[2*x] >= 0 {
+				p.assign(column, line[m[2*x]:m[2*x+1]])
+			}
+		}
+
+		switch r.recordOp {
+		case "Record":
+			p.record()
+		case "Clear":
+			p.clear(false)
+		case "Clearall":
+			p.clear(true)
+		}
+
+		switch r.lineOp {
+		case "Error":
+			if r.errorMsg == "" {
+				return state, fmt.Errorf("Template error rule %s matched line %q", r.source, line)
+			}
+			return state, fmt.Errorf("%s: %q", r.errorMsg, line)
+		case "Continue":
+			continue
+		}
+
+		if r.newState != "" {
+			return r.newState, nil
+		}
+		return state, nil
+	}
+
+	return state, nil
+}
+
+/* assign sets a value, List values collect every match, Fillup fills the empty cells above */
+func (p *parser) assign(column int, value string) {
+	v := p.values[column]
+
+	if v.list {
+		p.current[column] = append(p.current[column].([]string), value)
+		return
+	}
+
+	p.current[column] = value
+
+	if v.fillup && value != "" {
+		for x := len(p.table.Rows) - 1; x >= 0 && p.table.Rows[x][column] == ""; x-- {
+			p.table.Rows[x][column] = value
+		}
+	}
+}
+
+/* record appends the current row, rows without a Required value or without any value are skipped */
+func (p *parser) record() {
+	var empty = true
+
+	defer p.clear(false)
+
+	for x, v := range p.values {
+		if isEmpty(p.current[x]) {
+			if v.required {
+				return
+			}
+			continue
+		}
+		empty = false
+	}
+
+	if empty {
+		return
+	}
+
+	row := make([]interface{}, len(p.current))
+	for x, cell := range p.current {
+		if list, ok := cell.([]string); ok {
+			cell = append([]string{}, list...)
+		}
+		row[x] = cell
+	}
+	p.table.Rows = append(p.table.Rows, row)
+}
+
+/* clear resets the current row, Filldown values are kept unless all values are cleared */
+func (p *parser) clear(all bool) {
+	if p.current == nil {
+		p.current = make([]interface{}, len(p.values))
+	}
+
+	for x, v := range p.values {
+		switch {
+		case v.filldown && !all && p.current[x] != nil:
+		case v.list:
+			p.current[x] = []string{}
+		default:
+			p.current[x] = ""
+		}
+	}
+}
+
+func isEmpty(cell interface{}) bool {
+	switch c := cell.(type) {
+	case string:
+		return c == ""
+	case []string:
+		return len(c) == 0
+	}
+	return true
+}
+
+/*Records returns the rows as maps from the value names to the cells */
+func (t Table) Records() []map[string]interface{} {
+	var records []map[string]interface{}
+
+	for _, row := range t.Rows {
+		record := make(map[string]interface{}, len(row))
+		for x, cell := range row {
+			record[t.Header[x]] = cell
+		}
+		records = append(records, record)
+	}
+
+	return records
+}
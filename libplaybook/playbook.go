@@ -0,0 +1,168 @@
+package libplaybook
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ipcjk/mlxsh/libcheck"
+	"github.com/ipcjk/mlxsh/libhost"
+	"gopkg.in/yaml.v1"
+)
+
+/*
+Playbook is a list of ordered steps, that run on all hosts matching the
+label selector in Targets. A step runs on all hosts, before the next step starts.
+*/
+type Playbook struct {
+	Name    string `yaml:"name"`
+	Targets string `yaml:"targets"`
+	Steps   []Step `yaml:"steps"`
+}
+
+/*
+Step is a single action of a playbook: exec, config (with optional commit),
+commit, wait, assert, pause or local. When is a label selector like
+"location=munich,role!=core" or a template, that renders to true or false.
+Register saves the output of the step for later templates as .Registered.name.
+*/
+type Step struct {
+	Name     string          `yaml:"name"`
+	Exec     string          `yaml:"exec"`
+	Config   string          `yaml:"config"`
+	Commit   bool            `yaml:"commit"`
+	Wait     string          `yaml:"wait"`
+	Assert   *libcheck.Check `yaml:"assert"`
+	Pause    string          `yaml:"pause"`
+	Local    string          `yaml:"local"`
+	When     string          `yaml:"when"`
+	Register string          `yaml:"register"`
+
+	wait time.Duration
+}
+
+/*LoadPlaybook reads a playbook from a yaml reader source and validates it */
+func LoadPlaybook(r io.Reader) (Playbook, error) {
+	var playbook Playbook
+
+	source, err := ioutil.ReadAll(r)
+	if err != nil {
+		return playbook, fmt.Errorf("Cant read from yaml source: %s", err)
+	}
+
+	if err = yaml.Unmarshal(source, &playbook); err != nil {
+		return playbook, fmt.Errorf("Cant parse yaml source: %s", err)
+	}
+
+	if len(playbook.Steps) == 0 {
+		return playbook, fmt.Errorf("Playbook %s has no steps", playbook.Name)
+	}
+
+	for x := range playbook.Steps {
+		if err := playbook.Steps[x].validate(x + 1); err != nil {
+			return playbook, err
+		}
+	}
+
+	return playbook, nil
+}
+
+func (s *Step) validate(number int) (err error) {
+	var actions int
+
+	if s.Name == "" {
+		s.Name = fmt.Sprintf("step %d", number)
+	}
+
+	for _, action := range []bool{s.Exec != "", s.Config != "", s.Commit && s.Config == "",
+		s.Wait != "", s.Assert != nil, s.Pause != "", s.Local != ""} {
+		if action {
+			actions++
+		}
+	}
+
+	if actions != 1 {
+		return fmt.Errorf("Step %s needs exactly one of exec, config, commit, wait, assert, pause or local", s.Name)
+	}
+
+	if s.Wait != "" {
+		if s.wait, err = time.ParseDuration(s.Wait); err != nil {
+			return fmt.Errorf("Step %s has no valid wait: %s", s.Name, err)
+		}
+	}
+
+	if s.Assert != nil {
+		if err = s.Assert.Compile(); err != nil {
+			return fmt.Errorf("Step %s: %s", s.Name, err)
+		}
+	}
+
+	if (s.Pause != "" || s.Wait != "") && (s.When != "" || s.Register != "") {
+		return fmt.Errorf("Step %s: pause and wait run once for all hosts, when and register are not possible", s.Name)
+	}
+
+	return nil
+}
+
+/* needsDevice returns true, if the step talks to the device */
+func (s Step) needsDevice() bool {
+	return s.Exec != "" || s.Config != "" || s.Commit || s.Assert != nil
+}
+
+/*
+source returns the statements of an exec or config step. An existing file is read,
+otherwise the value is used directly and a single line is split at ;
+*/
+func source(value string) (string, error) {
+	if strings.Contains(value, "\n") {
+		return value, nil
+	}
+
+	content, err := ioutil.ReadFile(value)
+	if err != nil && os.IsNotExist(err) {
+		return strings.Replace(value, ";", "\n", -1), nil
+	} else if err != nil {
+		return "", fmt.Errorf("Cant open file: %s", err)
+	}
+
+	return string(content), nil
+}
+
+/*
+MatchSelector checks a selector like "location=munich,role!=core" against the
+labels and vars of a host, labels have precedence. A missing key is empty.
+*/
+func MatchSelector(host libhost.HostConfig, selector string) bool {
+	for _, term := range strings.Split(selector, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+
+		negate := strings.Contains(term, "!=")
+		parts := strings.SplitN(strings.Replace(term, "!=", "=", 1), "=", 2)
+
+		key := strings.TrimSpace(parts[0])
+		value, ok := host.Labels[key]
+		if !ok {
+			value = host.Vars[key]
+		}
+
+		if len(parts) == 1 {
+			/* a single key needs to be set */
+			if value == "" {
+				return false
+			}
+			continue
+		}
+
+		if (value == strings.TrimSpace(parts[1])) == negate {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,311 @@
+package libplaybook_test
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ipcjk/mlxsh/libhost"
+	"github.com/ipcjk/mlxsh/libplaybook"
+	"github.com/ipcjk/mlxsh/routerDevice"
+)
+
+var playbookYaml = `
+name: bgp maintenance
+targets: role=edge
+steps:
+  - name: version
+    exec: show version
+    register: version
+  - name: drain
+    config: "router bgp;neighbor {{.Vars.peer}} shutdown"
+    commit: true
+    when: location=munich
+  - name: wait
+    wait: 1ms
+  - name: neighbor down
+    assert:
+      command: show ip bgp summary
+      match: 'ESTAB'
+      count: true
+      expect: "== 0"
+  - name: echo
+    exec: "echo {{.Registered.version}}"
+`
+
+/* fakeDevice answers commands from a map like a terminal with echo and prompt and records all calls */
+type fakeDevice struct {
+	w       io.Writer
+	prompt  string
+	answers map[string]string
+	calls   *[]string
+	outputs []router.CommandOutput
+}
+
+func (f *fakeDevice) record(call string) {
+	*f.calls = append(*f.calls, call)
+}
+
+func (f *fakeDevice) Connect() error               { f.record("connect"); return nil }
+func (f *fakeDevice) ConfigureTerminalMode() error { f.record("configure"); return nil }
+func (f *fakeDevice) CommitConfiguration() error   { f.record("commit"); return nil }
+func (f *fakeDevice) Close()                       { f.record("close") }
+
+func (f *fakeDevice) PasteConfiguration(r io.Reader) error {
+	content, _ := ioutil.ReadAll(r)
+	f.record("paste " + strings.Replace(string(content), "\n", ";", -1))
+	return nil
+}
+
+func (f *fakeDevice) RunCommands(r io.Reader) error {
+	content, _ := ioutil.ReadAll(r)
+	command := strings.TrimSpace(string(content))
+	f.record("exec " + command)
+	answer, ok := f.answers[command]
+	if !ok {
+		return fmt.Errorf("Command failed: %s", command)
+	}
+	fmt.Fprintf(f.w, "%s %s\n%s%s", f.prompt, command, answer, f.prompt)
+	f.outputs = append(f.outputs, router.CommandOutput{Command: command, Output: strings.TrimSpace(answer)})
+	return nil
+}
+
+func (f *fakeDevice) CommandOutputs() []router.CommandOutput {
+	return f.outputs
+}
+
+func TestRun(t *testing.T) {
+	playbook, err := libplaybook.LoadPlaybook(strings.NewReader(playbookYaml))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mutex sync.Mutex
+	calls := make(map[string]*[]string)
+	hosts := []libhost.HostConfig{
+		{Hostname: "edge1", Labels: map[string]string{"location": "munich"}, Vars: map[string]string{"peer": "192.0.2.1"}},
+		{Hostname: "edge2", Labels: map[string]string{"location": "frankfurt"}},
+	}
+
+	runner := libplaybook.Runner{
+		Playbook: playbook,
+		Hosts:    hosts,
+		Parallel: 2,
+		NewDevice: func(host libhost.HostConfig, w io.Writer) libplaybook.Device {
+			answers := map[string]string{
+				"show version":        "V5.8\n",
+				"show ip bgp summary": "no neighbors\n",
+				"echo V5.8":           "V5.8\n",
+			}
+			if host.Hostname == "edge2" {
+				answers["show ip bgp summary"] = "192.0.2.1 65001 ESTAB\n"
+			}
+			mutex.Lock()
+			defer mutex.Unlock()
+			calls[host.Hostname] = new([]string)
+			return &fakeDevice{w: w, prompt: host.Hostname + "#", answers: answers, calls: calls[host.Hostname]}
+		},
+	}
+
+	var reported []string
+	failed, err := runner.Run(func(step libplaybook.Step, results []libplaybook.StepResult) {
+		for _, r := range results {
+			state := "ok"
+			if r.Skipped {
+				state = "skip"
+			} else if r.Err != nil {
+				state = "failed"
+			}
+			reported = append(reported, fmt.Sprintf("%s/%s=%s", step.Name, r.Hostname, state))
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if failed != 1 {
+		t.Errorf("Expected one failed host, got %d", failed)
+	}
+
+	expected := "version/edge1=ok version/edge2=ok drain/edge1=ok drain/edge2=skip " +
+		"neighbor down/edge1=ok neighbor down/edge2=failed echo/edge1=ok echo/edge2=skip"
+	if strings.Join(reported, " ") != expected {
+		t.Errorf("Wrong results:\n%s\nexpected\n%s", strings.Join(reported, " "), expected)
+	}
+
+	expected = "connect,exec show version,configure,paste router bgp;neighbor 192.0.2.1 shutdown,commit," +
+		"exec show ip bgp summary,exec echo V5.8,close"
+	if strings.Join(*calls["edge1"], ",") != expected {
+		t.Errorf("Wrong calls on edge1:\n%s", strings.Join(*calls["edge1"], ","))
+	}
+}
+
+func TestAssertIgnoresEcho(t *testing.T) {
+	playbook, err := libplaybook.LoadPlaybook(strings.NewReader(`
+steps:
+  - name: default route
+    assert:
+      command: show ip route 0.0.0.0/0
+      match: '0\.0\.0\.0/0'
+      expect: present
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runner := libplaybook.Runner{
+		Playbook: playbook,
+		Hosts:    []libhost.HostConfig{{Hostname: "edge1"}},
+		NewDevice: func(host libhost.HostConfig, w io.Writer) libplaybook.Device {
+			answers := map[string]string{"show ip route 0.0.0.0/0": "No routes found\n"}
+			return &fakeDevice{w: w, prompt: "edge1#", answers: answers, calls: new([]string)}
+		},
+	}
+
+	failed, err := runner.Run(func(step libplaybook.Step, results []libplaybook.StepResult) {})
+	if err != nil || failed != 1 {
+		t.Errorf("Assert passed on the echoed command: %d failed, %v", failed, err)
+	}
+}
+
+/* dryRunFake is a router module with an own dry-run, that rewrites the mlxsh_ macros */
+type dryRunFake struct {
+	fakeDevice
+}
+
+func (f *dryRunFake) DryRunLogin() {
+	fmt.Fprint(f.w, "[driver] connect\n")
+}
+
+func (f *dryRunFake) DryRunExec(commands io.Reader) error {
+	content, _ := ioutil.ReadAll(commands)
+	fmt.Fprintf(f.w, "[driver] exec %s\n", strings.Replace(strings.TrimSpace(string(content)), "mlxsh_bgp", "show ip bgp summary", -1))
+	return nil
+}
+
+func (f *dryRunFake) DryRunPaste(configuration io.Reader) error {
+	content, _ := ioutil.ReadAll(configuration)
+	fmt.Fprintf(f.w, "[driver] conf t\n+ %s\n", strings.TrimSpace(string(content)))
+	return nil
+}
+
+func (f *dryRunFake) DryRunCommit() error {
+	fmt.Fprint(f.w, "[driver] write memory\n")
+	return nil
+}
+
+func TestDryRunDriver(t *testing.T) {
+	playbook, err := libplaybook.LoadPlaybook(strings.NewReader(`
+steps:
+  - name: bgp
+    exec: mlxsh_bgp
+  - name: drain
+    config: "neighbor 192.0.2.1 shutdown"
+    commit: true
+  - name: prepare
+    config: "neighbor 192.0.2.1 description drained"
+  - name: save
+    commit: true
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var calls []string
+	runner := libplaybook.Runner{
+		Playbook: playbook,
+		Hosts:    []libhost.HostConfig{{Hostname: "edge1"}},
+		DryRun:   true,
+		NewDevice: func(host libhost.HostConfig, w io.Writer) libplaybook.Device {
+			return &dryRunFake{fakeDevice{w: w, prompt: "edge1#", calls: &calls}}
+		},
+	}
+
+	var output []string
+	if _, err := runner.Run(func(step libplaybook.Step, results []libplaybook.StepResult) {
+		output = append(output, results[0].Output)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"[driver] connect\n[driver] exec show ip bgp summary\n",
+		"[driver] conf t\n+ neighbor 192.0.2.1 shutdown\n[driver] write memory\n",
+		"[driver] conf t\n+ neighbor 192.0.2.1 description drained\n",
+		"[driver] write memory\n",
+	}
+	if len(output) != len(expected) {
+		t.Fatalf("Dry-run does not use the module:\n%q", output)
+	}
+	for x := range expected {
+		if output[x] != expected[x] {
+			t.Errorf("Wrong dry-run output for step %d: %q", x+1, output[x])
+		}
+	}
+
+	if len(calls) != 0 {
+		t.Errorf("Dry-run called the module: %v", calls)
+	}
+}
+
+func TestPause(t *testing.T) {
+	playbook, err := libplaybook.LoadPlaybook(strings.NewReader("steps:\n  - pause: continue?\n  - local: 'true'\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runner := libplaybook.Runner{
+		Playbook: playbook,
+		Hosts:    []libhost.HostConfig{{Hostname: "edge1"}},
+		Confirm:  func(string) bool { return false },
+	}
+
+	var steps int
+	if _, err := runner.Run(func(libplaybook.Step, []libplaybook.StepResult) { steps++ }); err == nil || steps != 0 {
+		t.Errorf("Declined pause did not stop the playbook: %v, %d steps", err, steps)
+	}
+}
+
+func TestMatchSelector(t *testing.T) {
+	host := libhost.HostConfig{
+		Labels: map[string]string{"location": "munich", "role": "edge"},
+		Vars:   map[string]string{"asn": "65001"},
+	}
+
+	var selectors = []struct {
+		selector string
+		matches  bool
+	}{
+		{"location=munich", true},
+		{"location=munich,role!=core", true},
+		{"role!=edge", false},
+		{"asn=65001", true},
+		{"location", true},
+		{"rack", false},
+		{"", true},
+	}
+
+	for _, s := range selectors {
+		if libplaybook.MatchSelector(host, s.selector) != s.matches {
+			t.Errorf("Selector %q: expected %v", s.selector, s.matches)
+		}
+	}
+}
+
+func TestPlaybookErrors(t *testing.T) {
+	for _, source := range []string{
+		"name: empty\n",
+		"steps:\n  - exec: show version\n    local: 'true'\n",
+		"steps:\n  - wait: soon\n",
+		"steps:\n  - pause: continue?\n    when: role=edge\n",
+		"steps:\n  - assert:\n      command: show version\n      match: '('\n",
+		"steps:\n  - name: nothing\n",
+	} {
+		if _, err := libplaybook.LoadPlaybook(strings.NewReader(source)); err == nil {
+			t.Errorf("Invalid playbook accepted: %s", source)
+		}
+	}
+}
@@ -0,0 +1,410 @@
+package libplaybook
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipcjk/mlxsh/libcheck"
+	"github.com/ipcjk/mlxsh/libhost"
+	"github.com/ipcjk/mlxsh/libtemplate"
+	"github.com/ipcjk/mlxsh/routerDevice"
+)
+
+/*Device is the part of a router module, that a playbook needs */
+type Device interface {
+	Close()
+	CommitConfiguration() error
+	ConfigureTerminalMode() error
+	Connect() error
+	PasteConfiguration(io.Reader) error
+	RunCommands(io.Reader) error
+}
+
+/*StepResult is the outcome of a step on a single host */
+type StepResult struct {
+	Hostname string
+	Output   string
+	Err      error
+	Skipped  bool
+}
+
+/*
+Runner runs a playbook on hosts. NewDevice returns the router module for a
+host, that writes its output to w. Confirm answers pause steps.
+*/
+type Runner struct {
+	Playbook  Playbook
+	Hosts     []libhost.HostConfig
+	Data      map[interface{}]interface{}
+	Parallel  int
+	DryRun    bool
+	NewDevice func(host libhost.HostConfig, w io.Writer) Device
+	Confirm   func(question string) bool
+}
+
+/* hostState keeps the connection and the registered outputs of a host between steps */
+type hostState struct {
+	host       libhost.HostConfig
+	device     Device
+	buffer     *bytes.Buffer
+	registered map[string]string
+	failed     bool
+}
+
+/*
+Run runs all steps one after another and calls report with the results of
+every step. Failed hosts do not run the following steps. It returns the number
+of failed hosts and an error, if a pause was not confirmed.
+*/
+func (r *Runner) Run(report func(step Step, results []StepResult)) (failed int, err error) {
+	var states []*hostState
+
+	for _, host := range r.Hosts {
+		states = append(states, &hostState{host: host, buffer: new(bytes.Buffer), registered: make(map[string]string)})
+	}
+
+	defer func() {
+		for _, state := range states {
+			if state.device != nil {
+				state.device.Close()
+			}
+		}
+	}()
+
+	for _, step := range r.Playbook.Steps {
+		if countFailed(states) == len(states) {
+			break
+		}
+
+		switch {
+		case step.Pause != "":
+			if r.DryRun {
+				continue
+			}
+			if r.Confirm == nil || !r.Confirm(step.Pause+" [y/N] ") {
+				return countFailed(states), fmt.Errorf("Playbook aborted at step %s", step.Name)
+			}
+			continue
+		case step.wait > 0:
+			if !r.DryRun {
+				time.Sleep(step.wait)
+			}
+			continue
+		}
+
+		report(step, r.runStep(step, states))
+	}
+
+	return countFailed(states), nil
+}
+
+func countFailed(states []*hostState) (failed int) {
+	for _, state := range states {
+		if state.failed {
+			failed++
+		}
+	}
+	return
+}
+
+/* runStep runs a step in parallel on all hosts, that did not fail before */
+func (r *Runner) runStep(step Step, states []*hostState) []StepResult {
+	var wg sync.WaitGroup
+	var results = make([]StepResult, len(states))
+
+	parallel := r.Parallel
+	if parallel < 1 {
+		parallel = 1
+	}
+	semaphore := make(chan struct{}, parallel)
+
+	for x := range states {
+		if states[x].failed {
+			results[x] = StepResult{Hostname: states[x].host.Hostname, Skipped: true}
+			continue
+		}
+
+		wg.Add(1)
+		go func(x int) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			results[x] = r.runHost(step, states[x])
+			if results[x].Err != nil {
+				states[x].failed = true
+			}
+		}(x)
+	}
+	wg.Wait()
+
+	return results
+}
+
+/* runHost runs a single step on a host */
+func (r *Runner) runHost(step Step, state *hostState) (result StepResult) {
+	result.Hostname = state.host.Hostname
+
+	if step.When != "" {
+		ok, err := r.when(step, state)
+		if err != nil {
+			result.Err = err
+			return
+		}
+		if !ok {
+			result.Skipped = true
+			return
+		}
+	}
+
+	/* exec steps register the output without echo and prompt, if the device records it */
+	var output string
+	var recorded bool
+
+	state.buffer.Reset()
+	defer func() {
+		result.Output = state.buffer.String()
+		if !recorded {
+			output = result.Output
+		}
+		if step.Register != "" && result.Err == nil {
+			state.registered[step.Register] = strings.TrimSpace(output)
+		}
+	}()
+
+	if step.Local != "" {
+		result.Err = r.local(step, state)
+		return
+	}
+
+	if state.device == nil && step.needsDevice() {
+		if result.Err = r.connect(state); result.Err != nil {
+			return
+		}
+		/* login banners and prompts are not part of the step output, a dry-run shows its connect once */
+		if !r.DryRun {
+			state.buffer.Reset()
+		}
+	}
+
+	switch {
+	case step.Exec != "":
+		var commands string
+		if commands, result.Err = r.render(step.Exec, state); result.Err == nil {
+			output, recorded, result.Err = r.exec(state, commands)
+		}
+	case step.Config != "":
+		var configuration string
+		if configuration, result.Err = r.render(step.Config, state); result.Err != nil {
+			return
+		}
+		if result.Err = state.device.ConfigureTerminalMode(); result.Err != nil {
+			return
+		}
+		if result.Err = state.device.PasteConfiguration(strings.NewReader(configuration)); result.Err != nil {
+			return
+		}
+		if step.Commit {
+			result.Err = state.device.CommitConfiguration()
+		}
+	case step.Commit:
+		result.Err = state.device.CommitConfiguration()
+	case step.Assert != nil:
+		result.Err = r.assert(step, state)
+	}
+
+	return
+}
+
+func (r *Runner) connect(state *hostState) error {
+	if r.DryRun {
+		dryRun := &dryRunDevice{w: state.buffer, host: state.host}
+		if r.NewDevice != nil {
+			dryRun.driver, _ = r.NewDevice(state.host, state.buffer).(router.DryRunStepper)
+		}
+		state.device = dryRun
+	} else if r.NewDevice != nil {
+		state.device = r.NewDevice(state.host, state.buffer)
+	}
+
+	if state.device == nil {
+		return fmt.Errorf("can't instance router object")
+	}
+
+	return state.device.Connect()
+}
+
+/* when evaluates the condition of a step, templates need to render to true */
+func (r *Runner) when(step Step, state *hostState) (bool, error) {
+	if !libtemplate.IsTemplate(step.When) {
+		return MatchSelector(state.host, step.When), nil
+	}
+
+	rendered, err := r.renderTemplate(step.When, state)
+	if err != nil {
+		return false, err
+	}
+
+	return strings.TrimSpace(rendered) == "true", nil
+}
+
+/* render reads the statements of a step and renders them for the host */
+func (r *Runner) render(value string, state *hostState) (string, error) {
+	statements, err := source(value)
+	if err != nil {
+		return "", err
+	}
+
+	if !libtemplate.IsTemplate(statements) {
+		return statements, nil
+	}
+
+	return r.renderTemplate(statements, state)
+}
+
+func (r *Runner) renderTemplate(source string, state *hostState) (string, error) {
+	return libtemplate.RenderData(r.Playbook.Name, source,
+		libtemplate.Data{HostConfig: state.host, Data: r.Data, Registered: state.registered})
+}
+
+/*
+exec runs the commands of a step and returns their output without echo and
+prompt, recorded is false, if the device does not keep its CommandOutputs
+*/
+func (r *Runner) exec(state *hostState, commands string) (output string, recorded bool, err error) {
+	var count int
+	outputer, recorded := state.device.(interface{ CommandOutputs() []router.CommandOutput })
+	if recorded {
+		count = len(outputer.CommandOutputs())
+	}
+
+	err = state.device.RunCommands(strings.NewReader(commands))
+	if !recorded {
+		return "", false, err
+	}
+
+	var outputs []string
+	for _, o := range outputer.CommandOutputs()[count:] {
+		outputs = append(outputs, o.Output)
+	}
+
+	return strings.Join(outputs, "\n"), true, err
+}
+
+/* assert runs the command of the check and fails, if its output without echo and prompt does not match */
+func (r *Runner) assert(step Step, state *hostState) error {
+	if r.DryRun {
+		return state.device.RunCommands(strings.NewReader(step.Assert.Command + "\n"))
+	}
+
+	output, err := router.RunCommand(state.device, state.buffer, step.Assert.Command)
+	if err != nil {
+		return err
+	}
+
+	result := libcheck.Evaluate(*step.Assert, output)
+	fmt.Fprintf(state.buffer, "%s: %s\n", result.Name, result.Value)
+
+	if !result.Passed {
+		return fmt.Errorf("Assert %s failed: %s, got %s", result.Name, result.Reason, result.Value)
+	}
+
+	return nil
+}
+
+/* local runs a shell command on the local machine, the host is passed in MLXSH_ variables */
+func (r *Runner) local(step Step, state *hostState) error {
+	command, err := r.renderTemplate(step.Local, state)
+	if err != nil {
+		return err
+	}
+
+	if r.DryRun {
+		fmt.Fprintf(state.buffer, "[local] %s\n", command)
+		return nil
+	}
+
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Env = append(os.Environ(),
+		"MLXSH_HOSTNAME="+state.host.Hostname,
+		"MLXSH_DEVICETYPE="+state.host.DeviceType,
+		"MLXSH_PLAYBOOK="+r.Playbook.Name,
+		"MLXSH_STEP="+step.Name)
+	cmd.Stdout = state.buffer
+	cmd.Stderr = state.buffer
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("Local command %s failed: %s", step.Name, err)
+	}
+
+	return nil
+}
+
+/*
+dryRunDevice writes the playbook actions instead of sending them. A router module,
+that is a DryRunStepper, writes its login once, exec steps, the paste of config
+steps and commits with its mode changes and rewritten mlxsh_ macros.
+*/
+type dryRunDevice struct {
+	w      io.Writer
+	host   libhost.HostConfig
+	driver router.DryRunStepper
+}
+
+func (d *dryRunDevice) Connect() error {
+	if d.driver != nil {
+		d.driver.DryRunLogin()
+		return nil
+	}
+	fmt.Fprintf(d.w, "[connect] %s\n", d.host.Hostname)
+	return nil
+}
+
+func (d *dryRunDevice) ConfigureTerminalMode() error {
+	if d.driver == nil {
+		fmt.Fprint(d.w, "[mode] configure\n")
+	}
+	return nil
+}
+
+func (d *dryRunDevice) PasteConfiguration(configuration io.Reader) error {
+	if d.driver != nil {
+		return d.driver.DryRunPaste(configuration)
+	}
+	return d.write("+ ", configuration)
+}
+
+func (d *dryRunDevice) CommitConfiguration() error {
+	if d.driver != nil {
+		return d.driver.DryRunCommit()
+	}
+	fmt.Fprint(d.w, "[mode] commit\n")
+	return nil
+}
+
+func (d *dryRunDevice) RunCommands(commands io.Reader) error {
+	if d.driver != nil {
+		return d.driver.DryRunExec(commands)
+	}
+	return d.write("> ", commands)
+}
+
+func (d *dryRunDevice) Close() {}
+
+func (d *dryRunDevice) write(prefix string, r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		fmt.Fprintf(d.w, "%s%s\n", prefix, scanner.Text())
+	}
+	return scanner.Err()
+}
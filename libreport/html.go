@@ -0,0 +1,84 @@
+package libreport
+
+import (
+	"html/template"
+	"io"
+	"time"
+)
+
+/* htmlReport is handed to the html template */
+type htmlReport struct {
+	Run
+	Duration            time.Duration
+	OK, Failed, Skipped int
+}
+
+var htmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"duration": func(d time.Duration) string { return d.Round(time.Millisecond).String() },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Name}} {{.Start.Format "2006-01-02 15:04:05"}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { text-align: left; padding: 0.2em 0.8em; border-bottom: 1px solid #ddd; }
+pre { background: #f6f6f6; padding: 0.6em; overflow-x: auto; }
+details { margin: 0.4em 0; }
+summary { cursor: pointer; font-weight: bold; }
+details details summary { font-weight: normal; font-family: monospace; }
+.ok { color: #1a7f37; } .failed { color: #cf222e; } .skipped { color: #9a6700; }
+</style>
+</head>
+<body>
+<h1>{{.Name}}</h1>
+<p>{{.Start.Format "2006-01-02 15:04:05"}}, {{duration .Duration}},
+{{len .Hosts}} hosts: <span class="ok">{{.OK}} ok</span>, <span class="failed">{{.Failed}} failed</span>, <span class="skipped">{{.Skipped}} skipped</span></p>
+
+<table>
+<tr><th>Host</th><th>Type</th><th>Status</th><th>Duration</th><th>Error</th></tr>
+{{- range .Hosts}}
+<tr><td><a href="#host-{{.Hostname}}">{{.Hostname}}</a></td><td>{{.DeviceType}}</td><td class="{{.Status}}">{{.Status}}</td><td>{{duration .Duration}}</td><td>{{.Error}}</td></tr>
+{{- end}}
+</table>
+
+{{range .Hosts}}
+<details id="host-{{.Hostname}}"{{if eq .Status "failed"}} open{{end}}>
+<summary><span class="{{.Status}}">{{.Status}}</span> {{.Hostname}}</summary>
+{{- if .Error}}
+<p class="failed">{{.Class}}: {{.Error}}</p>
+{{- end}}
+{{- if .Checks}}
+<table>
+<tr><th>Check</th><th>Name</th><th>Value</th><th>Result</th></tr>
+{{- range .Checks}}
+<tr><td>{{.Phase}}</td><td>{{.Name}}</td><td>{{.Value}}</td><td>{{if .Passed}}<span class="ok">passed</span>{{else}}<span class="failed">{{.Reason}}</span>{{end}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+{{- range .Commands}}
+<details{{if .Error}} open{{end}}>
+<summary>&gt; {{.Command}}</summary>
+<pre>{{.Output}}{{if .Error}}<span class="failed">{{.Error}}</span>{{end}}</pre>
+</details>
+{{- end}}
+{{- if .Output}}
+<pre>{{.Output}}</pre>
+{{- end}}
+</details>
+{{- end}}
+</body>
+</html>
+`))
+
+/*
+WriteHTML writes the run as a single html page with an overview of all hosts
+and the collapsible output of every host. Failed hosts are expanded.
+*/
+func WriteHTML(w io.Writer, run Run) error {
+	report := htmlReport{Run: run, Duration: run.End.Sub(run.Start)}
+	report.OK, report.Failed, report.Skipped = run.counts()
+
+	return htmlTemplate.Execute(w, report)
+}
@@ -0,0 +1,151 @@
+package libreport
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+type junitSuites struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Name     string       `xml:"name,attr"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Skipped  int          `xml:"skipped,attr"`
+	Time     float64      `xml:"time,attr"`
+	Suites   []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name      string      `xml:"name,attr"`
+	Tests     int         `xml:"tests,attr"`
+	Failures  int         `xml:"failures,attr"`
+	Errors    int         `xml:"errors,attr"`
+	Skipped   int         `xml:"skipped,attr"`
+	Time      float64     `xml:"time,attr"`
+	Timestamp string      `xml:"timestamp,attr"`
+	Cases     []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name       string          `xml:"name,attr"`
+	Classname  string          `xml:"classname,attr"`
+	Time       float64         `xml:"time,attr"`
+	Assertions int             `xml:"assertions,attr"`
+	Properties []junitProperty `xml:"properties>property,omitempty"`
+	Failure    *junitFailure   `xml:"failure,omitempty"`
+	Skipped    *junitSkipped   `xml:"skipped,omitempty"`
+	SystemOut  string          `xml:"system-out,omitempty"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr,omitempty"`
+}
+
+/*
+WriteJUnit writes the run as JUnit XML. Every host is a testcase, its
+post-checks are the assertions, failed checks are listed in the failure.
+*/
+func WriteJUnit(w io.Writer, run Run) error {
+	_, failed, skipped := run.counts()
+	duration := run.End.Sub(run.Start).Seconds()
+
+	suite := junitSuite{
+		Name:      run.Name,
+		Tests:     len(run.Hosts),
+		Failures:  failed,
+		Skipped:   skipped,
+		Time:      duration,
+		Timestamp: run.Start.Format("2006-01-02T15:04:05"),
+	}
+
+	for _, h := range run.Hosts {
+		suite.Cases = append(suite.Cases, junitTestCase(h))
+	}
+
+	suites := junitSuites{
+		Name:     run.Name,
+		Tests:    suite.Tests,
+		Failures: failed,
+		Skipped:  skipped,
+		Time:     duration,
+		Suites:   []junitSuite{suite},
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(suites); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func junitTestCase(h Host) junitCase {
+	var labels []string
+
+	testcase := junitCase{
+		Name:      h.Hostname,
+		Classname: "mlxsh." + h.DeviceType,
+		Time:      h.Duration.Seconds(),
+	}
+
+	for label := range h.Labels {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		testcase.Properties = append(testcase.Properties, junitProperty{Name: "label." + label, Value: h.Labels[label]})
+	}
+
+	var failedChecks []string
+	for _, c := range h.Checks {
+		state := "passed"
+		if !c.Passed {
+			state = "failed"
+			failedChecks = append(failedChecks, fmt.Sprintf("%s-check %s: %s, got %s", c.Phase, c.Name, c.Reason, c.Value))
+		}
+		testcase.Properties = append(testcase.Properties,
+			junitProperty{Name: c.Phase + "-check." + c.Name, Value: state + ": " + c.Value})
+	}
+	testcase.Assertions = len(h.postChecks())
+
+	switch h.Status {
+	case "skipped":
+		testcase.Skipped = &junitSkipped{Message: "host was not run"}
+		return testcase
+	case "failed":
+		text := append([]string{h.Error}, failedChecks...)
+		testcase.Failure = &junitFailure{Message: h.Error, Type: h.Class, Text: strings.Join(text, "\n")}
+	}
+
+	var out strings.Builder
+	for _, c := range h.Commands {
+		fmt.Fprintf(&out, "> %s\n%s\n", c.Command, c.Output)
+		if c.Error != "" {
+			fmt.Fprintf(&out, "error: %s\n", c.Error)
+		}
+	}
+	out.WriteString(h.Output)
+	testcase.SystemOut = out.String()
+
+	return testcase
+}
@@ -0,0 +1,135 @@
+package libreport
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ipcjk/mlxsh/libcheck"
+)
+
+/* Formats of reports */
+const (
+	FormatJUnit = "junit"
+	FormatHTML  = "html"
+)
+
+/*Run is a run of mlxsh on all its hosts */
+type Run struct {
+	Name  string
+	Start time.Time
+	End   time.Time
+	Hosts []Host
+}
+
+/*
+Host is the result of a host. Status is ok, failed or skipped, Class sorts the
+error, e.g. dial or auth.
+*/
+type Host struct {
+	Hostname   string
+	DeviceType string
+	Labels     map[string]string
+	Status     string
+	Error      string
+	Class      string
+	Start      time.Time
+	Duration   time.Duration
+	Commands   []Command
+	Output     string
+	Checks     []Check
+}
+
+/*Command is the output of a single exec command */
+type Command struct {
+	Command string
+	Output  string
+	Error   string
+}
+
+/*Check is the result of a pre- or post-check, Phase is pre or post */
+type Check struct {
+	Phase string
+	libcheck.Result
+}
+
+/*Target is a report format and the file, that the report is written to */
+type Target struct {
+	Format string
+	File   string
+}
+
+/*ParseTargets parses a list like "junit:run.xml,html:run.html" */
+func ParseTargets(list string) ([]Target, error) {
+	var targets []Target
+
+	for _, entry := range strings.Split(list, ",") {
+		parts := strings.SplitN(strings.TrimSpace(entry), ":", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, fmt.Errorf("Report %q needs the form format:file", entry)
+		}
+
+		switch parts[0] {
+		case FormatJUnit, FormatHTML:
+		default:
+			return nil, fmt.Errorf("Unknown report format %s, use %s or %s", parts[0], FormatJUnit, FormatHTML)
+		}
+
+		targets = append(targets, Target{Format: parts[0], File: parts[1]})
+	}
+
+	return targets, nil
+}
+
+/*Write writes the report of the run in the format of the target */
+func Write(w io.Writer, format string, run Run) error {
+	switch format {
+	case FormatJUnit:
+		return WriteJUnit(w, run)
+	case FormatHTML:
+		return WriteHTML(w, run)
+	}
+	return fmt.Errorf("Unknown report format %s", format)
+}
+
+/*WriteFile writes the report of the run into the file of the target */
+func WriteFile(target Target, run Run) error {
+	file, err := os.Create(target.File)
+	if err != nil {
+		return fmt.Errorf("Cant create the %s report: %s", target.Format, err)
+	}
+
+	if err = Write(file, target.Format, run); err != nil {
+		file.Close()
+		return fmt.Errorf("Cant write the %s report: %s", target.Format, err)
+	}
+
+	return file.Close()
+}
+
+/* counts returns the number of hosts per status */
+func (r Run) counts() (ok, failed, skipped int) {
+	for _, h := range r.Hosts {
+		switch h.Status {
+		case "failed":
+			failed++
+		case "skipped":
+			skipped++
+		default:
+			ok++
+		}
+	}
+	return
+}
+
+/* postChecks returns the post-checks of the host, they are the assertions of a testcase */
+func (h Host) postChecks() (checks []Check) {
+	for _, c := range h.Checks {
+		if c.Phase == "post" {
+			checks = append(checks, c)
+		}
+	}
+	return
+}
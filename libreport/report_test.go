@@ -0,0 +1,120 @@
+package libreport_test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ipcjk/mlxsh/libcheck"
+	"github.com/ipcjk/mlxsh/libreport"
+)
+
+var start = time.Date(2026, 10, 19, 22, 0, 0, 0, time.UTC)
+
+var run = libreport.Run{
+	Name:  "mlxsh change.txt",
+	Start: start,
+	End:   start.Add(12 * time.Second),
+	Hosts: []libreport.Host{
+		{
+			Hostname:   "edge1",
+			DeviceType: "MLX",
+			Labels:     map[string]string{"location": "munich"},
+			Status:     "ok",
+			Duration:   3 * time.Second,
+			Commands: []libreport.Command{
+				{Command: "show version", Output: "V5.8 <beta>"},
+				{Command: "show ip bgp summary", Output: "Number of BGP neighbors: 4"},
+			},
+			Checks: []libreport.Check{
+				{Phase: "post", Result: libcheck.Result{Name: "bgp", Value: "4", Passed: true}},
+			},
+		},
+		{
+			Hostname:   "edge2",
+			DeviceType: "MLX",
+			Status:     "failed",
+			Error:      "post-checks failed",
+			Class:      "check",
+			Duration:   5 * time.Second,
+			Checks: []libreport.Check{
+				{Phase: "pre", Result: libcheck.Result{Name: "bgp", Value: "4", Passed: true}},
+				{Phase: "post", Result: libcheck.Result{Name: "bgp", Value: "2", Reason: "expected == 4"}},
+			},
+		},
+		{Hostname: "edge3", DeviceType: "MLX", Status: "skipped"},
+	},
+}
+
+func TestWriteJUnit(t *testing.T) {
+	var report bytes.Buffer
+	if err := libreport.WriteJUnit(&report, run); err != nil {
+		t.Fatal(err)
+	}
+
+	var suites struct {
+		Tests    int `xml:"tests,attr"`
+		Failures int `xml:"failures,attr"`
+		Skipped  int `xml:"skipped,attr"`
+		Cases    []struct {
+			Name       string `xml:"name,attr"`
+			Assertions int    `xml:"assertions,attr"`
+			Failure    *struct {
+				Type string `xml:"type,attr"`
+				Text string `xml:",chardata"`
+			} `xml:"failure"`
+			SystemOut string `xml:"system-out"`
+		} `xml:"testsuite>testcase"`
+	}
+
+	if err := xml.Unmarshal(report.Bytes(), &suites); err != nil {
+		t.Fatalf("Invalid xml: %s\n%s", err, report.String())
+	}
+
+	if suites.Tests != 3 || suites.Failures != 1 || suites.Skipped != 1 || len(suites.Cases) != 3 {
+		t.Fatalf("Wrong counts: %+v", suites)
+	}
+
+	if suites.Cases[0].Assertions != 1 || suites.Cases[0].SystemOut != "> show version\nV5.8 <beta>\n> show ip bgp summary\nNumber of BGP neighbors: 4\n" {
+		t.Errorf("Wrong testcase of edge1: %+v", suites.Cases[0])
+	}
+
+	failure := suites.Cases[1].Failure
+	if failure == nil || failure.Type != "check" || !strings.Contains(failure.Text, "post-check bgp: expected == 4, got 2") {
+		t.Errorf("Failed post-check not reported: %+v", failure)
+	}
+}
+
+func TestWriteHTML(t *testing.T) {
+	var report bytes.Buffer
+	if err := libreport.WriteHTML(&report, run); err != nil {
+		t.Fatal(err)
+	}
+
+	html := report.String()
+	for _, expected := range []string{
+		"3 hosts: <span class=\"ok\">1 ok</span>",
+		"V5.8 &lt;beta&gt;",
+		"<details id=\"host-edge2\" open>",
+		"expected == 4",
+	} {
+		if !strings.Contains(html, expected) {
+			t.Errorf("Report misses %q", expected)
+		}
+	}
+}
+
+func TestParseTargets(t *testing.T) {
+	targets, err := libreport.ParseTargets("junit:run.xml, html:/tmp/run.html")
+	if err != nil || len(targets) != 2 || targets[1] != (libreport.Target{Format: "html", File: "/tmp/run.html"}) {
+		t.Errorf("Wrong targets: %+v %v", targets, err)
+	}
+
+	for _, list := range []string{"junit", "pdf:run.pdf", "html:"} {
+		if _, err := libreport.ParseTargets(list); err == nil {
+			t.Errorf("Invalid report %q accepted", list)
+		}
+	}
+}
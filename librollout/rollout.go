@@ -0,0 +1,109 @@
+package librollout
+
+import (
+	"fmt"
+
+	"github.com/ipcjk/mlxsh/libhost"
+)
+
+/*
+Strategy describes how the selected hosts are rolled out. Without Canary,
+BatchPercent or BatchLabel all hosts run in a single batch.
+*/
+type Strategy struct {
+	/* Canary is the number of hosts, that run first in their own batch */
+	Canary int
+	/* BatchPercent is the size of every following batch in percent of the remaining hosts */
+	BatchPercent int
+	/* BatchLabel runs one batch per value of this label, e.g. one location at a time */
+	BatchLabel string
+	/* MaxFailures halts the remaining batches, when more hosts failed, 0 halts on the first failure */
+	MaxFailures int
+}
+
+/*Active returns true, if the hosts are split into more than one batch */
+func (s Strategy) Active() bool {
+	return s.Canary > 0 || s.BatchPercent > 0 || s.BatchLabel != ""
+}
+
+/*Halt returns true, if the remaining batches must not run after failures failed hosts */
+func (s Strategy) Halt(failures int) bool {
+	return s.Active() && failures > s.MaxFailures
+}
+
+/*Plan splits the hosts into batches, the order of the hosts is kept */
+func Plan(hosts []libhost.HostConfig, s Strategy) ([][]libhost.HostConfig, error) {
+	var batches [][]libhost.HostConfig
+
+	if s.Canary < 0 || s.MaxFailures < 0 {
+		return nil, fmt.Errorf("Canary and failure threshold cant be negative")
+	}
+
+	if s.BatchPercent < 0 || s.BatchPercent > 100 {
+		return nil, fmt.Errorf("Batch percentage %d is not between 1 and 100", s.BatchPercent)
+	}
+
+	if s.BatchPercent > 0 && s.BatchLabel != "" {
+		return nil, fmt.Errorf("Batches can be split by percentage or by label, not both")
+	}
+
+	if s.Canary > 0 && len(hosts) > 0 {
+		canary := s.Canary
+		if canary > len(hosts) {
+			canary = len(hosts)
+		}
+		batches = append(batches, hosts[:canary])
+		hosts = hosts[canary:]
+	}
+
+	if len(hosts) == 0 {
+		return batches, nil
+	}
+
+	switch {
+	case s.BatchPercent > 0:
+		size := (len(hosts)*s.BatchPercent + 99) / 100
+		for len(hosts) > 0 {
+			if size > len(hosts) {
+				size = len(hosts)
+			}
+			batches = append(batches, hosts[:size])
+			hosts = hosts[size:]
+		}
+	case s.BatchLabel != "":
+		batches = append(batches, byLabel(hosts, s.BatchLabel)...)
+	default:
+		batches = append(batches, hosts)
+	}
+
+	return batches, nil
+}
+
+/* byLabel groups hosts by the value of a label, hosts without the label run last */
+func byLabel(hosts []libhost.HostConfig, label string) [][]libhost.HostConfig {
+	var values []string
+	var unlabeled []libhost.HostConfig
+	groups := make(map[string][]libhost.HostConfig)
+
+	for _, host := range hosts {
+		value, ok := host.Labels[label]
+		if !ok {
+			unlabeled = append(unlabeled, host)
+			continue
+		}
+		if _, seen := groups[value]; !seen {
+			values = append(values, value)
+		}
+		groups[value] = append(groups[value], host)
+	}
+
+	var batches [][]libhost.HostConfig
+	for _, value := range values {
+		batches = append(batches, groups[value])
+	}
+	if len(unlabeled) > 0 {
+		batches = append(batches, unlabeled)
+	}
+
+	return batches
+}
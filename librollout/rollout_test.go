@@ -0,0 +1,98 @@
+package librollout_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/ipcjk/mlxsh/libhost"
+	"github.com/ipcjk/mlxsh/librollout"
+)
+
+func edgeRouters(count int) []libhost.HostConfig {
+	var hosts []libhost.HostConfig
+	locations := []string{"munich", "frankfurt", "dus"}
+
+	for x := 0; x < count; x++ {
+		hosts = append(hosts, libhost.HostConfig{
+			Hostname: fmt.Sprintf("edge%d", x),
+			Labels:   map[string]string{"location": locations[x%len(locations)]},
+		})
+	}
+
+	return hosts
+}
+
+func hostnames(batches [][]libhost.HostConfig) string {
+	var result []string
+	for _, batch := range batches {
+		var names []string
+		for _, host := range batch {
+			names = append(names, host.Hostname)
+		}
+		result = append(result, strings.Join(names, ","))
+	}
+	return strings.Join(result, " | ")
+}
+
+func TestPlan(t *testing.T) {
+	var plans = []struct {
+		strategy librollout.Strategy
+		expected string
+	}{
+		{librollout.Strategy{}, "edge0,edge1,edge2,edge3,edge4,edge5,edge6"},
+		{librollout.Strategy{Canary: 2}, "edge0,edge1 | edge2,edge3,edge4,edge5,edge6"},
+		{librollout.Strategy{Canary: 1, BatchPercent: 50}, "edge0 | edge1,edge2,edge3 | edge4,edge5,edge6"},
+		{librollout.Strategy{BatchPercent: 30}, "edge0,edge1,edge2 | edge3,edge4,edge5 | edge6"},
+		{librollout.Strategy{BatchLabel: "location"}, "edge0,edge3,edge6 | edge1,edge4 | edge2,edge5"},
+		{librollout.Strategy{Canary: 10}, "edge0,edge1,edge2,edge3,edge4,edge5,edge6"},
+	}
+
+	for _, p := range plans {
+		batches, err := librollout.Plan(edgeRouters(7), p.strategy)
+		if err != nil {
+			t.Errorf("%+v: %s", p.strategy, err)
+			continue
+		}
+		if hostnames(batches) != p.expected {
+			t.Errorf("%+v: expected %s, got %s", p.strategy, p.expected, hostnames(batches))
+		}
+	}
+}
+
+func TestPlanUnlabeled(t *testing.T) {
+	hosts := edgeRouters(3)
+	hosts = append(hosts, libhost.HostConfig{Hostname: "core1"})
+
+	batches, err := librollout.Plan(hosts, librollout.Strategy{BatchLabel: "location"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hostnames(batches) != "edge0 | edge1 | edge2 | core1" {
+		t.Errorf("Hosts without label not in the last batch: %s", hostnames(batches))
+	}
+}
+
+func TestPlanErrors(t *testing.T) {
+	for _, s := range []librollout.Strategy{
+		{BatchPercent: 120},
+		{BatchPercent: 10, BatchLabel: "location"},
+		{Canary: -1},
+	} {
+		if _, err := librollout.Plan(edgeRouters(3), s); err == nil {
+			t.Errorf("Invalid strategy accepted: %+v", s)
+		}
+	}
+}
+
+func TestHalt(t *testing.T) {
+	if (librollout.Strategy{}).Halt(5) {
+		t.Error("Single batch run was halted")
+	}
+
+	s := librollout.Strategy{BatchPercent: 10, MaxFailures: 2}
+	if s.Halt(2) || !s.Halt(3) {
+		t.Error("Wrong failure threshold")
+	}
+}
@@ -0,0 +1,78 @@
+package libstream
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+)
+
+/*
+Output serializes the lines of many writers, e.g. of hosts running in parallel,
+so that a line is always written at once and never mixed with other lines.
+*/
+type Output struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+/*LineWriter writes complete lines with a prefix to its Output */
+type LineWriter struct {
+	output  *Output
+	prefix  string
+	partial []byte
+}
+
+/*NewOutput returns an Output, that writes to w */
+func NewOutput(w io.Writer) *Output {
+	return &Output{w: w}
+}
+
+/*Writer returns a new LineWriter, that puts prefix in front of every line */
+func (o *Output) Writer(prefix string) *LineWriter {
+	return &LineWriter{output: o, prefix: prefix}
+}
+
+/*Write writes p at once, e.g. a status line between the lines of the writers */
+func (o *Output) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.w.Write(p)
+}
+
+/* write writes the lines with a single write, carriage returns are removed */
+func (o *Output) write(prefix string, lines []string) {
+	var b bytes.Buffer
+	for _, line := range lines {
+		b.WriteString(prefix)
+		b.WriteString(strings.TrimRight(line, "\r"))
+		b.WriteString("\n")
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.w.Write(b.Bytes())
+}
+
+/*Write writes all complete lines and keeps the rest till the next write or Flush */
+func (l *LineWriter) Write(p []byte) (int, error) {
+	l.partial = append(l.partial, p...)
+
+	end := bytes.LastIndexByte(l.partial, '\n')
+	if end < 0 {
+		return len(p), nil
+	}
+
+	l.output.write(l.prefix, strings.Split(string(l.partial[:end]), "\n"))
+	l.partial = append(l.partial[:0], l.partial[end+1:]...)
+
+	return len(p), nil
+}
+
+/*Flush writes the rest of an incomplete line, e.g. a prompt */
+func (l *LineWriter) Flush() {
+	if len(bytes.TrimSpace(l.partial)) > 0 {
+		l.output.write(l.prefix, []string{string(l.partial)})
+	}
+	l.partial = l.partial[:0]
+}
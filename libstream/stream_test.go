@@ -0,0 +1,62 @@
+package libstream_test
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ipcjk/mlxsh/libstream"
+)
+
+func TestLineWriter(t *testing.T) {
+	var b bytes.Buffer
+	w := libstream.NewOutput(&b).Writer("rt1 | ")
+
+	fmt.Fprint(w, "show ver")
+	if b.Len() != 0 {
+		t.Errorf("Incomplete line was written: %q", b.String())
+	}
+
+	fmt.Fprint(w, "sion\r\nSystem: NetIron MLX\r\nSSH@rt1#")
+	w.Flush()
+
+	expected := "rt1 | show version\nrt1 | System: NetIron MLX\nrt1 | SSH@rt1#\n"
+	if b.String() != expected {
+		t.Errorf("Wrong lines:\n%q\nexpected\n%q", b.String(), expected)
+	}
+}
+
+func TestParallelLines(t *testing.T) {
+	var b bytes.Buffer
+	var wg sync.WaitGroup
+	output := libstream.NewOutput(&b)
+
+	for x := 0; x < 8; x++ {
+		wg.Add(1)
+		go func(x int) {
+			defer wg.Done()
+			w := output.Writer(fmt.Sprintf("host%d: ", x))
+			for y := 0; y < 100; y++ {
+				/* split a line over several writes */
+				fmt.Fprintf(w, "line %d of ", y)
+				fmt.Fprintf(w, "host%d\n", x)
+			}
+		}(x)
+	}
+	wg.Wait()
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 800 {
+		t.Fatalf("Expected 800 lines, got %d", len(lines))
+	}
+
+	for _, line := range lines {
+		var prefix, host string
+		var number int
+		if _, err := fmt.Sscanf(line, "%s line %d of %s", &prefix, &number, &host); err != nil || prefix != host+":" {
+			t.Errorf("Mixed line: %q", line)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package libtable
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+/*Table is a table of strings, column names are matched case-insensitive */
+type Table struct {
+	Columns []string
+	Rows    [][]string
+}
+
+/*Index returns the column of a name or -1 */
+func (t *Table) Index(name string) int {
+	for x, column := range t.Columns {
+		if strings.EqualFold(column, name) {
+			return x
+		}
+	}
+	return -1
+}
+
+/*AddColumn returns the column of a name and appends it, if it does not exist */
+func (t *Table) AddColumn(name string) int {
+	if x := t.Index(name); x >= 0 {
+		return x
+	}
+
+	t.Columns = append(t.Columns, name)
+	for x := range t.Rows {
+		t.Rows[x] = append(t.Rows[x], "")
+	}
+	return len(t.Columns) - 1
+}
+
+/*Append adds a row with the cells of the named columns, missing columns are added */
+func (t *Table) Append(columns, cells []string) {
+	var positions []int
+	for _, name := range columns {
+		positions = append(positions, t.AddColumn(name))
+	}
+
+	row := make([]string, len(t.Columns))
+	for x, cell := range cells {
+		row[positions[x]] = cell
+	}
+	t.Rows = append(t.Rows, row)
+}
+
+/*Select returns a table with the named columns in their order */
+func (t Table) Select(names []string) (Table, error) {
+	var columns []int
+	selected := Table{}
+
+	for _, name := range names {
+		x := t.Index(name)
+		if x < 0 {
+			return selected, fmt.Errorf("Unknown column %s, the columns are %s", name, strings.Join(t.Columns, ","))
+		}
+		columns = append(columns, x)
+		selected.Columns = append(selected.Columns, t.Columns[x])
+	}
+
+	for _, row := range t.Rows {
+		var line []string
+		for _, x := range columns {
+			line = append(line, row[x])
+		}
+		selected.Rows = append(selected.Rows, line)
+	}
+
+	return selected, nil
+}
+
+/*
+Sort sorts the rows by the named columns, a leading - sorts descending.
+Numbers are compared by their value.
+*/
+func (t Table) Sort(names []string) error {
+	var columns []int
+	var descending []bool
+
+	for _, name := range names {
+		desc := strings.HasPrefix(name, "-")
+		x := t.Index(strings.TrimPrefix(name, "-"))
+		if x < 0 {
+			return fmt.Errorf("Unknown sort column %s, the columns are %s", name, strings.Join(t.Columns, ","))
+		}
+		columns = append(columns, x)
+		descending = append(descending, desc)
+	}
+
+	sort.SliceStable(t.Rows, func(i, j int) bool {
+		for k, x := range columns {
+			c := compare(t.Rows[i][x], t.Rows[j][x])
+			if c == 0 {
+				continue
+			}
+			return (c < 0) != descending[k]
+		}
+		return false
+	})
+
+	return nil
+}
+
+/* compare compares two cells as numbers, if both are numbers, else as strings */
+func compare(a, b string) int {
+	x, errA := strconv.ParseFloat(a, 64)
+	y, errB := strconv.ParseFloat(b, 64)
+
+	switch {
+	case errA == nil && errB == nil && x < y:
+		return -1
+	case errA == nil && errB == nil && x > y:
+		return 1
+	case errA == nil && errB == nil:
+		return 0
+	}
+	return strings.Compare(a, b)
+}
+
+/*Filter is a condition on a column like state!=ESTAB */
+type Filter struct {
+	Column   string
+	Operator string
+	Value    string
+	regex    *regexp.Regexp
+}
+
+/* filterOperators are tried in order, so that != is found before = */
+var filterOperators = []string{"!=", "!~", ">=", "<=", "=", "~", ">", "<"}
+
+/*
+ParseFilters parses a comma-separated list of filters. = and != compare text,
+~ and !~ match a regular expression, <, <=, > and >= compare numbers.
+*/
+func ParseFilters(list string) ([]Filter, error) {
+	var filters []Filter
+
+	for _, condition := range strings.Split(list, ",") {
+		condition = strings.TrimSpace(condition)
+		if condition == "" {
+			continue
+		}
+
+		var f Filter
+		for _, op := range filterOperators {
+			if x := strings.Index(condition, op); x > 0 {
+				f = Filter{Column: strings.TrimSpace(condition[:x]), Operator: op, Value: strings.TrimSpace(condition[x+len(op):])}
+				break
+			}
+		}
+
+		if f.Operator == "" {
+			return nil, fmt.Errorf("Filter %q needs the form column=value, use =, !=, ~, !~, <, <=, > or >=", condition)
+		}
+
+		if f.Operator == "~" || f.Operator == "!~" {
+			regex, err := regexp.Compile(f.Value)
+			if err != nil {
+				return nil, fmt.Errorf("Filter %q has no valid regex: %s", condition, err)
+			}
+			f.regex = regex
+		}
+
+		if strings.ContainsAny(f.Operator, "<>") {
+			if _, err := strconv.ParseFloat(f.Value, 64); err != nil {
+				return nil, fmt.Errorf("Filter %q needs a number", condition)
+			}
+		}
+
+		filters = append(filters, f)
+	}
+
+	return filters, nil
+}
+
+/* matches returns true, if the cell fulfills the filter */
+func (f Filter) matches(cell string) bool {
+	switch f.Operator {
+	case "=":
+		return strings.EqualFold(cell, f.Value)
+	case "!=":
+		return !strings.EqualFold(cell, f.Value)
+	case "~":
+		return f.regex.MatchString(cell)
+	case "!~":
+		return !f.regex.MatchString(cell)
+	}
+
+	x, err := strconv.ParseFloat(cell, 64)
+	if err != nil {
+		return false
+	}
+	y, _ := strconv.ParseFloat(f.Value, 64)
+
+	switch f.Operator {
+	case "<":
+		return x < y
+	case "<=":
+		return x <= y
+	case ">":
+		return x > y
+	default:
+		return x >= y
+	}
+}
+
+/*Filter returns the rows, that match all filters */
+func (t Table) Filter(filters []Filter) (Table, error) {
+	var columns []int
+	filtered := Table{Columns: t.Columns}
+
+	for _, f := range filters {
+		x := t.Index(f.Column)
+		if x < 0 {
+			return filtered, fmt.Errorf("Unknown filter column %s, the columns are %s", f.Column, strings.Join(t.Columns, ","))
+		}
+		columns = append(columns, x)
+	}
+
+Rows:
+	for _, row := range t.Rows {
+		for k, f := range filters {
+			if !f.matches(row[columns[k]]) {
+				continue Rows
+			}
+		}
+		filtered.Rows = append(filtered.Rows, row)
+	}
+
+	return filtered, nil
+}
+
+/*WriteAligned writes the table with aligned columns for the terminal */
+func (t Table) WriteAligned(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+
+	fmt.Fprintln(tw, strings.Join(t.Columns, "\t"))
+	for _, row := range t.Rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+
+	return tw.Flush()
+}
+
+/*WriteCSV writes the table as csv with a header line */
+func (t Table) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+
+	cw.Write(t.Columns)
+	for _, row := range t.Rows {
+		cw.Write(row)
+	}
+	cw.Flush()
+
+	return cw.Error()
+}
@@ -0,0 +1,89 @@
+package libtable_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ipcjk/mlxsh/libtable"
+)
+
+func neighbors() libtable.Table {
+	var t libtable.Table
+	t.Append([]string{"host", "NEIGHBOR", "STATE", "ACCEPTED"}, []string{"edge2", "10.0.0.2", "ESTAB", "120"})
+	t.Append([]string{"host", "NEIGHBOR", "STATE", "ACCEPTED"}, []string{"edge1", "10.0.0.1", "ACTIVE", "0"})
+	t.Append([]string{"host", "PEER", "STATE"}, []string{"mx1", "10.0.1.1", "Establ"})
+	t.Append([]string{"host", "NEIGHBOR", "STATE", "ACCEPTED"}, []string{"edge1", "10.0.0.3", "ESTAB", "9"})
+	return t
+}
+
+func TestAppend(t *testing.T) {
+	table := neighbors()
+
+	if len(table.Columns) != 5 || table.Columns[4] != "PEER" {
+		t.Fatalf("Wrong columns: %v", table.Columns)
+	}
+
+	for _, row := range table.Rows {
+		if len(row) != 5 {
+			t.Errorf("Row %v has not all columns", row)
+		}
+	}
+
+	if table.Rows[2][1] != "" || table.Rows[2][4] != "10.0.1.1" {
+		t.Errorf("Wrong junos row: %v", table.Rows[2])
+	}
+}
+
+func TestFilterSortSelect(t *testing.T) {
+	filters, err := libtable.ParseFilters("state!=ESTAB, host~^edge")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	table, err := neighbors().Filter(filters)
+	if err != nil || len(table.Rows) != 1 || table.Rows[0][1] != "10.0.0.1" {
+		t.Fatalf("Wrong filtered rows: %v %v", table.Rows, err)
+	}
+
+	filters, _ = libtable.ParseFilters("accepted>=9")
+	if table, _ = neighbors().Filter(filters); len(table.Rows) != 2 {
+		t.Errorf("Numeric filter matched %v", table.Rows)
+	}
+
+	table = neighbors()
+	if err = table.Sort([]string{"host", "-accepted"}); err != nil {
+		t.Fatal(err)
+	}
+	if table, err = table.Select([]string{"HOST", "accepted"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	table.WriteAligned(&out)
+	expected := "host   ACCEPTED\nedge1  9\nedge1  0\nedge2  120\nmx1    \n"
+	if out.String() != expected {
+		t.Errorf("Wrong table:\n%q\nexpected\n%q", out.String(), expected)
+	}
+
+	out.Reset()
+	table.WriteCSV(&out)
+	if out.String() != "host,ACCEPTED\nedge1,9\nedge1,0\nedge2,120\nmx1,\n" {
+		t.Errorf("Wrong csv:\n%s", out.String())
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for _, list := range []string{"state", "state~(", "accepted>many"} {
+		if _, err := libtable.ParseFilters(list); err == nil {
+			t.Errorf("Invalid filter %q accepted", list)
+		}
+	}
+
+	table := neighbors()
+	if _, err := table.Select([]string{"uptime"}); err == nil {
+		t.Error("Unknown column selected")
+	}
+	if err := table.Sort([]string{"-uptime"}); err == nil {
+		t.Error("Unknown column sorted")
+	}
+}
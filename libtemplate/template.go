@@ -0,0 +1,292 @@
+package libtemplate
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/ipcjk/mlxsh/libhost"
+	"gopkg.in/yaml.v1"
+)
+
+/*
+Data is handed to the template, all HostConfig fields like .Hostname, .Labels
+or .Vars are available directly, .Data holds the optional data file and
+.Registered the registered step outputs of a playbook
+*/
+type Data struct {
+	libhost.HostConfig
+	Data       map[interface{}]interface{}
+	Registered map[string]string
+}
+
+/*IsTemplate returns true, if the source contains template actions */
+func IsTemplate(source string) bool {
+	return strings.Contains(source, "{{")
+}
+
+/*LoadData reads a yaml data file for the lookup function */
+func LoadData(r io.Reader) (map[interface{}]interface{}, error) {
+	var data map[interface{}]interface{}
+
+	source, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("Cant read from yaml source: %s", err)
+	}
+
+	if err = yaml.Unmarshal(source, &data); err != nil {
+		return nil, fmt.Errorf("Cant parse yaml source: %s", err)
+	}
+
+	return data, nil
+}
+
+/*
+Render executes the source as text/template for a single host. Missing
+keys in .Labels or .Vars are an error, so a host never gets half a config.
+*/
+func Render(name, source string, host libhost.HostConfig, data map[interface{}]interface{}) (string, error) {
+	return RenderData(name, source, Data{HostConfig: host, Data: data})
+}
+
+/*RenderData works like Render, but takes the complete template data */
+func RenderData(name, source string, data Data) (string, error) {
+	var rendered bytes.Buffer
+
+	tmpl, err := template.New(name).Option("missingkey=error").Funcs(Funcs(data.Data)).Parse(source)
+	if err != nil {
+		return "", fmt.Errorf("Cant parse template %s: %s", name, err)
+	}
+
+	if err = tmpl.Execute(&rendered, data); err != nil {
+		return "", fmt.Errorf("Cant render template %s for %s: %s", name, data.Hostname, err)
+	}
+
+	return rendered.String(), nil
+}
+
+/*Funcs returns the helper functions for templates, lookup reads from data */
+func Funcs(data map[interface{}]interface{}) template.FuncMap {
+	return template.FuncMap{
+		"join":    strings.Join,
+		"split":   strings.Split,
+		"lower":   strings.ToLower,
+		"upper":   strings.ToUpper,
+		"replace": func(s, old, new string) string { return strings.Replace(s, old, new, -1) },
+		"trim":    strings.TrimSpace,
+		"add":     add,
+		"sub":     sub,
+		"seq":     seq,
+		"default": func(def string, value interface{}) interface{} {
+			if value == nil || value == "" {
+				return def
+			}
+			return value
+		},
+		"ipadd":     ipAdd,
+		"iphost":    ipHost,
+		"ipnet":     ipNet,
+		"netmask":   netmask,
+		"prefixlen": prefixLen,
+		"subnet":    subnet,
+		"lookup": func(path string) (interface{}, error) {
+			return lookup(data, path)
+		},
+	}
+}
+
+/* toInt converts numbers and numeric strings, Vars are always strings */
+func toInt(value interface{}) (int, error) {
+	switch v := value.(type) {
+	case int:
+		return v, nil
+	case int64:
+		return int(v), nil
+	case float64:
+		return int(v), nil
+	case string:
+		return strconv.Atoi(strings.TrimSpace(v))
+	}
+	return 0, fmt.Errorf("not a number: %v", value)
+}
+
+func add(a, b interface{}) (int, error) {
+	x, err := toInt(a)
+	if err != nil {
+		return 0, err
+	}
+	y, err := toInt(b)
+	if err != nil {
+		return 0, err
+	}
+	return x + y, nil
+}
+
+func sub(a, b interface{}) (int, error) {
+	x, err := toInt(a)
+	if err != nil {
+		return 0, err
+	}
+	y, err := toInt(b)
+	if err != nil {
+		return 0, err
+	}
+	return x - y, nil
+}
+
+/* seq returns the numbers from start to end, both included */
+func seq(start, end int) []int {
+	var numbers []int
+	for x := start; x <= end; x++ {
+		numbers = append(numbers, x)
+	}
+	return numbers
+}
+
+/* lookup walks the data file along a dotted path, e.g. sites.munich.asn */
+func lookup(data map[interface{}]interface{}, path string) (interface{}, error) {
+	var current interface{} = data
+
+	for _, key := range strings.Split(path, ".") {
+		var value interface{}
+		var ok bool
+
+		switch m := current.(type) {
+		case map[interface{}]interface{}:
+			value, ok = m[key]
+		case map[string]interface{}:
+			value, ok = m[key]
+		}
+
+		if !ok {
+			return nil, fmt.Errorf("lookup: %s not found in data file", path)
+		}
+		current = value
+	}
+
+	return current, nil
+}
+
+func parseIP(s string) (net.IP, error) {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid ip address: %s", s)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4, nil
+	}
+	return ip, nil
+}
+
+/* addToIP adds n to an address and fails on an overflow of the address family */
+func addToIP(ip net.IP, n int) (net.IP, error) {
+	value := new(big.Int).SetBytes(ip)
+	value.Add(value, big.NewInt(int64(n)))
+
+	if value.Sign() < 0 || value.BitLen() > len(ip)*8 {
+		return nil, fmt.Errorf("%s + %d is out of range", ip, n)
+	}
+
+	result := make(net.IP, len(ip))
+	raw := value.Bytes()
+	copy(result[len(result)-len(raw):], raw)
+
+	return result, nil
+}
+
+/* ipAdd returns the address n addresses after ip, e.g. ipadd "10.0.0.1" 5 */
+func ipAdd(address string, n int) (string, error) {
+	ip, err := parseIP(address)
+	if err != nil {
+		return "", err
+	}
+
+	result, err := addToIP(ip, n)
+	if err != nil {
+		return "", err
+	}
+	return result.String(), nil
+}
+
+/* ipHost returns the nth address of a prefix, e.g. iphost "10.0.0.0/24" 1 */
+func ipHost(prefix string, n int) (string, error) {
+	_, network, err := net.ParseCIDR(prefix)
+	if err != nil {
+		return "", err
+	}
+
+	ip, err := addToIP(network.IP, n)
+	if err != nil {
+		return "", err
+	}
+
+	if !network.Contains(ip) {
+		return "", fmt.Errorf("host %d is not part of %s", n, prefix)
+	}
+
+	return ip.String(), nil
+}
+
+/* ipNet returns the network of an interface address, e.g. ipnet "10.0.0.5/24" */
+func ipNet(prefix string) (string, error) {
+	_, network, err := net.ParseCIDR(prefix)
+	if err != nil {
+		return "", err
+	}
+	return network.String(), nil
+}
+
+/* netmask returns the dotted netmask of an IPv4 prefix */
+func netmask(prefix string) (string, error) {
+	_, network, err := net.ParseCIDR(prefix)
+	if err != nil {
+		return "", err
+	}
+	if len(network.Mask) != net.IPv4len {
+		return "", fmt.Errorf("netmask needs an IPv4 prefix: %s", prefix)
+	}
+	return net.IP(network.Mask).String(), nil
+}
+
+/* prefixLen returns the prefix length, e.g. 24 for 10.0.0.0/24 */
+func prefixLen(prefix string) (int, error) {
+	_, network, err := net.ParseCIDR(prefix)
+	if err != nil {
+		return 0, err
+	}
+	ones, _ := network.Mask.Size()
+	return ones, nil
+}
+
+/* subnet returns the nth subnet with a new length, e.g. subnet "10.0.0.0/16" 24 3 is 10.0.3.0/24 */
+func subnet(prefix string, length, n int) (string, error) {
+	_, network, err := net.ParseCIDR(prefix)
+	if err != nil {
+		return "", err
+	}
+
+	ones, bits := network.Mask.Size()
+	if length < ones || length > bits {
+		return "", fmt.Errorf("cant split %s into /%d", prefix, length)
+	}
+
+	if length-ones < 63 && int64(n) >= int64(1)<<uint(length-ones) {
+		return "", fmt.Errorf("subnet %d of /%d is not part of %s", n, length, prefix)
+	}
+
+	offset := new(big.Int).Lsh(big.NewInt(int64(n)), uint(bits-length))
+	value := new(big.Int).SetBytes(network.IP)
+	value.Add(value, offset)
+
+	ip := make(net.IP, len(network.IP))
+	raw := value.Bytes()
+	copy(ip[len(ip)-len(raw):], raw)
+
+	return fmt.Sprintf("%s/%d", ip, length), nil
+}
@@ -0,0 +1,124 @@
+package libtemplate_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ipcjk/mlxsh/libhost"
+	"github.com/ipcjk/mlxsh/libtemplate"
+)
+
+var hostYaml = `
+- Hostname: munich-rt1
+  DeviceType: mlx
+  Labels:
+    location: munich
+  Vars:
+    vlan: 120
+    transfer: 10.10.0.0/30
+`
+
+var dataYaml = `
+sites:
+  munich:
+    asn: 65010
+    peers:
+      - 192.0.2.1
+      - 192.0.2.2
+`
+
+func loadHost(t *testing.T) libhost.HostConfig {
+	hosts, err := libhost.LoadAllFromYAML(strings.NewReader(hostYaml))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hosts[0]
+}
+
+func TestRender(t *testing.T) {
+	host := loadHost(t)
+
+	data, err := libtemplate.LoadData(strings.NewReader(dataYaml))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source := `vlan {{.Vars.vlan}} name {{upper .Labels.location}}-{{.Hostname}}
+router bgp
+ local-as {{lookup (printf "sites.%s.asn" .Labels.location)}}
+{{- range (lookup "sites.munich.peers")}}
+ neighbor {{.}} remote-as 65000
+{{- end}}
+interface ve {{.Vars.vlan}}
+ ip address {{iphost .Vars.transfer 1}} {{netmask .Vars.transfer}}
+{{- range seq 1 2}}
+vlan {{add $.Vars.vlan .}}
+{{- end}}`
+
+	if !libtemplate.IsTemplate(source) {
+		t.Error("Source not detected as template")
+	}
+
+	rendered, err := libtemplate.Render("test", source, host, data)
+	if err != nil {
+		t.Fatalf("Cant render: %s", err)
+	}
+
+	expected := `vlan 120 name MUNICH-munich-rt1
+router bgp
+ local-as 65010
+ neighbor 192.0.2.1 remote-as 65000
+ neighbor 192.0.2.2 remote-as 65000
+interface ve 120
+ ip address 10.10.0.1 255.255.255.252
+vlan 121
+vlan 122`
+
+	if rendered != expected {
+		t.Errorf("Wrong rendering:\n%s\nexpected:\n%s", rendered, expected)
+	}
+}
+
+func TestRenderErrors(t *testing.T) {
+	host := loadHost(t)
+
+	if _, err := libtemplate.Render("missing", "vlan {{.Vars.novlan}}", host, nil); err == nil {
+		t.Error("Missing variable did not abort the rendering")
+	}
+
+	if _, err := libtemplate.Render("lookup", `{{lookup "sites.berlin.asn"}}`, host, nil); err == nil {
+		t.Error("Missing lookup key did not abort the rendering")
+	}
+
+	if _, err := libtemplate.Render("broken", "vlan {{.Vars.vlan", host, nil); err == nil {
+		t.Error("Broken template was parsed")
+	}
+}
+
+func TestIPFunctions(t *testing.T) {
+	host := loadHost(t)
+
+	var functions = map[string]string{
+		`{{ipadd "10.0.0.1" 5}}`:            "10.0.0.6",
+		`{{ipadd "10.0.0.255" 1}}`:          "10.0.1.0",
+		`{{ipadd "2001:db8::1" 15}}`:        "2001:db8::10",
+		`{{iphost "192.0.2.0/24" 10}}`:      "192.0.2.10",
+		`{{ipnet "192.0.2.77/26"}}`:         "192.0.2.64/26",
+		`{{prefixlen "2001:db8::/48"}}`:     "48",
+		`{{subnet "10.0.0.0/16" 24 3}}`:     "10.0.3.0/24",
+		`{{subnet "2001:db8::/32" 48 255}}`: "2001:db8:ff::/48",
+		`{{default "none" ""}}`:             "none",
+		`{{join (split "a,b" ",") "-"}}`:    "a-b",
+	}
+
+	for source, expected := range functions {
+		rendered, err := libtemplate.Render("ip", source, host, nil)
+		if err != nil || rendered != expected {
+			t.Errorf("%s: expected %s, got %s (%v)", source, expected, rendered, err)
+		}
+	}
+
+	if _, err := libtemplate.Render("ip", `{{iphost "192.0.2.0/30" 4}}`, host, nil); err == nil {
+		t.Error("Host outside of the prefix was accepted")
+	}
+}
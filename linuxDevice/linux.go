@@ -0,0 +1,293 @@
+package linuxDevice
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipcjk/mlxsh/routerDevice"
+	"golang.org/x/crypto/ssh"
+)
+
+type linuxDevice struct {
+	RTC router.RunTimeConfig
+	router.Router
+	/* Vtysh will send configuration statements through vtysh -c instead of the shell */
+	Vtysh bool
+}
+
+/*
+LinuxDevice returns a new
+linuxDevice object, has a init struct of type Router.RunTimeConfig
+*/
+func LinuxDevice(Config router.RunTimeConfig) *linuxDevice {
+	var configureErrors = `(?i)(% Unknown command|% Invalid|% Ambiguous|% Command incomplete|line \d+: )`
+
+	/* Fill our config with defaults for ssh and timesouts */
+	router.GenerateDefaults(&Config)
+
+	device := &linuxDevice{
+		RTC: Config,
+		Router: router.Router{
+			CommandRewrite: map[string]string{
+				"mlxsh_log":        "journalctl -n 100 --no-pager",
+				"mlxsh_audit":      "last -n 20",
+				"mlxsh_chassis":    "uname -a",
+				"mlxsh_route":      "ip route show",
+				"mlxsh_route6":     "ip -6 route show",
+				"mlxsh_include":    "grep",
+				"mlxsh_pipe":       "|",
+				"mlxsh_route_sum":  "vtysh -c 'show ip route summary'",
+				"mlxsh_route6_sum": "vtysh -c 'show ipv6 route summary'",
+				"mlxsh_bgp":        "vtysh -c 'show bgp ipv4 summary'",
+				"mlxsh_bgp6":       "vtysh -c 'show bgp ipv6 summary'",
+				"mlxsh_bgpn":       "vtysh -c 'show bgp ipv4 neighbors'",
+				"mlxsh_bgpn6":      "vtysh -c 'show bgp ipv6 neighbors'",
+				"mlxsh_vlans":      "ip -d link show type vlan",
+			},
+			PromptModes:  make(map[string]string),
+			ErrorMatches: regexp.MustCompile(configureErrors),
+		}}
+
+	switch strings.ToLower(Config.DeviceType) {
+	case "frr", "vtysh", "quagga":
+		device.Vtysh = true
+	}
+
+	return device
+}
+
+func (b *linuxDevice) Connect() (err error) {
+	/* No shell and no prompt scraping, every command gets its own exec channel */
+	return b.DialSSH(b.RTC)
+}
+
+/* lockedBuffer keeps stdout and stderr in order, the ssh session copies them in own goroutines */
+type lockedBuffer struct {
+	sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (l *lockedBuffer) Write(p []byte) (int, error) {
+	l.Lock()
+	defer l.Unlock()
+	return l.buffer.Write(p)
+}
+
+func (l *lockedBuffer) String() string {
+	l.Lock()
+	defer l.Unlock()
+	return l.buffer.String()
+}
+
+/*
+Exec runs a single command in its own SSH exec channel and returns stdout and
+stderr combined. A non-zero exit status is returned as error.
+*/
+func (b *linuxDevice) Exec(command string) (string, error) {
+	if b.SSHConnection == nil {
+		return "", fmt.Errorf("Not connected")
+	}
+
+	session, err := b.SSHConnection.NewSession()
+	if err != nil {
+		return "", fmt.Errorf("Cant open exec channel: %s", err)
+	}
+	defer session.Close()
+
+	var output lockedBuffer
+	session.Stdout = &output
+	session.Stderr = &output
+
+	/* Kill the channel, if the command does not return in time */
+	timer := time.AfterFunc(b.RTC.ReadTimeout, func() {
+		session.Close()
+	})
+	defer timer.Stop()
+
+	if b.RTC.Debug {
+		fmt.Fprintf(b.RTC.W, "Send command: %s\n", command)
+	}
+
+	err = session.Run(command)
+	if exitErr, ok := err.(*ssh.ExitError); ok {
+		return output.String(), router.Errorf(router.ErrCommandFailed, b.RTC.Hostname, "Command %q exited with status %d", command, exitErr.ExitStatus())
+	} else if err != nil {
+		return output.String(), router.Errorf(router.ErrCommandFailed, b.RTC.Hostname, "Command %q failed: %w", command, err)
+	}
+
+	return output.String(), nil
+}
+
+func (b *linuxDevice) ConfigureTerminalMode() error {
+	/* vtysh enters configure terminal itself on every paste */
+	if b.RTC.Debug {
+		fmt.Fprint(b.RTC.W, "Configuration mode on")
+	}
+	return nil
+}
+
+func (b *linuxDevice) CommitConfiguration() (err error) {
+	if !b.Vtysh {
+		return
+	}
+
+	val, err := b.Exec("vtysh -c 'write memory'")
+	if err != nil {
+		return router.Errorf(router.ErrCommitFailed, b.RTC.Hostname, "Cant write memory: %w", err)
+	}
+
+	if b.RTC.Debug {
+		fmt.Fprintf(b.RTC.W, "Captured %s\n", val)
+	}
+
+	return
+}
+
+func (b *linuxDevice) PasteConfiguration(configuration io.Reader) (err error) {
+	var statements []string
+	var lines []int
+	var line int
+
+	scanner := bufio.NewScanner(configuration)
+	for scanner.Scan() {
+		line++
+		if strings.HasPrefix(scanner.Text(), "#") || strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		statements = append(statements, scanner.Text())
+		lines = append(lines, line)
+	}
+
+	/* Plain shell: every line is a command on its own, a failed command is an error for the ErrorPolicy */
+	if !b.Vtysh {
+		var failed []string
+
+		if err := router.CheckPolicy(b.RTC.ErrorPolicy); err != nil {
+			return err
+		}
+
+		b.Results = nil
+		for x, statement := range statements {
+			result := router.LineResult{Line: lines[x], Statement: statement, Applied: true}
+
+			val, err := b.Exec(statement)
+			if b.RTC.Debug {
+				fmt.Fprintf(b.RTC.W, "Captured %s\n", val)
+			}
+			if err != nil {
+				result.Severity, result.Message, result.Applied = router.SeverityError, err.Error(), false
+			}
+			b.Results = append(b.Results, result)
+
+			if err != nil && b.RTC.ErrorPolicy != router.PolicyContinue {
+				return router.Errorf(router.ErrConfigRejected, b.RTC.Hostname, "Invalid configuration statement: %s: %w", statement, err)
+			} else if err != nil {
+				failed = append(failed, statement)
+			}
+			fmt.Fprint(b.RTC.W, "+")
+		}
+		fmt.Fprint(b.RTC.W, "\n")
+
+		if len(failed) > 0 {
+			return router.Errorf(router.ErrConfigRejected, b.RTC.Hostname, "%d configuration statements failed: %s", len(failed), strings.Join(failed, ", "))
+		}
+		return nil
+	}
+
+	/* vtysh: one call, so section context like "router bgp" survives between lines */
+	command := "vtysh -c " + ShellQuote("configure terminal")
+	for _, statement := range statements {
+		command += " -c " + ShellQuote(statement)
+	}
+
+	val, err := b.Exec(command)
+	if b.RTC.Debug {
+		fmt.Fprintf(b.RTC.W, "Captured %s\n", val)
+	}
+	if err != nil {
+		return router.Errorf(router.ErrConfigRejected, b.RTC.Hostname, "Invalid configuration: %w %s", err, strings.TrimSpace(val))
+	}
+	if b.ErrorMatches.MatchString(val) {
+		return router.Errorf(router.ErrConfigRejected, b.RTC.Hostname, "Invalid configuration statement: %s", strings.TrimSpace(val))
+	}
+
+	fmt.Fprint(b.RTC.W, strings.Repeat("+", len(statements))+"\n")
+
+	return nil
+}
+
+func (b *linuxDevice) RunCommands(commands io.Reader) (err error) {
+	var failed []string
+
+	scanner := bufio.NewScanner(commands)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		line = b.RewriteCommand(line)
+
+		val, err := b.Exec(line)
+		fmt.Fprintf(b.RTC.W, "%s\n", val)
+		b.RecordOutput(line, val, err)
+		if err != nil && b.RTC.ErrorPolicy != router.PolicyContinue {
+			return err
+		} else if err != nil {
+			failed = append(failed, line)
+		}
+	}
+
+	if len(failed) > 0 {
+		return router.Errorf(router.ErrCommandFailed, b.RTC.Hostname, "%d commands failed: %s", len(failed), strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+/*DryRun writes the exec channels for exec or config mode without connecting */
+func (b *linuxDevice) DryRun(execMode bool, input io.Reader) error {
+	return router.DryRunSteps(b, execMode, input)
+}
+
+/*DryRunExec writes the commands, that RunCommands would send */
+func (b *linuxDevice) DryRunExec(commands io.Reader) error {
+	return b.DryRunCommands(b.RTC, commands)
+}
+
+/*DryRunLogin writes the connection */
+func (b *linuxDevice) DryRunLogin() {
+	b.DryRunConnect(b.RTC)
+}
+
+/*DryRunPaste writes the vtysh configuration or, on a plain shell, every statement as command */
+func (b *linuxDevice) DryRunPaste(configuration io.Reader) error {
+	if !b.Vtysh {
+		return b.DryRunCommands(b.RTC, configuration)
+	}
+	b.DryRunMode(b.RTC, "vtysh -c 'configure terminal'")
+	return b.DryRunConfiguration(b.RTC, configuration)
+}
+
+/*DryRunCommit writes the vtysh write memory */
+func (b *linuxDevice) DryRunCommit() error {
+	if b.Vtysh {
+		b.DryRunMode(b.RTC, "vtysh -c 'write memory'")
+	}
+	return nil
+}
+
+func (b *linuxDevice) Close() {
+	b.Router.Close()
+}
+
+/*ShellQuote wraps a string into single quotes for a POSIX shell */
+func ShellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,242 @@
+package linuxDevice_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/binary"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/ipcjk/mlxsh/libhost"
+	"github.com/ipcjk/mlxsh/linuxDevice"
+	"github.com/ipcjk/mlxsh/routerDevice"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestLinuxConstructor(t *testing.T) {
+	var Config = libhost.HostConfig{
+		DeviceType: "linux",
+		Hostname:   "localhost",
+		Username:   "myuser",
+		Password:   "mypassword",
+	}
+
+	singleRouter := linuxDevice.LinuxDevice(router.RunTimeConfig{HostConfig: Config, Debug: true, W: new(bytes.Buffer)})
+
+	if singleRouter == nil {
+		t.Error("Cant create linux object")
+	}
+
+	if singleRouter.RTC.SSHPort != 22 {
+		t.Error("Wrong SSH-Port in default settings")
+	}
+
+	if singleRouter.Vtysh {
+		t.Error("Plain linux device should not use vtysh")
+	}
+
+	Config.DeviceType = "FRR"
+	if !linuxDevice.LinuxDevice(router.RunTimeConfig{HostConfig: Config, W: new(bytes.Buffer)}).Vtysh {
+		t.Error("FRR device should use vtysh")
+	}
+
+}
+
+func TestSSHConnect(t *testing.T) {
+	var Config = libhost.HostConfig{
+		DeviceType: "linux",
+		Hostname:   "localhost",
+		Username:   "user",
+		Password:   "password",
+		SSHPort:    9131,
+	}
+
+	singleRouter := linuxDevice.LinuxDevice(router.RunTimeConfig{HostConfig: Config, Debug: true, W: new(bytes.Buffer)})
+
+	if err := singleRouter.Connect(); err == nil {
+		t.Error("Logged into localhost with default settings, this cant be true!")
+	}
+
+}
+
+func TestShellQuote(t *testing.T) {
+	if q := linuxDevice.ShellQuote("description it's mine"); q != `'description it'\''s mine'` {
+		t.Errorf("Wrong quoting: %s", q)
+	}
+}
+
+/*
+startExecServer starts a local ssh server, that answers exec requests with
+the command line as output, a warning on stderr and the exit status given by
+the last word of the command
+*/
+func startExecServer(t *testing.T, commands chan<- string) string {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			return nil, nil
+		},
+	}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		conn, err := listener.Accept()
+		listener.Close()
+		if err != nil {
+			return
+		}
+		_, chans, reqs, err := ssh.NewServerConn(conn, config)
+		if err != nil {
+			return
+		}
+		go ssh.DiscardRequests(reqs)
+
+		for newChannel := range chans {
+			channel, requests, err := newChannel.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				for req := range requests {
+					if req.Type != "exec" {
+						req.Reply(false, nil)
+						continue
+					}
+					command := string(req.Payload[4:])
+					commands <- command
+					req.Reply(true, nil)
+
+					fields := strings.Fields(command)
+					status, _ := strconv.Atoi(fields[len(fields)-1])
+					channel.Write([]byte(command + "\n"))
+					channel.Stderr().Write([]byte("warning: " + command + "\n"))
+
+					exitStatus := make([]byte, 4)
+					binary.BigEndian.PutUint32(exitStatus, uint32(status))
+					channel.SendRequest("exit-status", false, exitStatus)
+					channel.Close()
+				}
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func TestRunCommandsExitStatus(t *testing.T) {
+	commands := make(chan string, 10)
+	addr := startExecServer(t, commands)
+	host, port, _ := net.SplitHostPort(addr)
+	sshPort, _ := strconv.Atoi(port)
+
+	var buffer = new(bytes.Buffer)
+	singleRouter := linuxDevice.LinuxDevice(router.RunTimeConfig{HostConfig: libhost.HostConfig{
+		DeviceType: "frr",
+		Hostname:   host,
+		SSHPort:    sshPort,
+		Username:   "user",
+		Password:   "password",
+	}, W: buffer})
+
+	if err := singleRouter.Connect(); err != nil {
+		t.Fatalf("Cant connect to local ssh server: %s", err)
+	}
+	defer singleRouter.Close()
+
+	if err := singleRouter.RunCommands(strings.NewReader("exit 0\nexit 3\nexit 0\n")); err == nil {
+		t.Error("Exit status 3 was not returned as error")
+	} else if !strings.Contains(err.Error(), "status 3") {
+		t.Errorf("Error does not carry exit status: %s", err)
+	}
+
+	if len(commands) != 2 {
+		t.Errorf("Commands after failed command should not run, got %d commands", len(commands))
+	}
+
+	<-commands
+	<-commands
+
+	output, err := singleRouter.Exec("echo 0")
+	if err != nil || !strings.Contains(output, "echo 0\n") || !strings.Contains(output, "warning: echo 0\n") {
+		t.Errorf("Exec misses stdout or stderr: %q %v", output, err)
+	}
+	<-commands
+
+	if err := singleRouter.PasteConfiguration(strings.NewReader("router bgp 65000\n neighbor 192.0.2.1 remote-as 0\n")); err != nil {
+		t.Errorf("Paste failed: %s", err)
+	}
+
+	if command := <-commands; command != `vtysh -c 'configure terminal' -c 'router bgp 65000' -c ' neighbor 192.0.2.1 remote-as 0'` {
+		t.Errorf("Wrong vtysh command line: %s", command)
+	}
+
+}
+
+func TestPlainShellErrorPolicy(t *testing.T) {
+	commands := make(chan string, 10)
+	addr := startExecServer(t, commands)
+	host, port, _ := net.SplitHostPort(addr)
+	sshPort, _ := strconv.Atoi(port)
+
+	singleRouter := linuxDevice.LinuxDevice(router.RunTimeConfig{HostConfig: libhost.HostConfig{
+		DeviceType: "linux",
+		Hostname:   host,
+		SSHPort:    sshPort,
+		Username:   "user",
+		Password:   "password",
+	}, W: new(bytes.Buffer)})
+
+	if err := singleRouter.Connect(); err != nil {
+		t.Fatalf("Cant connect to local ssh server: %s", err)
+	}
+	defer singleRouter.Close()
+
+	configuration := "ip link set dev eth0 up 0\nip route add 192.0.2.0/24 via 3\n\nsysctl -w net.ipv4.ip_forward 0\n"
+
+	err := singleRouter.PasteConfiguration(strings.NewReader(configuration))
+	if router.Kind(err) != router.ErrConfigRejected {
+		t.Errorf("Failed statement should reject the configuration, got %v", err)
+	}
+	if len(commands) != 2 {
+		t.Errorf("Statements after failed statement should not run with abort policy, got %d commands", len(commands))
+	}
+	for len(commands) > 0 {
+		<-commands
+	}
+
+	singleRouter.RTC.ErrorPolicy = router.PolicyContinue
+	err = singleRouter.PasteConfiguration(strings.NewReader(configuration))
+	if err == nil || !strings.Contains(err.Error(), "1 configuration statements failed: ip route add 192.0.2.0/24 via 3") {
+		t.Errorf("Continue policy should report the failed statement, got %v", err)
+	}
+	if len(commands) != 3 {
+		t.Errorf("Continue policy should run all statements, got %d commands", len(commands))
+	}
+
+	results := singleRouter.PasteResults()
+	if len(results) != 3 {
+		t.Fatalf("Want 3 results, got %d", len(results))
+	}
+	if results[1].Severity != router.SeverityError || results[1].Applied || results[1].Line != 2 {
+		t.Errorf("Wrong result for failed statement: %+v", results[1])
+	}
+	if results[2].Severity != "" || !results[2].Applied || results[2].Line != 4 {
+		t.Errorf("Wrong result for statement after failure: %+v", results[2])
+	}
+}
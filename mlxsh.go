@@ -6,11 +6,14 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/ipcjk/mlxsh/slxDevice"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"runtime"
@@ -19,30 +22,82 @@ import (
 	"time"
 
 	"github.com/ipcjk/mlxsh/junosDevice"
+	"github.com/ipcjk/mlxsh/libcheck"
 	"github.com/ipcjk/mlxsh/libhost"
+	"github.com/ipcjk/mlxsh/libplaybook"
+	"github.com/ipcjk/mlxsh/libreport"
+	"github.com/ipcjk/mlxsh/librollout"
+	"github.com/ipcjk/mlxsh/libstream"
+	"github.com/ipcjk/mlxsh/libtable"
+	"github.com/ipcjk/mlxsh/libtemplate"
+	"github.com/ipcjk/mlxsh/linuxDevice"
 	"github.com/ipcjk/mlxsh/netironDevice"
+	"github.com/ipcjk/mlxsh/profileDevice"
 	"github.com/ipcjk/mlxsh/routerDevice"
+	"github.com/ipcjk/mlxsh/routerosDevice"
 	"github.com/ipcjk/mlxsh/vdxDevice"
 	"github.com/mattn/go-isatty"
 )
 
 var cliWriteTimeout, cliReadTimeout time.Duration
 var cliHostname, cliPassword, cliUsername, cliEnablePassword string
-var debug, version, quiet, cliHostCheck, cliSpeedMode bool
-var outputIsTerminal, cliNoColor, shellMode bool
+var debug, version, quiet, cliHostCheck, cliSpeedMode, cliBackupConfig bool
+var outputIsTerminal, cliNoColor, shellMode, cliDryRun, cliStream, cliCollate, cliCollateDiff bool
 var cliMaxParallel int
-var cliScriptFile, cliConfigFile, cliRouterFile, cliLabel, cliType, cliKeyFile, cliHostFile string
+var cliScriptFile, cliConfigFile, cliRouterFile, cliLabel, cliType, cliKeyFile, cliHostFile, cliProfileDir, cliTransport string
+var cliConfigFormat, cliLoadAction, cliCommitComment, cliPostCheckFile string
+var cliConfirmMinutes, cliCanary, cliBatchPercent, cliMaxFailures int
+var cliDataFile, cliArchiveDir, cliRollback, cliErrorPolicy, cliBatchLabel string
+var templateData map[interface{}]interface{}
+var cliChecksFile, cliAnswer, cliOutput, cliOutDir, cliOutName, cliCollateMask, cliReport string
+var cliParse, cliParseTemplate, cliFormat, cliColumns, cliSort, cliFilter string
+var checkBundle libcheck.Bundle
+var playbookMode bool
+var playbook libplaybook.Playbook
 var selectedHosts, allHosts []libhost.HostConfig
+var deviceProfiles map[string]profileDevice.Profile
+
+/* stdinReader reads the answers of all confirm questions, a reader per question would lose buffered answers */
+var stdinReader = bufio.NewReader(os.Stdin)
 
 type chanHost struct {
 	hostName string
 	message  string
 	err      error
+	skipped  bool
+	host     libhost.HostConfig
+	start    time.Time
+	end      time.Time
+	commands []router.CommandOutput
+
+	preChecks, postChecks []libcheck.Result
 }
 
 func init() {
 	flag.StringVar(&cliScriptFile, "script", "", "script file to to execute, if no file is found, its used as a direct command")
 	flag.StringVar(&cliConfigFile, "config", "", "Configuration file to insert, its used as a direct command")
+	flag.StringVar(&cliConfigFormat, "config-format", "", "Format of the configuration file for Junos: set, text or xml, detected if empty")
+	flag.StringVar(&cliLoadAction, "load-action", "", "Load action for Junos configuration: merge, replace or override")
+	flag.StringVar(&cliDataFile, "data", "", "yaml data file for the lookup function in config and script templates")
+	flag.StringVar(&cliArchiveDir, "archive", "", "NetIron: directory to archive the running-config into before pasting a configuration")
+	flag.StringVar(&cliRollback, "rollback", "", "NetIron: undo a failed paste with inverse statements (inverse) or the archived sections (sections)")
+	flag.StringVar(&cliAnswer, "answer", "", "Answer rule for questions of exec commands, e.g. '\\(y/n\\) -> y' or 'reload => \\(y/n\\) -> y'")
+	flag.StringVar(&cliOutput, "output", "", "Print structured results: json (one array) or ndjson (one record per host and line)")
+	flag.BoolVar(&cliStream, "stream", false, "Print the output of all hosts line by line as it arrives, prefixed with the hostname")
+	flag.BoolVar(&cliCollate, "collate", false, "Print every distinct output once with the list of hosts, that produced it")
+	flag.StringVar(&cliCollateMask, "collate-mask", "", "Regular expression for -collate, matches are masked before comparing, 'default' masks times, dates and uptimes")
+	flag.BoolVar(&cliCollateDiff, "collate-diff", false, "Print a unified diff of every other output against the largest group for -collate")
+	flag.StringVar(&cliOutDir, "outdir", "", "Write the result of every host into its own file below this directory, with an index file")
+	flag.StringVar(&cliParse, "parse", "", "Parse the output of exec commands with TextFSM templates and print the rows as json or csv")
+	flag.StringVar(&cliParseTemplate, "parse-template", "", "TextFSM template file for -parse, that replaces the bundled templates")
+	flag.StringVar(&cliFormat, "format", "", "Parse the output of exec commands and print the rows of all hosts as one table or csv with a host column")
+	flag.StringVar(&cliColumns, "columns", "", "Columns for -format, e.g. 'hostname,NEIGHBOR,STATE'")
+	flag.StringVar(&cliSort, "sort", "", "Sort columns for -format, a leading - sorts descending, e.g. 'STATE,-UPTIME'")
+	flag.StringVar(&cliFilter, "filter", "", "Filters for the rows of -format, e.g. 'state!=ESTAB,accepted<100', use =, !=, ~, !~, <, <=, > or >=")
+	flag.StringVar(&cliReport, "report", "", "Write run reports after the run, e.g. 'junit:run.xml,html:run.html'")
+	flag.StringVar(&cliOutName, "outname", "", "Filename template for -outdir, e.g. '{{.Labels.location}}/{{.Hostname}}/{{.Timestamp}}.txt', defaults to the hostname")
+	flag.StringVar(&cliErrorPolicy, "error-policy", "", "Stop a configuration paste on errors and warnings (abort), never (continue) or only on errors (abort-on-error-ignore-warning)")
+	flag.StringVar(&cliChecksFile, "checks", "", "yaml file with pre_checks and post_checks, that run before and after the change")
 	flag.StringVar(&cliLabel, "label", "", "label-selection for run commands on a group of routers, e.g. 'location=munich,environment=prod'")
 	flag.StringVar(&cliHostname, "hostname", "", "Router hostname")
 	flag.StringVar(&cliPassword, "password", "", "user password")
@@ -50,14 +105,25 @@ func init() {
 	flag.StringVar(&cliEnablePassword, "enable", "", "enable password")
 	flag.StringVar(&cliType, "clitype", "mlxe", "Router type")
 	flag.StringVar(&cliKeyFile, "i", "", "Path to a ssh private key (in openssh2-format) that will be used for connections ")
+	flag.StringVar(&cliTransport, "transport", "", "Transport for devices that support more than the cli, e.g. netconf for Junos")
+	flag.StringVar(&cliProfileDir, "profiles", "", "Directory with yaml device profiles for additional device types")
 	flag.StringVar(&cliHostFile, "sf", "", "Path to the known-hosts-file (in openssh2-format) that will be used for validating hostkeys, defaults to .ssh/known_hosts ")
 	flag.IntVar(&cliMaxParallel, "c", 20, "concurrent working threads")
+	flag.IntVar(&cliCanary, "canary", 0, "Run the first N hosts as canary and ask for confirmation before the remaining hosts")
+	flag.IntVar(&cliBatchPercent, "batch-percent", 0, "Roll out in batches of this percentage of the hosts")
+	flag.StringVar(&cliBatchLabel, "batch-label", "", "Roll out in batches, one batch per value of this label, e.g. location")
+	flag.IntVar(&cliMaxFailures, "max-failures", 0, "Halt the remaining batches, when more hosts failed, 0 halts on the first failed host")
+	flag.IntVar(&cliConfirmMinutes, "confirm-minutes", 0, "Junos: commit confirmed with this timeout, run post-checks and confirm afterwards")
+	flag.StringVar(&cliCommitComment, "commit-comment", "", "Junos: comment for the commit, defaults to \"mlxsh change\"")
+	flag.StringVar(&cliPostCheckFile, "post-check", "", "Junos: commands to run after a confirmed commit, the commit is only confirmed if all succeed")
 	flag.DurationVar(&cliReadTimeout, "readtimeout", time.Second*30, "timeout for reading poll on cli select")
 	flag.DurationVar(&cliWriteTimeout, "writetimeout", time.Millisecond*0, "timeout to stall after a write to cli")
 	flag.BoolVar(&shellMode, "shell", false, "Run in libreadline command line prompt mode")
+	flag.BoolVar(&cliDryRun, "dry-run", false, "Print the commands and mode changes for every selected host without connecting")
 	flag.BoolVar(&debug, "debug", false, "Enable debug for read / write")
 	flag.BoolVar(&cliHostCheck, "s", false, "Enable strict hostkey checking for ssh connections")
 	flag.BoolVar(&cliSpeedMode, "speedmode", false, "Enable speed mode write, will ignore any output from the cli while writing")
+	flag.BoolVar(&cliBackupConfig, "backup", false, "Export a configuration backup on the device when committing (RouterOS)")
 	flag.BoolVar(&quiet, "q", false, "quiet mode, no output except error on connecting & co")
 	flag.BoolVar(&version, "version", false, "prints version and exit")
 	flag.BoolVar(&cliNoColor, "nocolor", false, "Disable color printing when output line is a terminal")
@@ -69,7 +135,27 @@ func init() {
 		flag.StringVar(&cliRouterFile, "routerdb", "mlxsh.yaml", "Input file in yaml for username,password and host configuration if not specified on command-line")
 	}
 
-	flag.Parse()
+	parseFlags(os.Args[1:])
+
+	/* mlxsh play playbook.yaml [flags] */
+	if flag.Arg(0) == "play" {
+		if flag.Arg(1) == "" {
+			log.Fatal("No playbook given, use mlxsh play playbook.yaml")
+		}
+
+		file, err := os.Open(flag.Arg(1))
+		if err != nil {
+			log.Fatal(err)
+		}
+		playbook, err = libplaybook.LoadPlaybook(file)
+		file.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		parseFlags(flag.Args()[2:])
+		playbookMode = true
+	}
 
 	if version {
 		log.Println("mlxsh 0.6 (C) 2021 by Jörg Kost, jk@ip-clear.de")
@@ -85,18 +171,149 @@ func init() {
 		os.Exit(0)
 	}
 
-	if cliHostname == "" && cliLabel == "" && !shellMode {
+	if cliHostname == "" && cliLabel == "" && !shellMode && !playbookMode {
 		log.Println("No host/router or selector given, abort...")
-		os.Exit(0)
+		os.Exit(exitConfig)
 	} else if cliHostname != "" && cliLabel != "" && shellMode == false {
 		log.Println("Cant run in targetHost-mode or groupselection")
-		os.Exit(0)
+		os.Exit(exitConfig)
 	}
 
 	if cliHostFile == "" {
 		cliHostFile = getUserKnownHostsFile()
 	}
 
+	if cliProfileDir != "" {
+		var err error
+		if deviceProfiles, err = profileDevice.LoadProfiles(cliProfileDir); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if err := router.CheckPolicy(cliErrorPolicy); err != nil {
+		log.Fatal(err)
+	}
+
+	switch cliOutput {
+	case "", "text", "json", "ndjson":
+	default:
+		log.Fatalf("Unknown output format %s, use text, json or ndjson", cliOutput)
+	}
+
+	if playbookMode && structuredOutput() {
+		log.Fatal("Structured output is not supported for playbooks")
+	}
+
+	if cliCollateMask != "" || cliCollateDiff {
+		cliCollate = true
+	}
+
+	if cliCollate {
+		if structuredOutput() || playbookMode {
+			log.Fatal("Collating is not supported with structured output or playbooks")
+		}
+		if err := parseCollateMask(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if cliStream && (structuredOutput() || playbookMode) {
+		log.Fatal("Streaming is not supported with structured output or playbooks")
+	}
+
+	if cliOutDir != "" {
+		if playbookMode {
+			log.Fatal("Output files are not supported for playbooks")
+		}
+		if err := parseOutName(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	switch cliParse {
+	case "", "json", "csv":
+	default:
+		log.Fatalf("Unknown parse format %s, use json or csv", cliParse)
+	}
+
+	switch cliFormat {
+	case "", "table", "csv":
+	default:
+		log.Fatalf("Unknown format %s, use table or csv", cliFormat)
+	}
+
+	if cliFormat == "" && (cliColumns != "" || cliSort != "" || cliFilter != "") {
+		log.Fatal("Columns, sorting and filters need -format table or csv")
+	}
+
+	if cliFormat != "" {
+		if cliParse != "" {
+			log.Fatal("Use either -parse or -format")
+		}
+		var err error
+		if rowFilters, err = libtable.ParseFilters(cliFilter); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if cliParseTemplate != "" && cliParse == "" && cliFormat == "" {
+		cliParse = "json"
+	}
+
+	if parsing() {
+		if structuredOutput() || cliCollate || cliStream || playbookMode {
+			log.Fatal("Parsing is not supported with structured output, collating, streaming or playbooks")
+		}
+		if cliConfigFile != "" {
+			log.Fatal("Parsing needs exec commands from -script, not -config")
+		}
+		if cliParseTemplate != "" {
+			if err := loadParseTemplate(); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+
+	if cliReport != "" {
+		if playbookMode {
+			log.Fatal("Reports are not supported for playbooks")
+		}
+		var err error
+		if reportTargets, err = libreport.ParseTargets(cliReport); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if cliAnswer != "" {
+		if _, err := router.ParseAnswerRule(cliAnswer); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if cliDataFile != "" {
+		file, err := os.Open(cliDataFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		templateData, err = libtemplate.LoadData(file)
+		file.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if cliChecksFile != "" {
+		file, err := os.Open(cliChecksFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		checkBundle, err = libcheck.LoadBundle(file)
+		file.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	if cliRouterFile != "" {
 		file, err := os.Open(cliRouterFile)
 		if err != nil {
@@ -107,6 +324,15 @@ func init() {
 		if err != nil {
 			log.Fatal(err)
 		}
+
+		/* without selection on the command line, the playbook targets select the hosts */
+		if playbookMode && cliHostname == "" && cliLabel == "" {
+			for _, host := range allHosts {
+				if libplaybook.MatchSelector(host, playbook.Targets) {
+					selectedHosts = append(selectedHosts, host)
+				}
+			}
+		}
 	}
 
 	/* Setup done for shellmode, rest setup is only done for one-shot */
@@ -127,57 +353,159 @@ func init() {
 func main() {
 	if shellMode {
 		runShellMode()
+		return
+	}
+
+	if playbookMode {
+		runPlaybook()
 	} else {
 		run()
 	}
+
+	if !quiet {
+		printSummary()
+	}
+	os.Exit(summary.exitCode())
+}
+
+/* parseFlags parses the command line, a wrong flag is a configuration error */
+func parseFlags(arguments []string) {
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+
+	if err := flag.CommandLine.Parse(arguments); err == flag.ErrHelp {
+		os.Exit(exitOK)
+	} else if err != nil {
+		os.Exit(exitConfig)
+	}
 }
 
 func applyCliSettings() {
 	/* Possible overwrite settings from CliParameters */
 	for x := range selectedHosts {
 		selectedHosts[x].ApplyCliSettings(cliScriptFile, cliConfigFile, cliWriteTimeout, cliReadTimeout, cliHostCheck, cliKeyFile, cliHostFile)
+		if cliBackupConfig {
+			selectedHosts[x].BackupConfig = true
+		}
+		if cliTransport != "" {
+			selectedHosts[x].Transport = cliTransport
+		}
+		if cliConfigFormat != "" {
+			selectedHosts[x].ConfigFormat = cliConfigFormat
+		}
+		if cliLoadAction != "" {
+			selectedHosts[x].LoadAction = cliLoadAction
+		}
+		if cliCommitComment != "" {
+			selectedHosts[x].CommitComment = cliCommitComment
+		}
+		if cliConfirmMinutes != 0 {
+			selectedHosts[x].ConfirmMinutes = cliConfirmMinutes
+		}
+		if cliPostCheckFile != "" {
+			selectedHosts[x].PostCheckFile = cliPostCheckFile
+		}
+		if cliArchiveDir != "" {
+			selectedHosts[x].ArchiveDir = cliArchiveDir
+		}
+		if cliRollback != "" {
+			selectedHosts[x].Rollback = cliRollback
+		}
+		if cliErrorPolicy != "" {
+			selectedHosts[x].ErrorPolicy = cliErrorPolicy
+		}
+		if cliAnswer != "" {
+			selectedHosts[x].Answers = append(selectedHosts[x].Answers, cliAnswer)
+		}
 	}
 }
 
 /* Config or Exec-Statements running from command line parameter or file input */
 func run() {
+	var failures int
+
+	applyCliSettings()
+
+	if cliStream {
+		streamOutput = libstream.NewOutput(os.Stdout)
+		for _, host := range selectedHosts {
+			if len(host.Hostname) > streamWidth {
+				streamWidth = len(host.Hostname)
+			}
+		}
+	}
+
+	strategy := librollout.Strategy{Canary: cliCanary, BatchPercent: cliBatchPercent, BatchLabel: cliBatchLabel, MaxFailures: cliMaxFailures}
+	batches, err := librollout.Plan(selectedHosts, strategy)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for x, batch := range batches {
+		if len(batches) > 1 {
+			notice("=== batch %d/%d: %d hosts\n", x+1, len(batches), len(batch))
+		}
+
+		failures += printResults(runBatch(batch), &summary)
+
+		if x == len(batches)-1 {
+			break
+		}
+
+		if strategy.Halt(failures) {
+			notice("=== halted: %d hosts failed, threshold is %d\n", failures, strategy.MaxFailures)
+			skipHosts(batches[x+1:])
+			break
+		}
+
+		if x == 0 && strategy.Canary > 0 && !cliDryRun && !confirm("Canary done, continue with the remaining hosts? [y/N] ") {
+			notice("=== aborted after canary\n")
+			skipHosts(batches[x+1:])
+			break
+		}
+	}
+
+	flushRecords()
+	printCollated()
+	printParsed()
+	writeIndex()
+	writeReports()
+}
+
+/* runBatch runs all hosts of a batch in parallel, the channel is closed after the last host */
+func runBatch(hosts []libhost.HostConfig) <-chan chanHost {
 	hostChannel := make(chan chanHost, 1)
 	var wg sync.WaitGroup
 	var semaphore = make(chan struct{}, cliMaxParallel)
 
-	applyCliSettings()
-
 	// worker
-	for x := range selectedHosts {
+	for x := range hosts {
 		wg.Add(1)
 		go func(x int) {
 			semaphore <- struct{}{}
 
 			var err error
+			var commands []router.CommandOutput
+			var preResults, postResults []libcheck.Result
+			var start = time.Now()
 			var buffer = new(bytes.Buffer)
-			var singleRouter RouterInt
-
-			switch strings.ToLower(selectedHosts[x].DeviceType) {
-			case "vdx":
-				singleRouter = RouterInt(vdxDevice.VdxDevice(router.RunTimeConfig{HostConfig: selectedHosts[x], Debug: debug, W: buffer}))
-			case "slx":
-				singleRouter = RouterInt(slxDevice.SlxDevice(router.RunTimeConfig{HostConfig: selectedHosts[x], Debug: debug, W: buffer}))
-			case "mlx", "cer", "mlxe", "xmr", "iron", "turobiron", "icx", "fcs":
-				singleRouter = RouterInt(netironDevice.NetironDevice(
-					router.RunTimeConfig{HostConfig: selectedHosts[x], Debug: debug, W: buffer}))
-			case "juniper", "junos", "mx", "ex", "j":
-				singleRouter = RouterInt(junosDevice.JunosDevice(router.RunTimeConfig{HostConfig: selectedHosts[x], Debug: debug, W: buffer}))
-			default:
-				/* Default always to Netiron for compatible  */
-				singleRouter = RouterInt(netironDevice.NetironDevice(
-					router.RunTimeConfig{HostConfig: selectedHosts[x], Debug: debug, W: buffer}))
+			var w, raw io.Writer = buffer, nil
+			var stream *libstream.LineWriter
+			if streamOutput != nil {
+				stream = streamOutput.Writer(streamPrefix(hosts[x].Hostname))
+				w, raw = io.MultiWriter(buffer, stream), stream
 			}
+			var singleRouter = getRouter(hosts[x], w, raw)
 
 			defer func() {
 				if singleRouter != nil {
 					singleRouter.Close()
 				}
-				hostChannel <- chanHost{message: buffer.String(), hostName: selectedHosts[x].Hostname, err: err}
+				if stream != nil {
+					stream.Flush()
+				}
+				hostChannel <- chanHost{message: buffer.String(), hostName: hosts[x].Hostname, err: err,
+					host: hosts[x], start: start, end: time.Now(), commands: commands,
+					preChecks: preResults, postChecks: postResults}
 				wg.Done()
 				<-semaphore
 			}()
@@ -187,32 +515,76 @@ func run() {
 				return
 			}
 
-			if err = singleRouter.Connect(); err != nil {
-				return
+			var input io.Reader
+			if hosts[x].Filename != "" {
+				if input, err = readInput(hosts[x]); err != nil {
+					return
+				}
 			}
 
-			if selectedHosts[x].Filename != "" {
-				var input io.Reader
-				var file *os.File
+			/* Dry-run: print what would be sent, but never connect */
+			if cliDryRun {
+				dryRunner, ok := singleRouter.(DryRunner)
+				if !ok {
+					err = fmt.Errorf("dry-run is not supported for device type %s", hosts[x].DeviceType)
+					return
+				}
+				if input == nil {
+					input = strings.NewReader("")
+				}
+				for _, c := range checkBundle.PreChecks {
+					fmt.Fprintf(buffer, "[pre-check] %s\n", c.Command)
+				}
+				err = dryRunner.DryRun(hosts[x].ExecMode, input)
+				for _, c := range checkBundle.PostChecks {
+					fmt.Fprintf(buffer, "[post-check] %s\n", c.Command)
+				}
+				return
+			}
 
-				file, err = os.Open(selectedHosts[x].Filename)
-				defer file.Close()
+			if err = singleRouter.Connect(); err != nil {
+				return
+			}
 
-				if err != nil && os.IsNotExist(err) {
-					command := strings.Replace(selectedHosts[x].Filename, ";", "\n", -1)
-					input = strings.NewReader(command)
-					if debug {
-						log.Printf("Cant open file: %s, will read from command line argument\n", err)
+			if len(checkBundle.PreChecks) > 0 {
+				preResults, err = libcheck.Run(singleRouter, buffer, checkBundle.PreChecks, nil)
+				if errors.Is(err, libcheck.ErrFailed) {
+					for _, line := range libcheck.Diff(nil, preResults) {
+						fmt.Fprintln(buffer, line)
 					}
-				} else if err != nil {
-					log.Printf("Cant open file: %s\n", err)
-				} else {
-					input = file
+					err = fmt.Errorf("pre-%w, nothing was changed", err)
+				}
+				if err != nil {
+					return
 				}
+			}
 
+			/* a commit confirmed runs the post-checks before it confirms, Junos reverts on failure */
+			var confirmChecked bool
+			if confirmer, ok := singleRouter.(ConfirmChecker); ok && len(checkBundle.PostChecks) > 0 {
+				confirmer.SetConfirmCheck(func() (checkErr error) {
+					confirmChecked = true
+					postResults, checkErr = libcheck.Run(singleRouter, buffer, checkBundle.PostChecks, preResults)
+					for _, line := range libcheck.Diff(preResults, postResults) {
+						fmt.Fprintln(buffer, line)
+					}
+					if errors.Is(checkErr, libcheck.ErrFailed) {
+						return fmt.Errorf("post-%w", checkErr)
+					}
+					return checkErr
+				})
+			}
+
+			if input != nil {
 				/* Execution Mode starts here */
-				if selectedHosts[x].ExecMode {
-					if err = singleRouter.RunCommands(input); err != nil {
+				if hosts[x].ExecMode {
+					/* recorded commands replace their raw output with echo and prompts */
+					recorded, offset := len(commandOutputs(singleRouter)), buffer.Len()
+					err = singleRouter.RunCommands(input)
+					if commands = commandOutputs(singleRouter)[recorded:]; len(commands) > 0 {
+						buffer.Truncate(offset)
+					}
+					if err != nil {
 						return
 					}
 				} else {
@@ -221,7 +593,11 @@ func run() {
 					if err = singleRouter.ConfigureTerminalMode(); err != nil {
 						return
 					}
-					if err = singleRouter.PasteConfiguration(input); err != nil {
+					err = singleRouter.PasteConfiguration(input)
+					if resulter, ok := singleRouter.(PasteResulter); ok {
+						reportPasteResults(buffer, resulter.PasteResults())
+					}
+					if err != nil {
 						return
 					}
 					if err = singleRouter.CommitConfiguration(); err != nil {
@@ -230,6 +606,26 @@ func run() {
 
 				}
 			}
+
+			if len(checkBundle.PostChecks) > 0 && !confirmChecked {
+				postResults, err = libcheck.Run(singleRouter, buffer, checkBundle.PostChecks, preResults)
+				if err != nil && !errors.Is(err, libcheck.ErrFailed) {
+					return
+				}
+				for _, line := range libcheck.Diff(preResults, postResults) {
+					fmt.Fprintln(buffer, line)
+				}
+				if err != nil {
+					err = fmt.Errorf("post-%w", err)
+					if rollbacker, ok := singleRouter.(Rollbacker); ok && !hosts[x].ExecMode && input != nil {
+						if rollbackErr := rollbacker.Rollback(); rollbackErr != nil {
+							err = fmt.Errorf("post-%w, rollback failed: %s", libcheck.ErrFailed, rollbackErr)
+						} else {
+							err = fmt.Errorf("post-%w, change was rolled back", libcheck.ErrFailed)
+						}
+					}
+				}
+			}
 		}(x)
 	}
 
@@ -239,29 +635,258 @@ func run() {
 		close(hostChannel)
 	}()
 
-	// printer
+	return hostChannel
+}
+
+/* printResults prints the result of every host, counts it for the summary, if given, and returns the number of failed hosts */
+func printResults(hostChannel <-chan chanHost, counts *runSummary) (failed int) {
 	for elems := range hostChannel {
+		var line bytes.Buffer
 		state := "OK"
 
+		if counts != nil {
+			counts.add(elems)
+		}
+
+		if len(reportTargets) > 0 {
+			reportHosts = append(reportHosts, elems)
+		}
+
+		if cliOutDir != "" {
+			saveHostOutput(elems)
+		}
+
+		if structuredOutput() {
+			if elems.err != nil {
+				failed++
+			}
+			writeRecord(newHostRecord(elems))
+			continue
+		}
+
+		if parsing() && !elems.skipped {
+			if elems.err != nil {
+				failed++
+			}
+			parsedHosts = append(parsedHosts, elems)
+			continue
+		}
+
+		if cliCollate && !elems.skipped {
+			if elems.err != nil {
+				failed++
+			}
+			collatedHosts = append(collatedHosts, elems)
+			continue
+		}
+
+		if elems.skipped {
+			notice("skip: [%-20s]\n", elems.hostName)
+			continue
+		}
+
 		if elems.err != nil {
 			state = "err"
+			failed++
 		}
 
 		if !outputIsTerminal || cliNoColor {
-			fmt.Printf("%s: [%-20s]", state, elems.hostName)
+			fmt.Fprintf(&line, "%s: [%-20s]", state, elems.hostName)
 		} else if state == "err" {
-			fmt.Printf("\x1b[31m%s: [%-20s]\x1b[0m", state, elems.hostName)
+			fmt.Fprintf(&line, "\x1b[31m%s: [%-20s]\x1b[0m", state, elems.hostName)
 		} else {
-			fmt.Printf("\x1b[32m%s: [%-20s]\x1b[0m", state, elems.hostName)
+			fmt.Fprintf(&line, "\x1b[32m%s: [%-20s]\x1b[0m", state, elems.hostName)
 		}
 
-		if state == "err" {
-			fmt.Printf(" errors: %s, messages: %s", elems.message, elems.err)
-		} else if !quiet {
-			fmt.Printf(" %s", elems.message)
+		/* streamed output was already printed */
+		if state == "err" && cliStream {
+			fmt.Fprintf(&line, " messages: %s", elems.err)
+		} else if state == "err" {
+			fmt.Fprintf(&line, " errors: %s, messages: %s", hostOutput(elems), elems.err)
+		} else if !quiet && !cliStream {
+			fmt.Fprintf(&line, " %s", hostOutput(elems))
+		}
+
+		line.WriteString("\n")
+		stdout().Write(line.Bytes())
+	}
+
+	return
+}
+
+/* runPlaybook runs the steps of the playbook on the selected hosts and prints the results of every step */
+func runPlaybook() {
+	applyCliSettings()
+
+	runner := libplaybook.Runner{
+		Playbook: playbook,
+		Hosts:    selectedHosts,
+		Data:     templateData,
+		Parallel: cliMaxParallel,
+		DryRun:   cliDryRun,
+		NewDevice: func(host libhost.HostConfig, w io.Writer) libplaybook.Device {
+			return getRouter(host, w, nil)
+		},
+		Confirm: confirm,
+	}
+
+	hostErrors := make(map[string]error)
+
+	_, err := runner.Run(func(step libplaybook.Step, results []libplaybook.StepResult) {
+		fmt.Printf("=== step %s\n", step.Name)
+
+		hostChannel := make(chan chanHost, len(results))
+		for _, r := range results {
+			hostChannel <- chanHost{hostName: r.Hostname, message: r.Output, err: r.Err, skipped: r.Skipped}
+			if r.Err != nil {
+				hostErrors[r.Hostname] = r.Err
+			}
+		}
+		close(hostChannel)
+
+		/* the hosts of a step are not counted, a host runs several steps */
+		printResults(hostChannel, nil)
+	})
+
+	if err != nil {
+		fmt.Printf("=== %s\n", err)
+	}
+
+	/* the summary counts every host once, hosts of an aborted playbook are skipped */
+	for _, host := range selectedHosts {
+		switch {
+		case hostErrors[host.Hostname] != nil:
+			summary.add(chanHost{hostName: host.Hostname, err: hostErrors[host.Hostname]})
+		case err != nil:
+			summary.add(chanHost{hostName: host.Hostname, skipped: true})
+		default:
+			summary.add(chanHost{hostName: host.Hostname})
 		}
+	}
+}
+
+/* commandOutputs returns the recorded exec commands of a router module */
+func commandOutputs(r RouterInt) []router.CommandOutput {
+	if outputer, ok := r.(CommandOutputer); ok {
+		return outputer.CommandOutputs()
+	}
+	return nil
+}
+
+/* hostOutput returns the output of a host for the text output, every command has its own section */
+func hostOutput(elems chanHost) string {
+	var output bytes.Buffer
+
+	for _, c := range elems.commands {
+		fmt.Fprintf(&output, "\n> %s\n%s\n", c.Command, c.Output)
+	}
+
+	output.WriteString(elems.message)
+	return output.String()
+}
 
-		fmt.Printf("\n")
+/* skipHosts prints the hosts of batches, that were not started */
+func skipHosts(batches [][]libhost.HostConfig) {
+	for _, batch := range batches {
+		for _, host := range batch {
+			summary.add(chanHost{hostName: host.Hostname, skipped: true})
+			if len(reportTargets) > 0 {
+				reportHosts = append(reportHosts, chanHost{hostName: host.Hostname, host: host, skipped: true})
+			}
+			if cliOutDir != "" {
+				saveHostOutput(chanHost{hostName: host.Hostname, host: host, skipped: true})
+			}
+			if structuredOutput() {
+				writeRecord(newHostRecord(chanHost{hostName: host.Hostname, host: host, skipped: true}))
+				continue
+			}
+			notice("skip: [%-20s]\n", host.Hostname)
+		}
+	}
+}
+
+/* confirm asks on the terminal, an empty answer or a closed stdin is a no */
+func confirm(question string) bool {
+	notice("%s", question)
+
+	answer, err := stdinReader.ReadString('\n')
+	if err != nil {
+		return false
+	}
+
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+/* reportPasteResults writes every configuration statement, that raised an error or warning */
+func reportPasteResults(w io.Writer, results []router.LineResult) {
+	for _, r := range results {
+		if r.Severity == router.SeverityError || r.Severity == router.SeverityWarning {
+			fmt.Fprintf(w, "%s line %d: %s: %s\n", r.Severity, r.Line, r.Statement, r.Message)
+		}
+	}
+}
+
+/*
+readInput returns the content of a script or configuration file, if no file is
+found, the filename is used as one-liner with ; as line separator. Templates
+are rendered for the host, a rendering error aborts the host before connecting.
+*/
+func readInput(host libhost.HostConfig) (io.Reader, error) {
+	var source, name = "", host.Filename
+
+	content, err := ioutil.ReadFile(host.Filename)
+	if err != nil && os.IsNotExist(err) {
+		if debug {
+			log.Printf("Cant open file: %s, will read from command line argument\n", err)
+		}
+		source, name = strings.Replace(host.Filename, ";", "\n", -1), "command line"
+	} else if err != nil {
+		return nil, fmt.Errorf("Cant open file: %s", err)
+	} else {
+		source = string(content)
+	}
+
+	if libtemplate.IsTemplate(source) {
+		if source, err = libtemplate.Render(name, source, host, templateData); err != nil {
+			return nil, err
+		}
+	}
+
+	return strings.NewReader(source), nil
+}
+
+/*
+getRouter returns the driver for the hosts device type, profiles loaded from
+the profile directory have precedence over the built-in drivers. stream gets
+the device output as it is read, it is nil without -stream.
+*/
+func getRouter(host libhost.HostConfig, w, stream io.Writer) RouterInt {
+	rtc := router.RunTimeConfig{HostConfig: host, Debug: debug, W: w, Stream: stream}
+
+	if profile, ok := deviceProfiles[strings.ToLower(host.DeviceType)]; ok {
+		return RouterInt(profileDevice.ProfileDevice(rtc, profile))
+	}
+
+	switch strings.ToLower(host.DeviceType) {
+	case "vdx":
+		return RouterInt(vdxDevice.VdxDevice(rtc))
+	case "slx":
+		return RouterInt(slxDevice.SlxDevice(rtc))
+	case "mlx", "cer", "mlxe", "xmr", "iron", "turobiron", "icx", "fcs":
+		return RouterInt(netironDevice.NetironDevice(rtc))
+	case "juniper", "junos", "mx", "ex", "j":
+		if strings.ToLower(host.Transport) == "netconf" {
+			return RouterInt(junosDevice.JunosNetconfDevice(rtc))
+		}
+		return RouterInt(junosDevice.JunosDevice(rtc))
+	case "routeros", "mikrotik", "ros":
+		return RouterInt(routerosDevice.RouterOSDevice(rtc))
+	case "linux", "frr", "vtysh", "quagga":
+		return RouterInt(linuxDevice.LinuxDevice(rtc))
+	default:
+		/* Default always to Netiron for compatible  */
+		return RouterInt(netironDevice.NetironDevice(rtc))
 	}
 }
 
@@ -12,11 +12,17 @@ import (
 type netironDevice struct {
 	RTC    router.RunTimeConfig
 	Router router.Router
+
+	/* running-config before the paste, NetIron has no candidate configuration */
+	runningConfig string
+	archived      bool
 }
 
 /*NetironDevice returns a new netironDevice object, has a init struct of type NetironConfig */
 func NetironDevice(Config router.RunTimeConfig) *netironDevice {
 	var configureErrors = `(?i)(Please first configure|invalid command|Invalid input|Warning|skipped due|Error)`
+	var execErrors = `(?m)^\s*(Invalid input -> |Ambiguous input -> |Unrecognized command|Incomplete command)`
+	var configureFailures = `(?i)(Please first configure|invalid command|Invalid input|skipped due|Error)`
 
 	router.GenerateDefaults(&Config)
 
@@ -41,11 +47,16 @@ func NetironDevice(Config router.RunTimeConfig) *netironDevice {
 			},
 			PromptReadTriggers: []string{">", "#"},
 			PromptModes:        make(map[string]string),
-			ErrorMatches:       regexp.MustCompile(configureErrors)}}
+			ErrorMatches:       regexp.MustCompile(configureErrors),
+			ExecErrorMatches:   regexp.MustCompile(execErrors),
+			Classifiers: []router.Classifier{
+				{Severity: router.SeverityError, Pattern: regexp.MustCompile(configureFailures)},
+				{Severity: router.SeverityWarning, Pattern: regexp.MustCompile(`(?i)Warning`)},
+			}}}
 }
 func (b *netironDevice) Connect() (err error) {
 
-	if err = b.Router.SetupSSH(b.RTC.ConnectionAddr, b.RTC.SSHClientConfig, false); err != nil {
+	if err = b.Router.SetupSSH(b.RTC, false); err != nil {
 		return err
 	}
 
@@ -60,7 +71,7 @@ func (b *netironDevice) Connect() (err error) {
 
 	/* Try login if promptMode is NonEnabled */
 	if b.Router.PromptMode == "sshNonEnabled" && !b.loginDialog() {
-		return fmt.Errorf("Cant login")
+		return router.Errorf(router.ErrAuth, b.RTC.Hostname, "Cant login")
 	}
 
 	if _, err = b.skipPageDisplayMode(); err != nil {
@@ -80,7 +91,7 @@ func (b *netironDevice) DetectSetPrompt(prompt string) error {
 		b.Router.PromptMode = "sshNonEnabled"
 		b.Router.SSHUnprivilegedPrompt = prompt
 	} else if err != nil {
-		return fmt.Errorf("Cant run regexp for prompt detection, weird! Error was: %s", err)
+		return router.Errorf(router.ErrPromptDetect, b.RTC.Hostname, "Cant run regexp for prompt detection, weird! Error was: %s", err)
 	}
 
 	matched, err = regexp.MatchString("#$", prompt)
@@ -88,7 +99,7 @@ func (b *netironDevice) DetectSetPrompt(prompt string) error {
 		b.Router.PromptMode = "sshEnabled"
 		b.Router.SSHUnprivilegedPrompt = strings.Replace(prompt, "#", ">", 1)
 	} else if err != nil {
-		return fmt.Errorf("Cant run regexp for prompt detection, weird! Error was: %s", err)
+		return router.Errorf(router.ErrPromptDetect, b.RTC.Hostname, "Cant run regexp for prompt detection, weird! Error was: %s", err)
 	}
 
 	/*
@@ -111,7 +122,7 @@ func (b *netironDevice) DetectSetPrompt(prompt string) error {
 	}
 
 	if b.Router.SSHEnabledPrompt == "" || b.Router.SSHUnprivilegedPrompt == "" {
-		return fmt.Errorf("Cant detect any prompt")
+		return router.Errorf(router.ErrPromptDetect, b.RTC.Hostname, "Cant detect any prompt")
 	}
 
 	return nil
@@ -151,14 +162,26 @@ func (b *netironDevice) loginDialog() bool {
 }
 
 func (b *netironDevice) ConfigureTerminalMode() error {
+	if b.RTC.Rollback != "" && b.RTC.Rollback != "inverse" && b.RTC.Rollback != "sections" {
+		return fmt.Errorf("Unknown rollback mode %s, use inverse or sections", b.RTC.Rollback)
+	}
+
+	if (b.RTC.ArchiveDir != "" || b.RTC.Rollback != "") && !b.archived {
+		if err := b.archiveRunningConfig(); err != nil {
+			return err
+		}
+		b.archived = true
+	}
+
 	if err := b.Router.Write(b.RTC, "conf t\n"); err != nil {
 		return err
 	}
 
 	_, err := b.Router.ReadTill(b.RTC, []string{"(config)#"})
 	if err != nil {
-		return fmt.Errorf("Cant find configure prompt: %s", err)
+		return router.Errorf(router.ErrPromptDetect, b.RTC.Hostname, "Cant find configure prompt: %w", err)
 	}
+	b.Router.PromptMode = "sshConfig"
 
 	if b.RTC.Debug {
 		fmt.Fprint(b.RTC.W, "Configuration mode on")
@@ -168,7 +191,7 @@ func (b *netironDevice) ConfigureTerminalMode() error {
 
 func (b *netironDevice) skipPageDisplayMode() (string, error) {
 	if err := b.SwitchMode("sshEnabled"); err != nil {
-		return "", fmt.Errorf("Cant switch to enabled mode to execute skip-page-display: %s", err)
+		return "", fmt.Errorf("Cant switch to enabled mode to execute skip-page-display: %w", err)
 	}
 
 	if err := b.Router.Write(b.RTC, "skip-page-display\n"); err != nil {
@@ -198,7 +221,7 @@ func (b *netironDevice) SwitchMode(targetMode string) error {
 	switch b.Router.PromptMode {
 	case "sshEnabled":
 		if targetMode == "sshConfig" {
-			b.ConfigureTerminalMode()
+			return b.ConfigureTerminalMode()
 		} else {
 			if err := b.Router.Write(b.RTC, "exit\n"); err != nil {
 				return err
@@ -209,6 +232,10 @@ func (b *netironDevice) SwitchMode(targetMode string) error {
 			if err := b.Router.Write(b.RTC, "end\n"); err != nil {
 				return err
 			}
+			if _, err := b.readTillEnabledPrompt(); err != nil {
+				return router.Errorf(router.ErrPromptDetect, b.RTC.Hostname, "Cant leave configuration mode: %w", err)
+			}
+			b.Router.PromptMode = "sshEnabled"
 		} else {
 			if err := b.Router.Write(b.RTC, "end\n"); err != nil {
 				return err
@@ -235,7 +262,7 @@ func (b *netironDevice) getPromptMode() error {
 
 	mode, err := b.Router.ReadTill(b.RTC, []string{b.Router.SSHConfigPrompt, b.Router.SSHEnabledPrompt, b.Router.SSHUnprivilegedPrompt})
 	if err != nil {
-		return fmt.Errorf("Cant find command line mode: %s", err)
+		return router.Errorf(router.ErrPromptDetect, b.RTC.Hostname, "Cant find command line mode: %w", err)
 	}
 
 	mode = strings.TrimSpace(mode)
@@ -255,7 +282,7 @@ func (b *netironDevice) getPromptMode() error {
 }
 
 func (b *netironDevice) CommitConfiguration() (err error) {
-
+	/* write memory runs in exec mode, SwitchMode sends end first */
 	if err = b.SwitchMode("sshEnabled"); err != nil {
 		return err
 	}
@@ -264,9 +291,13 @@ func (b *netironDevice) CommitConfiguration() (err error) {
 		return err
 	}
 
-	_, err = b.Router.ReadTill(b.RTC, []string{"(config)#", "Write startup-config done."})
+	val, err := b.readTillEnabledPrompt()
 	if err != nil {
-		return fmt.Errorf("Cant write memory flash: %s", err)
+		return router.Errorf(router.ErrCommitFailed, b.RTC.Hostname, "Cant write memory flash: %w", err)
+	}
+
+	if !strings.Contains(val, "Write startup-config done.") {
+		return router.Errorf(router.ErrCommitFailed, b.RTC.Hostname, "Write memory not successful: %s", strings.TrimSpace(val))
 	}
 
 	if b.RTC.Debug {
@@ -281,17 +312,75 @@ func (b *netironDevice) PasteConfiguration(configuration io.Reader) (err error)
 		return err
 	}
 
-	return b.Router.PasteConfiguration(b.RTC, configuration)
+	results, err := b.Router.PasteConfigurationResults(b.RTC, configuration)
+	if err != nil {
+		applied := router.AppliedStatements(results)
+
+		fmt.Fprintf(b.RTC.W, "\nApplied %d statements before the failure:\n", len(applied))
+		for _, statement := range applied {
+			fmt.Fprintf(b.RTC.W, "+ %s\n", statement)
+		}
+
+		if b.archived {
+			if rollbackErr := b.rollback(b.RTC.Rollback, applied); rollbackErr != nil {
+				return fmt.Errorf("%w, rollback failed: %s", err, rollbackErr)
+			}
+		}
+		return err
+	}
+
+	return nil
+}
+
+/*PasteResults returns the per-line results of the last configuration paste */
+func (b *netironDevice) PasteResults() []router.LineResult {
+	return b.Router.PasteResults()
+}
+
+/*CommandOutputs returns the outputs of all exec commands of the connection */
+func (b *netironDevice) CommandOutputs() []router.CommandOutput {
+	return b.Router.CommandOutputs()
 }
 
 func (b *netironDevice) RunCommands(commands io.Reader) (err error) {
 	if err = b.SwitchMode("sshEnabled"); err != nil {
-		return fmt.Errorf("Cant switch to privileged mode: %s", err)
+		return fmt.Errorf("Cant switch to privileged mode: %w", err)
 	}
 
 	return b.Router.RunCommands(b.RTC, commands)
 }
 
+/*DryRun writes the command stream for exec or config mode without connecting */
+func (b *netironDevice) DryRun(execMode bool, input io.Reader) error {
+	return router.DryRunSteps(b, execMode, input)
+}
+
+/*DryRunExec writes the commands, that RunCommands would send */
+func (b *netironDevice) DryRunExec(commands io.Reader) error {
+	return b.Router.DryRunCommands(b.RTC, commands)
+}
+
+/*DryRunLogin writes the connection and the mode changes after the login */
+func (b *netironDevice) DryRunLogin() {
+	b.Router.DryRunConnect(b.RTC)
+	b.Router.DryRunMode(b.RTC, "enable (if not privileged)", "skip-page-display")
+}
+
+/*DryRunPaste writes the archive, the configuration mode and the statements */
+func (b *netironDevice) DryRunPaste(configuration io.Reader) error {
+	if b.RTC.ArchiveDir != "" || b.RTC.Rollback != "" {
+		b.Router.DryRunMode(b.RTC, "show running-config (archive)")
+	}
+	b.Router.DryRunMode(b.RTC, "conf t")
+	return b.Router.DryRunConfiguration(b.RTC, configuration)
+}
+
+/*DryRunCommit writes the memory */
+func (b *netironDevice) DryRunCommit() error {
+	b.Router.DryRunMode(b.RTC, "end", "write memory")
+	return nil
+}
+
 func (b *netironDevice) Close() {
 	b.Router.Close()
 }
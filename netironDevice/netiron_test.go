@@ -1,10 +1,14 @@
 package netironDevice_test
 
 import (
+	"bufio"
 	"bytes"
+	"errors"
 	"github.com/ipcjk/mlxsh/libhost"
 	"github.com/ipcjk/mlxsh/netironDevice"
 	"github.com/ipcjk/mlxsh/routerDevice"
+	"io"
+	"strings"
 	"testing"
 	"time"
 )
@@ -85,3 +89,266 @@ func TestDetectPrompt(t *testing.T) {
 	}
 
 }
+
+func TestDryRun(t *testing.T) {
+	var buffer = new(bytes.Buffer)
+	var Config = libhost.HostConfig{
+		DeviceType: "MLX",
+		Hostname:   "frankfurt-rt1",
+		Username:   "noc",
+	}
+
+	singleRouter := netironDevice.NetironDevice(router.RunTimeConfig{HostConfig: Config, W: buffer})
+
+	if err := singleRouter.DryRun(true, strings.NewReader("mlxsh_bgp\nshow ip cache\n")); err != nil {
+		t.Errorf("Dry-run failed: %s", err)
+	}
+
+	for _, expected := range []string{"[connect] noc@frankfurt-rt1:22", "[mode] skip-page-display", "> show ip bgp summary", "> show ip cache"} {
+		if !strings.Contains(buffer.String(), expected) {
+			t.Errorf("Dry-run output is missing %q: %s", expected, buffer.String())
+		}
+	}
+
+	buffer.Reset()
+	if err := singleRouter.DryRun(false, strings.NewReader("# comment\nvlan 123 name VLAN123\n")); err != nil {
+		t.Errorf("Dry-run failed: %s", err)
+	}
+
+	if !strings.Contains(buffer.String(), "[mode] conf t\n+ vlan 123 name VLAN123\n[mode] end\n[mode] write memory\n") {
+		t.Errorf("Wrong configuration dry-run: %s", buffer.String())
+	}
+
+	if strings.Contains(buffer.String(), "comment") {
+		t.Error("Comments should not be sent")
+	}
+}
+
+var runningConfig = `Current configuration:
+!
+ver V5.6.0fT163
+!
+hostname frankfurt-rt1
+snmp-server community public ro
+!
+interface ethernet 1/1
+ port-name uplink
+ enable
+!
+router bgp
+ local-as 65000
+ neighbor 192.0.2.1 remote-as 65001
+!
+end
+`
+
+func TestInverseStatements(t *testing.T) {
+	applied := []string{
+		"no snmp-server community public ro",
+		"vlan 120 name customer",
+		" tagged ethe 1/1",
+		"interface ethernet 1/1",
+		" port-name uplink",
+		" disable",
+		"router bgp",
+		" no neighbor 192.0.2.1 remote-as 65001",
+		" neighbor 192.0.2.2 remote-as 65002",
+	}
+
+	expected := []string{
+		"router bgp",
+		" no neighbor 192.0.2.2 remote-as 65002",
+		" neighbor 192.0.2.1 remote-as 65001",
+		"exit",
+		"interface ethernet 1/1",
+		" no disable",
+		"exit",
+		"no vlan 120 name customer",
+		"snmp-server community public ro",
+	}
+
+	inverse := netironDevice.InverseStatements(applied, runningConfig)
+	if strings.Join(inverse, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Wrong inverse statements:\n%s\nexpected:\n%s", strings.Join(inverse, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+func TestExitStanzas(t *testing.T) {
+	applied := []string{
+		"router bgp",
+		"neighbor 192.0.2.2 remote-as 65002",
+		"exit",
+		"interface ethernet 1/1",
+		"disable",
+		"exit",
+		"ip route 0.0.0.0/0 192.0.2.254",
+	}
+
+	expected := []string{
+		"no ip route 0.0.0.0/0 192.0.2.254",
+		"interface ethernet 1/1",
+		" no disable",
+		"exit",
+		"router bgp",
+		" no neighbor 192.0.2.2 remote-as 65002",
+		"exit",
+	}
+
+	inverse := netironDevice.InverseStatements(applied, runningConfig)
+	if strings.Join(inverse, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Wrong inverse statements:\n%s\nexpected:\n%s", strings.Join(inverse, "\n"), strings.Join(expected, "\n"))
+	}
+
+	expected = []string{
+		"router bgp",
+		" no neighbor 192.0.2.2 remote-as 65002",
+		" local-as 65000",
+		" neighbor 192.0.2.1 remote-as 65001",
+		"exit",
+		"interface ethernet 1/1",
+		" no disable",
+		" port-name uplink",
+		" enable",
+		"exit",
+		"no ip route 0.0.0.0/0 192.0.2.254",
+	}
+
+	restore := netironDevice.SavedSections(applied, runningConfig)
+	if strings.Join(restore, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Wrong saved sections:\n%s\nexpected:\n%s", strings.Join(restore, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+func TestSavedSections(t *testing.T) {
+	applied := []string{
+		"interface ethernet 1/1",
+		" port-name transit",
+		"no snmp-server community public ro",
+		"ip route 0.0.0.0/0 192.0.2.254",
+	}
+
+	expected := []string{
+		"interface ethernet 1/1",
+		" no port-name transit",
+		" port-name uplink",
+		" enable",
+		"exit",
+		"snmp-server community public ro",
+		"no ip route 0.0.0.0/0 192.0.2.254",
+	}
+
+	restore := netironDevice.SavedSections(applied, runningConfig)
+	if strings.Join(restore, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Wrong saved sections:\n%s\nexpected:\n%s", strings.Join(restore, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+/* netironTerminal fakes a NetIron terminal, that answers every line with the script and records it */
+func netironTerminal(t *testing.T, script func(line string) string) (io.WriteCloser, io.Reader, *[]string) {
+	deviceReader, stdin := io.Pipe()
+	stdout, deviceWriter := io.Pipe()
+	lines := new([]string)
+
+	t.Cleanup(func() {
+		stdin.Close()
+		deviceWriter.Close()
+	})
+
+	go func() {
+		scanner := bufio.NewScanner(deviceReader)
+		for scanner.Scan() {
+			*lines = append(*lines, scanner.Text())
+			io.WriteString(deviceWriter, script(scanner.Text()))
+		}
+	}()
+
+	return stdin, stdout, lines
+}
+
+/* writeTerminal fakes a NetIron terminal, that answers write memory with the result */
+func writeTerminal(t *testing.T, result string) (io.WriteCloser, io.Reader, *[]string) {
+	return netironTerminal(t, func(line string) string {
+		switch line {
+		case "end":
+			return "end\ntelnet@frankfurt-rt1#"
+		case "write memory":
+			return "write memory\n" + result + "telnet@frankfurt-rt1#"
+		}
+		return ""
+	})
+}
+
+func TestCommitConfiguration(t *testing.T) {
+	var results = []struct {
+		result string
+		failed bool
+	}{
+		{"Write startup-config in progress.\nWrite startup-config done.\n", false},
+		{"Error - flash is busy\n", true},
+	}
+
+	for _, r := range results {
+		var lines *[]string
+		singleRouter := netironDevice.NetironDevice(router.RunTimeConfig{
+			HostConfig: libhost.HostConfig{Hostname: "frankfurt-rt1"}, W: new(bytes.Buffer)})
+		singleRouter.Router.SSHStdinPipe, singleRouter.Router.SSHStdoutPipe, lines = writeTerminal(t, r.result)
+		singleRouter.Router.SSHEnabledPrompt = "telnet@frankfurt-rt1#"
+		singleRouter.Router.PromptMode = "sshConfig"
+
+		err := singleRouter.CommitConfiguration()
+		if !r.failed && err != nil {
+			t.Errorf("Commit failed with %q: %s", r.result, err)
+		}
+		if r.failed && !errors.Is(err, router.ErrCommitFailed) {
+			t.Errorf("Commit not failed with %q: %v", r.result, err)
+		}
+
+		if strings.Join(*lines, ",") != "end,write memory" {
+			t.Errorf("Expected end before write memory, sent %q", *lines)
+		}
+	}
+}
+
+func TestPasteRollbackFailure(t *testing.T) {
+	var replies = []struct {
+		reply  string
+		failed bool
+	}{
+		{"", false},
+		{"Warning: vlan 120 has no ports\n", false},
+		{"Error - vlan 120 is in use\n", true},
+	}
+
+	for _, r := range replies {
+		singleRouter := netironDevice.NetironDevice(router.RunTimeConfig{
+			HostConfig: libhost.HostConfig{Hostname: "frankfurt-rt1", Rollback: "inverse"}, W: new(bytes.Buffer)})
+		singleRouter.Router.SSHStdinPipe, singleRouter.Router.SSHStdoutPipe, _ = netironTerminal(t, func(line string) string {
+			switch line {
+			case "show running-config":
+				return line + "\n" + runningConfig + "telnet@frankfurt-rt1#"
+			case "conf t":
+				return line + "\ntelnet@frankfurt-rt1(config)#"
+			case "vlan 120 name customer":
+				return line + "\ntelnet@frankfurt-rt1(config-vlan-120)#"
+			case "no vlan 120 name customer":
+				return line + "\n" + r.reply + "telnet@frankfurt-rt1(config)#"
+			}
+			return line + "\nInvalid input -> " + line + "\ntelnet@frankfurt-rt1(config-vlan-120)#"
+		})
+		singleRouter.Router.SSHEnabledPrompt = "telnet@frankfurt-rt1#"
+		singleRouter.Router.SSHConfigPromptPre = "telnet@frankfurt-rt1(config"
+		singleRouter.Router.PromptMode = "sshEnabled"
+
+		if err := singleRouter.ConfigureTerminalMode(); err != nil {
+			t.Fatalf("Cant enter configuration mode: %s", err)
+		}
+
+		err := singleRouter.PasteConfiguration(strings.NewReader("vlan 120 name customer\nbogus\n"))
+		if !errors.Is(err, router.ErrConfigRejected) {
+			t.Errorf("Paste not rejected: %v", err)
+		}
+		if failed := err != nil && strings.Contains(err.Error(), "rollback failed"); failed != r.failed {
+			t.Errorf("Rollback failure %t with %q: %v", failed, r.reply, err)
+		}
+	}
+}
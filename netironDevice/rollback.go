@@ -0,0 +1,314 @@
+package netironDevice
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ipcjk/mlxsh/routerDevice"
+)
+
+/*
+block is a top-level statement with its sub statements, like
+an interface or router section in the running-config
+*/
+type block struct {
+	context  string
+	children []string
+}
+
+/* blockOpeners are the statements, that enter a configuration context */
+var blockOpeners = []string{"interface ", "router ", "vlan ", "lag ", "vrf ", "route-map ", "ip access-list ", "ipv6 access-list "}
+
+/* opensBlock returns true, if the statement enters a configuration context */
+func opensBlock(statement string) bool {
+	for _, opener := range blockOpeners {
+		if strings.HasPrefix(statement, opener) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+splitBlocks groups configuration lines into top-level blocks. Indented lines belong to
+the block above, a block opener like interface or router also takes the following
+unindented lines till exit, ! or end. Once a block has indented lines, the next
+unindented line starts a new block.
+*/
+func splitBlocks(lines []string) []block {
+	var blocks []block
+	var open, indented bool
+
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+
+		if trimmed == "" || trimmed == "!" || trimmed == "end" || trimmed == "exit" {
+			open = false
+			continue
+		}
+
+		if strings.HasPrefix(line, " ") && len(blocks) > 0 {
+			blocks[len(blocks)-1].children = append(blocks[len(blocks)-1].children, trimmed)
+			indented = true
+			continue
+		}
+
+		if open && !indented && !opensBlock(trimmed) {
+			blocks[len(blocks)-1].children = append(blocks[len(blocks)-1].children, trimmed)
+			continue
+		}
+
+		blocks = append(blocks, block{context: trimmed})
+		open, indented = opensBlock(trimmed), false
+	}
+
+	return blocks
+}
+
+/* runningSections maps every top-level line of the running-config to its block */
+func runningSections(running string) map[string]block {
+	sections := make(map[string]block)
+
+	for _, b := range splitBlocks(strings.Split(strings.Replace(running, "\r", "", -1), "\n")) {
+		sections[b.context] = b
+	}
+
+	return sections
+}
+
+/* negate turns a statement into its inverse, "no x" becomes "x" and the other way round */
+func negate(statement string) string {
+	if strings.HasPrefix(statement, "no ") {
+		return strings.TrimPrefix(statement, "no ")
+	}
+	return "no " + statement
+}
+
+/*
+undoStatement returns the inverse of a single statement. Statements, that
+were already configured, need no undo, a "no x" is only undone, if x was
+configured before.
+*/
+func undoStatement(statement string, existed, negatedExisted bool) (string, bool) {
+	if strings.HasPrefix(statement, "no ") {
+		return negate(statement), negatedExisted
+	}
+	return negate(statement), !existed
+}
+
+func contains(lines []string, line string) bool {
+	for _, l := range lines {
+		if l == line {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+InverseStatements returns the statements that undo the applied statements,
+newest first. Sections that did not exist in the running config are removed
+completely, in existing sections only the applied sub statements are negated.
+*/
+func InverseStatements(applied []string, running string) []string {
+	var inverse []string
+
+	sections := runningSections(running)
+	blocks := splitBlocks(applied)
+
+	for x := len(blocks) - 1; x >= 0; x-- {
+		b := blocks[x]
+		saved, exists := sections[b.context]
+
+		if len(b.children) == 0 {
+			if undo, ok := undoStatement(b.context, exists, sections[negate(b.context)].context != ""); ok {
+				inverse = append(inverse, undo)
+			}
+			continue
+		}
+
+		if !exists {
+			inverse = append(inverse, negate(b.context))
+			continue
+		}
+
+		var children []string
+		for y := len(b.children) - 1; y >= 0; y-- {
+			if undo, ok := undoStatement(b.children[y], contains(saved.children, b.children[y]),
+				contains(saved.children, negate(b.children[y]))); ok {
+				children = append(children, " "+undo)
+			}
+		}
+
+		if len(children) > 0 {
+			inverse = append(inverse, b.context)
+			inverse = append(inverse, children...)
+			inverse = append(inverse, "exit")
+		}
+	}
+
+	return inverse
+}
+
+/*
+SavedSections returns the archived running-config sections of every section,
+that was touched by the applied statements. Statements, that were added and are
+unknown to the archive, are negated like in InverseStatements.
+*/
+func SavedSections(applied []string, running string) []string {
+	var restore []string
+
+	sections := runningSections(running)
+	seen := make(map[string]bool)
+
+	for _, b := range splitBlocks(applied) {
+		saved, ok := sections[b.context]
+		if !ok {
+			if len(b.children) > 0 || !strings.HasPrefix(b.context, "no ") {
+				restore = append(restore, negate(b.context))
+				continue
+			}
+			/* "no x" removed a single statement, x is the saved one */
+			if saved, ok = sections[negate(b.context)]; !ok {
+				continue
+			}
+		}
+
+		if seen[saved.context] {
+			continue
+		}
+		seen[saved.context] = true
+
+		restore = append(restore, saved.context)
+		if len(saved.children) == 0 && len(b.children) == 0 {
+			continue
+		}
+		for _, child := range b.children {
+			if !strings.HasPrefix(child, "no ") && !contains(saved.children, child) {
+				restore = append(restore, " "+negate(child))
+			}
+		}
+		for _, child := range saved.children {
+			restore = append(restore, " "+child)
+		}
+		restore = append(restore, "exit")
+	}
+
+	return restore
+}
+
+/* archiveRunningConfig reads the running-config and saves it into the archive directory */
+func (b *netironDevice) archiveRunningConfig() error {
+	if err := b.SwitchMode("sshEnabled"); err != nil {
+		return err
+	}
+
+	if err := b.Router.Write(b.RTC, "show running-config\n"); err != nil {
+		return err
+	}
+
+	running, err := b.readTillEnabledPrompt()
+	if err != nil {
+		return fmt.Errorf("Cant read running-config: %w", err)
+	}
+
+	/* cut the echoed command and the trailing prompt */
+	if x := strings.Index(running, "\n"); x != -1 {
+		running = running[x+1:]
+	}
+	if x := strings.LastIndex(running, "\n"); x != -1 {
+		running = running[:x+1]
+	}
+	b.runningConfig = running
+
+	if b.RTC.ArchiveDir == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(b.RTC.ArchiveDir, 0700); err != nil {
+		return fmt.Errorf("Cant create archive directory: %s", err)
+	}
+
+	fileName := filepath.Join(b.RTC.ArchiveDir,
+		fmt.Sprintf("%s-%s.cfg", b.RTC.Hostname, time.Now().Format("20060102-150405")))
+
+	if err := ioutil.WriteFile(fileName, []byte(running), 0600); err != nil {
+		return fmt.Errorf("Cant archive running-config: %s", err)
+	}
+
+	fmt.Fprintf(b.RTC.W, "Archived running-config to %s\n", fileName)
+
+	return nil
+}
+
+/* rollback sends the statements for the rollback mode, errors are reported, but do not stop */
+func (b *netironDevice) rollback(mode string, applied []string) error {
+	var statements []string
+
+	switch mode {
+	case "inverse":
+		statements = InverseStatements(applied, b.runningConfig)
+	case "sections":
+		statements = SavedSections(applied, b.runningConfig)
+	default:
+		return nil
+	}
+
+	/* the classify rules of the host were checked by the paste before */
+	rules, _ := router.ParseClassifiers(b.RTC.Classify)
+
+	failed := 0
+	for _, statement := range statements {
+		if err := b.Router.Write(b.RTC, statement+"\n"); err != nil {
+			fmt.Fprintf(b.RTC.W, "Rollback aborted: %s\n", err)
+			return err
+		}
+
+		val, err := b.readTillConfigPromptSection()
+		if err != nil {
+			fmt.Fprintf(b.RTC.W, "Rollback aborted: %s\n", err)
+			return err
+		}
+
+		if severity, message := b.Router.Classify(val, rules...); severity == router.SeverityError {
+			fmt.Fprintf(b.RTC.W, "Rollback statement failed: %s: %s\n", statement, message)
+			failed++
+		}
+	}
+
+	fmt.Fprintf(b.RTC.W, "Rolled back with %d statements, %d failed\n", len(statements), failed)
+
+	if failed > 0 {
+		return fmt.Errorf("%d rollback statements failed", failed)
+	}
+	return nil
+}
+
+/*
+Rollback undoes the last pasted configuration, e.g. after failed post-checks,
+and writes the memory again. It needs the running-config from before the paste.
+*/
+func (b *netironDevice) Rollback() error {
+	if !b.archived {
+		return fmt.Errorf("Rollback needs the running-config from before the change, use an archive or rollback mode")
+	}
+
+	mode := b.RTC.Rollback
+	if mode == "" {
+		mode = "inverse"
+	}
+
+	if err := b.SwitchMode("sshConfig"); err != nil {
+		return err
+	}
+
+	if err := b.rollback(mode, router.AppliedStatements(b.Router.PasteResults())); err != nil {
+		return err
+	}
+
+	return b.CommitConfiguration()
+}
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+	"time"
+
+	"github.com/ipcjk/mlxsh/libcollate"
+	"github.com/ipcjk/mlxsh/libhost"
+	"github.com/ipcjk/mlxsh/libreport"
+	"github.com/ipcjk/mlxsh/libstream"
+)
+
+/* hostRecord is the result of a host for -output json and ndjson */
+type hostRecord struct {
+	Hostname   string            `json:"hostname"`
+	DeviceType string            `json:"device_type"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	Status     string            `json:"status"`
+	Error      string            `json:"error,omitempty"`
+	Start      *time.Time        `json:"start,omitempty"`
+	End        *time.Time        `json:"end,omitempty"`
+	Duration   float64           `json:"duration"`
+	Commands   []commandRecord   `json:"commands,omitempty"`
+	Output     string            `json:"output,omitempty"`
+}
+
+/* commandRecord is the output of a single exec command */
+type commandRecord struct {
+	Command string `json:"command"`
+	Output  string `json:"output"`
+	Error   string `json:"error,omitempty"`
+}
+
+/* hostRecords keeps the records for -output json, that is printed as one array at the end */
+var hostRecords = []hostRecord{}
+
+/* structuredOutput returns true, if results are printed as json or ndjson */
+func structuredOutput() bool {
+	return cliOutput == "json" || cliOutput == "ndjson"
+}
+
+/* notice prints progress messages, they go to stderr, when stdout is structured or parsed output */
+func notice(format string, a ...interface{}) {
+	var w = stdout()
+	if structuredOutput() || parsing() {
+		w = os.Stderr
+	}
+	fmt.Fprintf(w, format, a...)
+}
+
+/* streamOutput keeps streamed lines and results from mixing, streamWidth pads the hostnames */
+var streamOutput *libstream.Output
+var streamWidth int
+
+/* stdout returns the writer for results, with -stream its writes never split a streamed line */
+func stdout() io.Writer {
+	if streamOutput != nil {
+		return streamOutput
+	}
+	return os.Stdout
+}
+
+/* streamPrefix returns the padded hostname for streamed lines, colored by a hash of the hostname */
+func streamPrefix(hostname string) string {
+	if !outputIsTerminal || cliNoColor {
+		return fmt.Sprintf("%-*s | ", streamWidth, hostname)
+	}
+
+	hash := fnv.New32a()
+	hash.Write([]byte(hostname))
+	return fmt.Sprintf("\x1b[%dm%-*s\x1b[0m | ", 32+hash.Sum32()%5, streamWidth, hostname)
+}
+
+/*
+newHostRecord converts the result of a host. Exec commands are single entries,
+the output holds the remaining output of the host, e.g. of a configuration change.
+*/
+func newHostRecord(elems chanHost) hostRecord {
+	record := hostRecord{
+		Hostname:   elems.hostName,
+		DeviceType: elems.host.DeviceType,
+		Labels:     elems.host.Labels,
+		Status:     "ok",
+	}
+
+	switch {
+	case elems.skipped:
+		record.Status = "skipped"
+		return record
+	case elems.err != nil:
+		record.Status = "failed"
+		record.Error = elems.err.Error()
+	}
+
+	if !elems.start.IsZero() {
+		start, end := elems.start, elems.end
+		record.Start, record.End = &start, &end
+		record.Duration = end.Sub(start).Seconds()
+	}
+
+	for _, c := range elems.commands {
+		command := commandRecord{Command: c.Command, Output: c.Output}
+		if c.Err != nil {
+			command.Error = c.Err.Error()
+		}
+		record.Commands = append(record.Commands, command)
+	}
+
+	record.Output = elems.message
+
+	return record
+}
+
+/* writeRecord prints a ndjson record at once or keeps it for the json array */
+func writeRecord(record hostRecord) {
+	switch cliOutput {
+	case "ndjson":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetEscapeHTML(false)
+		encoder.Encode(record)
+	case "json":
+		hostRecords = append(hostRecords, record)
+	}
+}
+
+/* flushRecords prints the json array with all records */
+func flushRecords() {
+	if cliOutput != "json" {
+		return
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(hostRecords)
+}
+
+/* outputFileData is handed to the -outname template */
+type outputFileData struct {
+	libhost.HostConfig
+	Timestamp string
+	Status    string
+}
+
+/* indexEntry is a line of the index file in -outdir */
+type indexEntry struct {
+	Hostname string  `json:"hostname"`
+	Status   string  `json:"status"`
+	Duration float64 `json:"duration"`
+	File     string  `json:"file,omitempty"`
+	Error    string  `json:"error,omitempty"`
+}
+
+/* runStart is the timestamp of all output files of a run */
+var runStart = time.Now()
+var outNameTemplate *template.Template
+var indexEntries []indexEntry
+
+/* parseOutName parses the filename template, the default is the hostname */
+func parseOutName() (err error) {
+	if cliOutName == "" {
+		cliOutName = "{{.Hostname}}.txt"
+		if structuredOutput() {
+			cliOutName = "{{.Hostname}}.json"
+		}
+	}
+
+	if outNameTemplate, err = template.New("outname").Option("missingkey=error").Parse(cliOutName); err != nil {
+		return fmt.Errorf("Cant parse the filename template: %s", err)
+	}
+	return nil
+}
+
+/* outputFileName renders the filename of a host, it has to stay below -outdir */
+func outputFileName(host libhost.HostConfig, status string) (string, error) {
+	var name bytes.Buffer
+
+	data := outputFileData{HostConfig: host, Timestamp: runStart.Format("20060102-150405"), Status: status}
+	if err := outNameTemplate.Execute(&name, data); err != nil {
+		return "", fmt.Errorf("Cant render the filename for %s: %s", host.Hostname, err)
+	}
+
+	file := filepath.Clean(name.String())
+	if file == "." || filepath.IsAbs(file) || strings.HasPrefix(file, "..") {
+		return "", fmt.Errorf("Filename %s of %s is not below the output directory", name.String(), host.Hostname)
+	}
+
+	return file, nil
+}
+
+/*
+saveHostOutput writes the result of a host into its own file below -outdir,
+as text or as json record. Skipped hosts only get an index entry.
+*/
+func saveHostOutput(elems chanHost) {
+	record := newHostRecord(elems)
+	entry := indexEntry{Hostname: record.Hostname, Status: record.Status, Duration: record.Duration, Error: record.Error}
+	defer func() {
+		indexEntries = append(indexEntries, entry)
+	}()
+
+	if elems.skipped {
+		return
+	}
+
+	file, err := outputFileName(elems.host, record.Status)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	var content []byte
+	if structuredOutput() {
+		content, _ = json.MarshalIndent(record, "", "  ")
+	} else {
+		if record.Error != "" {
+			content = []byte("error: " + record.Error + "\n")
+		}
+		content = append(content, hostOutput(elems)...)
+	}
+
+	path := filepath.Join(cliOutDir, file)
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err == nil {
+		err = ioutil.WriteFile(path, content, 0644)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cant write the output of %s: %s\n", record.Hostname, err)
+		return
+	}
+
+	entry.File = file
+}
+
+/* writeIndex writes index.txt, or index.json with structured output, with the status of every host */
+func writeIndex() {
+	if cliOutDir == "" {
+		return
+	}
+
+	sort.Slice(indexEntries, func(i, j int) bool {
+		return indexEntries[i].Hostname < indexEntries[j].Hostname
+	})
+
+	var index bytes.Buffer
+	name := "index.txt"
+
+	if structuredOutput() {
+		name = "index.json"
+		content, _ := json.MarshalIndent(indexEntries, "", "  ")
+		index.Write(content)
+	} else {
+		w := tabwriter.NewWriter(&index, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "HOST\tSTATUS\tDURATION\tFILE\tERROR")
+		for _, e := range indexEntries {
+			fmt.Fprintf(w, "%s\t%s\t%.1fs\t%s\t%s\n", e.Hostname, e.Status, e.Duration, e.File, e.Error)
+		}
+		w.Flush()
+	}
+
+	if err := os.MkdirAll(cliOutDir, 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "Cant write the index: %s\n", err)
+		return
+	}
+	if err := ioutil.WriteFile(filepath.Join(cliOutDir, name), index.Bytes(), 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "Cant write the index: %s\n", err)
+	}
+}
+
+/* collatedHosts keeps the results for -collate, that are printed at the end of the run */
+var collatedHosts []chanHost
+var collateMasks []*regexp.Regexp
+
+/* parseCollateMask compiles -collate-mask, default selects the built-in masks */
+func parseCollateMask() error {
+	switch cliCollateMask {
+	case "":
+	case "default":
+		collateMasks = libcollate.DefaultMasks
+	default:
+		mask, err := regexp.Compile(cliCollateMask)
+		if err != nil {
+			return fmt.Errorf("Cant compile -collate-mask: %s", err)
+		}
+		collateMasks = []*regexp.Regexp{mask}
+	}
+	return nil
+}
+
+/* groupName lists the hosts of a group, long lists are shortened */
+func groupName(group libcollate.Group) string {
+	if len(group.Hosts) <= 3 {
+		return strings.Join(group.Hosts, ",")
+	}
+	return fmt.Sprintf("%s +%d", strings.Join(group.Hosts[:3], ","), len(group.Hosts)-3)
+}
+
+/*
+printCollated prints every distinct output once with its hosts, the largest
+group first. With -collate-diff the other outputs follow as diff against it.
+*/
+func printCollated() {
+	if !cliCollate || len(collatedHosts) == 0 {
+		return
+	}
+
+	outputs := make(map[string]string)
+	for _, elems := range collatedHosts {
+		output := strings.TrimLeft(hostOutput(elems), "\n")
+		if elems.err != nil {
+			output = "error: " + elems.err.Error() + "\n" + output
+		}
+		outputs[elems.hostName] = output
+	}
+
+	groups := libcollate.Collate(outputs, collateMasks)
+	separator := strings.Repeat("-", 16)
+
+	for _, group := range groups {
+		fmt.Fprintf(stdout(), "%s\n%s (%d)\n%s\n%s\n", separator, strings.Join(group.Hosts, ","),
+			len(group.Hosts), separator, strings.TrimRight(group.Output, "\n"))
+	}
+
+	if !cliCollateDiff {
+		return
+	}
+
+	for _, group := range groups[1:] {
+		fmt.Fprint(stdout(), libcollate.Diff(groups[0].Output, group.Output, groupName(groups[0]), groupName(group)))
+	}
+}
+
+/* reportTargets are the reports of -report, reportHosts keeps the results for them */
+var reportTargets []libreport.Target
+var reportHosts []chanHost
+
+/* newReportHost converts the result of a host for the reports */
+func newReportHost(elems chanHost) libreport.Host {
+	record := newHostRecord(elems)
+	host := libreport.Host{
+		Hostname:   record.Hostname,
+		DeviceType: record.DeviceType,
+		Labels:     record.Labels,
+		Status:     record.Status,
+		Error:      record.Error,
+		Start:      elems.start,
+		Duration:   elems.end.Sub(elems.start),
+		Output:     record.Output,
+	}
+
+	if elems.err != nil {
+		host.Class = errorClass(elems.err)
+	}
+
+	for _, c := range record.Commands {
+		host.Commands = append(host.Commands, libreport.Command{Command: c.Command, Output: c.Output, Error: c.Error})
+	}
+
+	for _, r := range elems.preChecks {
+		host.Checks = append(host.Checks, libreport.Check{Phase: "pre", Result: r})
+	}
+	for _, r := range elems.postChecks {
+		host.Checks = append(host.Checks, libreport.Check{Phase: "post", Result: r})
+	}
+
+	return host
+}
+
+/* writeReports writes the reports of -report with all hosts of the run */
+func writeReports() {
+	if len(reportTargets) == 0 {
+		return
+	}
+
+	name := "mlxsh"
+	if cliScriptFile != "" {
+		name += " " + cliScriptFile
+	} else if cliConfigFile != "" {
+		name += " " + cliConfigFile
+	}
+
+	run := libreport.Run{Name: name, Start: runStart, End: time.Now()}
+	for _, elems := range reportHosts {
+		run.Hosts = append(run.Hosts, newReportHost(elems))
+	}
+	sort.Slice(run.Hosts, func(i, j int) bool {
+		return run.Hosts[i].Hostname < run.Hosts[j].Hostname
+	})
+
+	for _, target := range reportTargets {
+		if err := libreport.WriteFile(target, run); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+}
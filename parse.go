@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/ipcjk/mlxsh/libparse"
+	"github.com/ipcjk/mlxsh/libtable"
+)
+
+/* parsedHosts keeps the results for -parse, their rows are printed at the end of the run */
+var parsedHosts []chanHost
+
+/* rowFilters are the filters of -format */
+var rowFilters []libtable.Filter
+
+/* parseTemplate is the template of -parse-template, it replaces the bundled templates */
+var parseTemplate *libparse.Template
+
+/* parsedTable is the parsed output of a command on a host */
+type parsedTable struct {
+	hostname string
+	command  string
+	table    libparse.Table
+}
+
+/* parsing returns true, if the output is parsed for -parse or -format */
+func parsing() bool {
+	return cliParse != "" || cliFormat != ""
+}
+
+/* loadParseTemplate reads the template of -parse-template */
+func loadParseTemplate() error {
+	file, err := os.Open(cliParseTemplate)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if parseTemplate, err = libparse.ParseTemplate(file); err != nil {
+		return fmt.Errorf("%s: %s", cliParseTemplate, err)
+	}
+	return nil
+}
+
+/* parsePlatform returns the platform of the bundled templates for a device type, like getRouter */
+func parsePlatform(deviceType string) string {
+	if _, ok := deviceProfiles[strings.ToLower(deviceType)]; ok {
+		return strings.ToLower(deviceType)
+	}
+
+	switch strings.ToLower(deviceType) {
+	case "vdx", "slx":
+		return "slx"
+	case "juniper", "junos", "mx", "ex", "j":
+		return "junos"
+	case "routeros", "mikrotik", "ros":
+		return "routeros"
+	case "linux", "frr", "vtysh", "quagga":
+		return "linux"
+	default:
+		return "netiron"
+	}
+}
+
+/*
+printParsed parses the output of every exec command with the template of the
+platform and command and prints the rows as json, csv or one table for -format.
+Failed hosts and commands without template are reported on stderr.
+*/
+func printParsed() {
+	var tables []parsedTable
+
+	if !parsing() {
+		return
+	}
+
+	sort.SliceStable(parsedHosts, func(i, j int) bool {
+		return parsedHosts[i].hostName < parsedHosts[j].hostName
+	})
+
+	for _, elems := range parsedHosts {
+		if elems.err != nil {
+			notice("err: [%-20s] %s\n", elems.hostName, elems.err)
+		}
+
+		for _, c := range elems.commands {
+			if c.Err != nil {
+				continue
+			}
+
+			template := parseTemplate
+			if template == nil {
+				template = libparse.Lookup(parsePlatform(elems.host.DeviceType), c.Command)
+			}
+			if template == nil {
+				notice("=== %s: no template for %s\n", elems.hostName, c.Command)
+				continue
+			}
+
+			table, err := template.Parse(c.Output)
+			if err != nil {
+				notice("err: [%-20s] %s: %s\n", elems.hostName, c.Command, err)
+				continue
+			}
+			tables = append(tables, parsedTable{hostname: elems.hostName, command: c.Command, table: table})
+		}
+	}
+
+	switch {
+	case cliFormat != "":
+		writeFormatted(tables)
+	case cliParse == "json":
+		writeParsedJSON(tables)
+	case cliParse == "csv":
+		writeParsedCSV(tables)
+	}
+}
+
+/* writeParsedJSON prints one array with a record per row, with the hostname and the command */
+func writeParsedJSON(tables []parsedTable) {
+	records := []map[string]interface{}{}
+
+	for _, t := range tables {
+		for _, record := range t.table.Records() {
+			record["hostname"] = t.hostname
+			record["command"] = t.command
+			records = append(records, record)
+		}
+	}
+
+	encoder := json.NewEncoder(stdout())
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(records)
+}
+
+/* writeParsedCSV prints a line per row, a header line starts every table with other columns */
+func writeParsedCSV(tables []parsedTable) {
+	var header string
+	w := csv.NewWriter(stdout())
+
+	for _, t := range tables {
+		if columns := strings.Join(t.table.Header, ","); columns != header {
+			header = columns
+			w.Write(append([]string{"hostname", "command"}, t.table.Header...))
+		}
+
+		for _, row := range t.table.Rows {
+			line := []string{t.hostname, t.command}
+			for _, cell := range row {
+				line = append(line, cellString(cell))
+			}
+			w.Write(line)
+		}
+	}
+
+	w.Flush()
+}
+
+/* cellString joins the entries of List values with spaces */
+func cellString(cell interface{}) string {
+	if list, ok := cell.([]string); ok {
+		return strings.Join(list, " ")
+	}
+	return cell.(string)
+}
+
+/*
+mergeParsed merges the rows of all hosts into one table with the host and the
+command in front, followed by the columns of all templates.
+*/
+func mergeParsed(tables []parsedTable) libtable.Table {
+	merged := libtable.Table{Columns: []string{"hostname", "command"}}
+
+	for _, t := range tables {
+		columns := append([]string{"hostname", "command"}, t.table.Header...)
+		for _, row := range t.table.Rows {
+			cells := []string{t.hostname, t.command}
+			for _, cell := range row {
+				cells = append(cells, cellString(cell))
+			}
+			merged.Append(columns, cells)
+		}
+	}
+
+	return merged
+}
+
+/*
+writeFormatted prints the merged rows of -format as aligned table or csv. The
+command column is only shown for several commands, unless -columns selects it.
+The column names are only known after parsing, an unknown name is reported on
+stderr and the table is printed without that filter, sorting or selection.
+*/
+func writeFormatted(tables []parsedTable) {
+	merged := mergeParsed(tables)
+	if len(merged.Rows) == 0 {
+		return
+	}
+
+	var columns []string
+	commands := make(map[string]bool)
+	for _, t := range tables {
+		commands[t.command] = true
+	}
+	for _, column := range merged.Columns {
+		if column != "command" || len(commands) > 1 {
+			columns = append(columns, column)
+		}
+	}
+
+	if filtered, err := merged.Filter(rowFilters); err != nil {
+		notice("err: %s, rows are not filtered\n", err)
+	} else {
+		merged = filtered
+	}
+
+	if cliSort != "" {
+		if err := merged.Sort(strings.Split(cliSort, ",")); err != nil {
+			notice("err: %s, rows are not sorted\n", err)
+		}
+	}
+
+	if cliColumns != "" {
+		var names []string
+		for _, column := range strings.Split(cliColumns, ",") {
+			names = append(names, strings.TrimSpace(column))
+		}
+		if _, err := merged.Select(names); err != nil {
+			notice("err: %s, all columns are printed\n", err)
+		} else {
+			columns = names
+		}
+	}
+
+	merged, err := merged.Select(columns)
+	if err == nil && cliFormat == "csv" {
+		err = merged.WriteCSV(stdout())
+	} else if err == nil {
+		err = merged.WriteAligned(stdout())
+	}
+	if err != nil {
+		notice("err: %s\n", err)
+	}
+}
@@ -0,0 +1,247 @@
+package profileDevice
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/ipcjk/mlxsh/routerDevice"
+)
+
+type profileDevice struct {
+	RTC     router.RunTimeConfig
+	Profile Profile
+	router.Router
+}
+
+/*
+ProfileDevice returns a new
+profileDevice object, that is driven by the given profile
+*/
+func ProfileDevice(Config router.RunTimeConfig, profile Profile) *profileDevice {
+	var errorMatches, execErrorMatches *regexp.Regexp
+	var classifiers []router.Classifier
+
+	/* Fill our config with defaults for ssh and timesouts */
+	router.GenerateDefaults(&Config)
+
+	/* classify rules are validated, when the profile is loaded, they go before the matches */
+	classifiers, _ = router.ParseClassifiers(profile.Classify)
+
+	if profile.ErrorMatches != "" {
+		errorMatches = regexp.MustCompile(profile.ErrorMatches)
+		classifiers = append(classifiers, router.Classifier{Severity: router.SeverityError, Pattern: errorMatches})
+	}
+
+	if profile.ExecErrorMatches != "" {
+		execErrorMatches = regexp.MustCompile(profile.ExecErrorMatches)
+	}
+
+	if profile.WarningMatches != "" {
+		classifiers = append(classifiers, router.Classifier{Severity: router.SeverityWarning, Pattern: regexp.MustCompile(profile.WarningMatches)})
+	}
+
+	/* answer rules are validated, when the profile is loaded */
+	answers, _ := router.ParseAnswerRules(profile.Answers)
+
+	return &profileDevice{
+		RTC:     Config,
+		Profile: profile,
+		Router: router.Router{
+			CommandRewrite:     profile.CommandRewrite,
+			PromptModes:        make(map[string]string),
+			ErrorMatches:       errorMatches,
+			ExecErrorMatches:   execErrorMatches,
+			Classifiers:        classifiers,
+			PromptDetect:       profile.PromptDetect,
+			PromptReadTriggers: profile.PromptReadTriggers,
+			PromptReplacements: profile.PromptReplacements,
+			Answers:            answers,
+		}}
+}
+
+func (b *profileDevice) Connect() (err error) {
+	if err = b.SetupSSH(b.RTC, b.Profile.RequestPty); err != nil {
+		return err
+	}
+
+	prompt, err := b.Router.ReadTill(b.RTC, b.PromptReadTriggers)
+	if err != nil {
+		return err
+	}
+
+	if err := b.DetectSetPrompt(prompt); err != nil {
+		return fmt.Errorf("detect prompt: %w", err)
+	}
+
+	if _, err = b.skipPageDisplayMode(); err != nil {
+		return err
+	}
+
+	if err = b.GetPromptMode(b.RTC); err != nil {
+		return
+	}
+
+	return
+}
+
+func (b *profileDevice) DetectSetPrompt(prompt string) error {
+	return b.DetectPrompt(b.RTC, prompt)
+}
+
+func (b *profileDevice) skipPageDisplayMode() (string, error) {
+	if b.Profile.PagerDisable == "" {
+		return "", nil
+	}
+
+	if err := b.Write(b.RTC, b.Profile.PagerDisable+"\n"); err != nil {
+		return "", err
+	}
+
+	return b.ReadTillEnabledPrompt(b.RTC)
+}
+
+func (b *profileDevice) ConfigureTerminalMode() error {
+	if err := b.Write(b.RTC, b.Profile.ConfigEnter+"\n"); err != nil {
+		return err
+	}
+
+	_, err := b.ReadTillConfigPromptSection(b.RTC)
+	if err != nil {
+		return router.Errorf(router.ErrPromptDetect, b.RTC.Hostname, "Cant find configure prompt: %w", err)
+	}
+
+	b.PromptMode = "sshConfig"
+
+	if b.RTC.Debug {
+		fmt.Fprint(b.RTC.W, "Configuration mode on")
+	}
+	return nil
+}
+
+func (b *profileDevice) SwitchMode(targetMode string) error {
+
+	if b.PromptMode == targetMode {
+		return nil
+	}
+
+	switch b.PromptMode {
+	case "sshEnabled":
+		if targetMode == "sshConfig" {
+			if err := b.ConfigureTerminalMode(); err != nil {
+				return err
+			}
+		}
+	case "sshConfig":
+		if targetMode == "sshEnabled" {
+			if err := b.Write(b.RTC, b.Profile.ConfigExit+"\n"); err != nil {
+				return err
+			}
+			if _, err := b.ReadTillEnabledPrompt(b.RTC); err != nil {
+				return router.Errorf(router.ErrPromptDetect, b.RTC.Hostname, "Cant leave configuration mode: %w", err)
+			}
+		}
+	}
+	b.PromptMode = targetMode
+
+	return nil
+}
+
+func (b *profileDevice) CommitConfiguration() (err error) {
+	if len(b.Profile.Commit) == 0 {
+		return
+	}
+
+	targetMode := "sshEnabled"
+	if b.Profile.CommitInConfigMode {
+		targetMode = "sshConfig"
+	}
+
+	if err = b.SwitchMode(targetMode); err != nil {
+		return err
+	}
+
+	for _, step := range b.Profile.Commit {
+		if step.Send != "" {
+			if err := b.Write(b.RTC, step.Send+"\n"); err != nil {
+				return err
+			}
+		}
+
+		expect := step.Expect
+		if expect == "" && targetMode == "sshConfig" {
+			expect = b.SSHConfigPromptPre
+		} else if expect == "" {
+			expect = b.SSHEnabledPrompt
+		}
+
+		val, err := b.ReadTill(b.RTC, []string{expect})
+		if err != nil {
+			return router.Errorf(router.ErrCommitFailed, b.RTC.Hostname, "Commit not completed or not successful: %w", err)
+		}
+
+		if b.ErrorMatches != nil && b.ErrorMatches.MatchString(val) {
+			return router.Errorf(router.ErrCommitFailed, b.RTC.Hostname, "Commit not successful: %s", strings.TrimSpace(val))
+		}
+	}
+
+	return
+}
+
+func (b *profileDevice) PasteConfiguration(configuration io.Reader) (err error) {
+	if err = b.SwitchMode("sshConfig"); err != nil {
+		return err
+	}
+
+	return b.Router.PasteConfiguration(b.RTC, configuration)
+}
+
+func (b *profileDevice) RunCommands(commands io.Reader) (err error) {
+	if err = b.SwitchMode("sshEnabled"); err != nil {
+		return fmt.Errorf("Cant switch to privileged mode: %w", err)
+	}
+
+	return b.Router.RunCommands(b.RTC, commands)
+}
+
+/*DryRun writes the command stream for exec or config mode without connecting */
+func (b *profileDevice) DryRun(execMode bool, input io.Reader) error {
+	return router.DryRunSteps(b, execMode, input)
+}
+
+/*DryRunExec writes the commands, that RunCommands would send */
+func (b *profileDevice) DryRunExec(commands io.Reader) error {
+	return b.DryRunCommands(b.RTC, commands)
+}
+
+/*DryRunLogin writes the connection and the pager command of the profile */
+func (b *profileDevice) DryRunLogin() {
+	b.DryRunConnect(b.RTC)
+	if b.Profile.PagerDisable != "" {
+		b.DryRunMode(b.RTC, b.Profile.PagerDisable)
+	}
+}
+
+/*DryRunPaste writes the configuration mode and the statements */
+func (b *profileDevice) DryRunPaste(configuration io.Reader) error {
+	b.DryRunMode(b.RTC, b.Profile.ConfigEnter)
+	return b.DryRunConfiguration(b.RTC, configuration)
+}
+
+/*DryRunCommit writes the commit dialog of the profile */
+func (b *profileDevice) DryRunCommit() error {
+	if len(b.Profile.Commit) > 0 && !b.Profile.CommitInConfigMode {
+		b.DryRunMode(b.RTC, b.Profile.ConfigExit)
+	}
+	for _, step := range b.Profile.Commit {
+		if step.Send != "" {
+			b.DryRunMode(b.RTC, step.Send)
+		}
+	}
+	return nil
+}
+
+func (b *profileDevice) Close() {
+	b.Router.Close()
+}
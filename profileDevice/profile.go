@@ -0,0 +1,151 @@
+package profileDevice
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/ipcjk/mlxsh/routerDevice"
+	"gopkg.in/yaml.v1"
+)
+
+/*
+Profile describes a vendor command line, that can be driven by the generic
+profile driver without writing code. It is loaded from a yaml file.
+*/
+type Profile struct {
+	Name               string              `yaml:"Name"`
+	DeviceTypes        []string            `yaml:"DeviceTypes"`
+	RequestPty         bool                `yaml:"RequestPty"`
+	PromptDetect       string              `yaml:"PromptDetect"`
+	PromptReadTriggers []string            `yaml:"PromptReadTriggers"`
+	PromptReplacements map[string][]string `yaml:"PromptReplacements"`
+	ErrorMatches       string              `yaml:"ErrorMatches"`
+	WarningMatches     string              `yaml:"WarningMatches"`
+	ExecErrorMatches   string              `yaml:"ExecErrorMatches"`
+	PagerDisable       string              `yaml:"PagerDisable"`
+	ConfigEnter        string              `yaml:"ConfigEnter"`
+	ConfigExit         string              `yaml:"ConfigExit"`
+	CommitInConfigMode bool                `yaml:"CommitInConfigMode"`
+	Commit             []DialogStep        `yaml:"Commit"`
+	CommandRewrite     map[string]string   `yaml:"CommandRewrite"`
+	Answers            []string            `yaml:"Answers"`
+	Classify           []string            `yaml:"Classify"`
+}
+
+/*
+DialogStep is a single step of a dialog, e.g. for committing. Send is written to
+the device, then the driver reads till Expect. An empty Expect waits for the prompt.
+*/
+type DialogStep struct {
+	Send   string `yaml:"Send"`
+	Expect string `yaml:"Expect"`
+}
+
+/*LoadProfile reads a single profile from a yaml reader source and validates it */
+func LoadProfile(r io.Reader) (Profile, error) {
+	var profile Profile
+
+	source, err := ioutil.ReadAll(r)
+	if err != nil {
+		return Profile{}, fmt.Errorf("Cant read from yaml source: %s", err)
+	}
+
+	if err = yaml.Unmarshal(source, &profile); err != nil {
+		return Profile{}, fmt.Errorf("Cant parse yaml source: %s", err)
+	}
+
+	if err = profile.validate(); err != nil {
+		return Profile{}, err
+	}
+
+	return profile, nil
+}
+
+/*
+LoadProfiles reads all *.yaml and *.yml files from a directory and returns a
+map from every lower case device type (and the profile name) to its profile
+*/
+func LoadProfiles(dir string) (map[string]Profile, error) {
+	var profiles = make(map[string]Profile)
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("Cant read profile directory: %s", err)
+	}
+
+	for _, f := range files {
+		if f.IsDir() || (filepath.Ext(f.Name()) != ".yaml" && filepath.Ext(f.Name()) != ".yml") {
+			continue
+		}
+
+		file, err := os.Open(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		profile, err := LoadProfile(file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", f.Name(), err)
+		}
+
+		for _, deviceType := range append(profile.DeviceTypes, profile.Name) {
+			profiles[strings.ToLower(deviceType)] = profile
+		}
+	}
+
+	return profiles, nil
+}
+
+func (p *Profile) validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("Profile has no name")
+	}
+
+	if _, err := regexp.Compile(p.PromptDetect); err != nil || p.PromptDetect == "" {
+		return fmt.Errorf("Profile %s has no valid PromptDetect: %v", p.Name, err)
+	}
+
+	if p.ErrorMatches != "" {
+		if _, err := regexp.Compile(p.ErrorMatches); err != nil {
+			return fmt.Errorf("Profile %s has no valid ErrorMatches: %s", p.Name, err)
+		}
+	}
+
+	if p.WarningMatches != "" {
+		if _, err := regexp.Compile(p.WarningMatches); err != nil {
+			return fmt.Errorf("Profile %s has no valid WarningMatches: %s", p.Name, err)
+		}
+	}
+
+	if p.ExecErrorMatches != "" {
+		if _, err := regexp.Compile(p.ExecErrorMatches); err != nil {
+			return fmt.Errorf("Profile %s has no valid ExecErrorMatches: %s", p.Name, err)
+		}
+	}
+
+	if _, err := router.ParseAnswerRules(p.Answers); err != nil {
+		return fmt.Errorf("Profile %s: %s", p.Name, err)
+	}
+
+	if _, err := router.ParseClassifiers(p.Classify); err != nil {
+		return fmt.Errorf("Profile %s: %s", p.Name, err)
+	}
+
+	if len(p.PromptReadTriggers) == 0 {
+		return fmt.Errorf("Profile %s has no PromptReadTriggers", p.Name)
+	}
+
+	for _, key := range []string{"SSHConfigPrompt", "SSHConfigPromptPre"} {
+		if len(p.PromptReplacements[key]) < 2 || len(p.PromptReplacements[key])%2 != 0 {
+			return fmt.Errorf("Profile %s needs pairs of PromptReplacements for %s", p.Name, key)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,124 @@
+package profileDevice_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/ipcjk/mlxsh/libhost"
+	"github.com/ipcjk/mlxsh/profileDevice"
+	"github.com/ipcjk/mlxsh/routerDevice"
+)
+
+var vrpProfile = `
+Name: huaweivrp
+DeviceTypes:
+  - vrp
+PromptDetect: '<[@?\.\d\w-]+> ?$'
+PromptReadTriggers:
+  - ">"
+PromptReplacements:
+  SSHConfigPrompt: ["<", "[", ">", "]"]
+  SSHConfigPromptPre: ["<", "[", ">", ""]
+ErrorMatches: '(?i)(Error:|Unrecognized command)'
+WarningMatches: '(?i)Warning:'
+Classify:
+  - 'info: Warning: The interface is already'
+PagerDisable: screen-length 0 temporary
+ConfigEnter: system-view
+ConfigExit: return
+Commit:
+  - Send: save
+    Expect: "[Y/N]"
+  - Send: "Y"
+`
+
+func TestLoadProfile(t *testing.T) {
+	profile, err := profileDevice.LoadProfile(strings.NewReader(vrpProfile))
+	if err != nil {
+		t.Fatalf("Cant load profile: %s", err)
+	}
+
+	if profile.Name != "huaweivrp" || profile.ConfigEnter != "system-view" {
+		t.Error("Profile fields not loaded")
+	}
+
+	if len(profile.Commit) != 2 || profile.Commit[0].Expect != "[Y/N]" {
+		t.Error("Commit dialog not loaded")
+	}
+
+	if _, err := profileDevice.LoadProfile(strings.NewReader("Name: broken\nPromptDetect: '(['\n")); err == nil {
+		t.Error("Loaded profile with broken prompt regex")
+	}
+
+	if _, err := profileDevice.LoadProfile(strings.NewReader(vrpProfile + "Classify:\n  - 'notice: Info:'\n")); err == nil {
+		t.Error("Loaded profile with broken classify rule")
+	}
+}
+
+func TestLoadProfiles(t *testing.T) {
+	profiles, err := profileDevice.LoadProfiles("../profiles")
+	if err != nil {
+		t.Fatalf("Cant load bundled profiles: %s", err)
+	}
+
+	for _, deviceType := range []string{"os10", "dellos10", "vrp", "huawei"} {
+		if _, ok := profiles[deviceType]; !ok {
+			t.Errorf("Profile for %s not found", deviceType)
+		}
+	}
+}
+
+func TestProfileDetectPrompt(t *testing.T) {
+	profile, err := profileDevice.LoadProfile(strings.NewReader(vrpProfile))
+	if err != nil {
+		t.Fatalf("Cant load profile: %s", err)
+	}
+
+	var Config = libhost.HostConfig{
+		DeviceType: "vrp",
+		Hostname:   "localhost",
+		Username:   "user",
+		Password:   "password",
+		SSHPort:    9131,
+	}
+
+	singleRouter := profileDevice.ProfileDevice(router.RunTimeConfig{HostConfig: Config, Debug: true, W: new(bytes.Buffer)}, profile)
+
+	if err := singleRouter.DetectSetPrompt("Info: The max number of VTY users is 10.\r\n<HUAWEI>"); err != nil {
+		t.Errorf("Cant detect prompt! :%s", err)
+	}
+
+	if singleRouter.SSHConfigPrompt != "[HUAWEI]" || singleRouter.SSHConfigPromptPre != "[HUAWEI" {
+		t.Errorf("Wrong configuration prompts: %s %s", singleRouter.SSHConfigPrompt, singleRouter.SSHConfigPromptPre)
+	}
+
+	if err := singleRouter.Connect(); err == nil {
+		t.Error("Logged into localhost with default settings, this cant be true!")
+	}
+}
+
+func TestProfileClassify(t *testing.T) {
+	profile, err := profileDevice.LoadProfile(strings.NewReader(vrpProfile))
+	if err != nil {
+		t.Fatalf("Cant load profile: %s", err)
+	}
+
+	singleRouter := profileDevice.ProfileDevice(router.RunTimeConfig{HostConfig: libhost.HostConfig{DeviceType: "vrp"}, W: new(bytes.Buffer)}, profile)
+
+	var classifications = []struct {
+		output   string
+		severity string
+	}{
+		{"Warning: The interface is already up.\n[HUAWEI]", router.SeverityInfo},
+		{"Warning: The VLAN is not created.\n[HUAWEI]", router.SeverityWarning},
+		{"Error: Unrecognized command found at '^' position.\n[HUAWEI]", router.SeverityError},
+		{"[HUAWEI]", ""},
+	}
+
+	for _, c := range classifications {
+		if severity, _ := singleRouter.Classify(c.output); severity != c.severity {
+			t.Errorf("Output %q classified as %q, want %q", c.output, severity, c.severity)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package router
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+/*AnswerDirective declares an answer rule inside a script, e.g. "mlxsh_answer reload => \(y/n\) -> y" */
+const AnswerDirective = "mlxsh_answer"
+
+/* maxAnswers stops commands, that ask the same question again and again */
+const maxAnswers = 8
+
+/*DefaultQuestionMatches detects questions, that are used, if the router has no own QuestionMatches */
+var DefaultQuestionMatches = regexp.MustCompile(`(?i)(\(y/n\)|\[y/n\]|\(yes/no\)|\[yes/no\]|\[confirm\]|\(y/n/q\)|\[y/n/q\])\W*$`)
+
+/*
+AnswerRule writes Response, when the last output line of a command matches
+Prompt. With Command the rule only answers for matching commands.
+*/
+type AnswerRule struct {
+	Command  *regexp.Regexp
+	Prompt   *regexp.Regexp
+	Response string
+}
+
+/*
+ParseAnswerRule parses "prompt regex -> response" or, for a single command,
+"command regex => prompt regex -> response". An empty response sends a newline.
+*/
+func ParseAnswerRule(rule string) (AnswerRule, error) {
+	var a AnswerRule
+	var err error
+
+	arrow := strings.LastIndex(rule, "->")
+	if arrow < 0 {
+		return a, fmt.Errorf("Answer rule %q needs the form 'prompt -> response'", rule)
+	}
+
+	prompt := strings.TrimSpace(rule[:arrow])
+	a.Response = strings.TrimSpace(rule[arrow+2:])
+
+	if parts := strings.SplitN(prompt, "=>", 2); len(parts) == 2 {
+		if a.Command, err = regexp.Compile(strings.TrimSpace(parts[0])); err != nil {
+			return a, fmt.Errorf("Answer rule %q has no valid command: %s", rule, err)
+		}
+		prompt = strings.TrimSpace(parts[1])
+	}
+
+	if prompt == "" {
+		return a, fmt.Errorf("Answer rule %q has no prompt", rule)
+	}
+
+	if a.Prompt, err = regexp.Compile(prompt); err != nil {
+		return a, fmt.Errorf("Answer rule %q has no valid prompt: %s", rule, err)
+	}
+
+	return a, nil
+}
+
+/*ParseAnswerRules parses a list of answer rules */
+func ParseAnswerRules(rules []string) ([]AnswerRule, error) {
+	var answers []AnswerRule
+
+	for _, rule := range rules {
+		a, err := ParseAnswerRule(rule)
+		if err != nil {
+			return nil, err
+		}
+		answers = append(answers, a)
+	}
+
+	return answers, nil
+}
+
+/* matches returns true, if the rule answers the question of the command */
+func (a AnswerRule) matches(command, question string) bool {
+	if a.Command != nil && !a.Command.MatchString(command) {
+		return false
+	}
+	return a.Prompt.MatchString(question)
+}
+
+/* lastLine returns the line, that the device is waiting on */
+func lastLine(output string) string {
+	output = strings.TrimRight(output, " \r")
+	return strings.TrimSpace(output[strings.LastIndex(output, "\n")+1:])
+}
+
+/*AnswerRules returns the answer rules of the router module and the host */
+func (ro *Router) AnswerRules(rtc RunTimeConfig) ([]AnswerRule, error) {
+	rules, err := ParseAnswerRules(rtc.Answers)
+	if err != nil {
+		return nil, err
+	}
+	return append(rules, ro.Answers...), nil
+}
+
+/*
+RunCommand writes a single command and reads till the enabled prompt. Questions
+of the device are answered with the first matching rule. A question without
+rule is an error, the device still waits for an answer then.
+*/
+func (ro *Router) RunCommand(rtc RunTimeConfig, command string, rules []AnswerRule) (string, error) {
+	if err := ro.Write(rtc, command+"\n"); err != nil {
+		return "", err
+	}
+
+	return ro.ReadTillAnswered(rtc, command, rules)
+}
+
+/*ReadTillAnswered reads till the enabled prompt and answers questions of the command */
+func (ro *Router) ReadTillAnswered(rtc RunTimeConfig, command string, rules []AnswerRule) (string, error) {
+	var output string
+
+	questions := ro.QuestionMatches
+	if questions == nil {
+		questions = DefaultQuestionMatches
+	}
+
+	for answers := 0; ; answers++ {
+		var rule *AnswerRule
+		var question bool
+
+		val, err := ro.ReadTillFunc(rtc, ro.SSHEnabledPrompt, func(buffer string) bool {
+			last := lastLine(buffer)
+			for x := range rules {
+				if rules[x].matches(command, last) {
+					rule = &rules[x]
+					return true
+				}
+			}
+			if ro.atEnabledPrompt(buffer) {
+				return true
+			}
+			question = questions.MatchString(last)
+			return question
+		})
+		output += val
+		if err != nil {
+			return output, err
+		}
+
+		switch {
+		case rule != nil && answers == maxAnswers:
+			return output, fmt.Errorf("Command %s asked more than %d questions", command, maxAnswers)
+		case rule != nil:
+			if err := ro.Write(rtc, rule.Response+"\n"); err != nil {
+				return output, err
+			}
+		case question:
+			return output, fmt.Errorf("Command %s asks %q, but there is no answer rule", command, lastLine(val))
+		default:
+			return output, nil
+		}
+	}
+}
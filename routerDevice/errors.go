@@ -0,0 +1,87 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+/* Kinds of errors, that the router modules return, check them with errors.Is */
+var (
+	ErrDial           = errors.New("cant connect")
+	ErrAuth           = errors.New("authentication failed")
+	ErrHostKey        = errors.New("host key not accepted")
+	ErrPromptDetect   = errors.New("cant detect prompt")
+	ErrTimeout        = errors.New("timeout")
+	ErrConfigRejected = errors.New("configuration rejected")
+	ErrCommitFailed   = errors.New("commit failed")
+	ErrCommandFailed  = errors.New("command failed")
+)
+
+/*
+Error is an error of a host. errors.Is matches its Kind and its cause, so a
+prompt, that is not found after a timeout, is ErrPromptDetect and ErrTimeout.
+*/
+type Error struct {
+	Host string
+	Kind error
+	Err  error
+}
+
+func (e *Error) Error() string {
+	var cause *Error
+
+	switch {
+	case e.Err == nil:
+		return fmt.Sprintf("%s: %s", e.Host, e.Kind)
+	case errors.As(e.Err, &cause):
+		/* the cause names the host already */
+		return e.Err.Error()
+	default:
+		return fmt.Sprintf("%s: %s", e.Host, e.Err)
+	}
+}
+
+/*Unwrap returns the kind and the cause of the error */
+func (e *Error) Unwrap() []error {
+	if e.Err == nil {
+		return []error{e.Kind}
+	}
+	return []error{e.Kind, e.Err}
+}
+
+/*Errorf returns an error of kind for the host, %w keeps the cause for errors.Is */
+func Errorf(kind error, host, format string, a ...interface{}) error {
+	return &Error{Host: host, Kind: kind, Err: fmt.Errorf(format, a...)}
+}
+
+/*Kind returns the kind of the outermost Error, nil for other errors */
+func Kind(err error) error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+	return nil
+}
+
+/*
+dialError sorts an error of the ssh handshake of a host into ErrAuth, ErrHostKey
+or ErrDial. x/crypto/ssh formats handshake errors with %v, so its messages are
+only matched as fallback, if the error does not carry a kind already.
+*/
+func dialError(host string, err error) error {
+	message := err.Error()
+
+	switch {
+	case errors.Is(err, ErrHostKey):
+		return Errorf(ErrHostKey, host, "Cant verify the host key: %w", err)
+	case errors.Is(err, ErrAuth):
+		return Errorf(ErrAuth, host, "Cant login: %w", err)
+	case strings.Contains(message, "host key"):
+		return Errorf(ErrHostKey, host, "Cant verify the host key: %w", err)
+	case strings.Contains(message, "unable to authenticate"):
+		return Errorf(ErrAuth, host, "Cant login: %w", err)
+	default:
+		return Errorf(ErrDial, host, "%w", err)
+	}
+}
@@ -0,0 +1,93 @@
+package router
+
+import (
+	"bytes"
+	"io"
+	"strings"
+)
+
+/*CommandOutput is the output of a single exec command, Err is set if the command failed */
+type CommandOutput struct {
+	Command string
+	Output  string
+	Err     error
+}
+
+/*RecordOutput keeps the output of an exec command for CommandOutputs, without echo and prompt */
+func (ro *Router) RecordOutput(command, output string, err error) {
+	ro.Outputs = append(ro.Outputs, CommandOutput{Command: command, Output: ro.CleanOutput(command, output), Err: err})
+}
+
+/*CommandOutputs returns the outputs of all exec commands of the connection */
+func (ro *Router) CommandOutputs() []CommandOutput {
+	return ro.Outputs
+}
+
+/*Commander is a router module, that runs exec commands */
+type Commander interface {
+	RunCommands(io.Reader) error
+}
+
+/*
+RunCommand runs a single exec command for a check and returns its output. The
+raw output with echo and prompt is cut from the buffer of the module, the
+recorded output is returned, if the module keeps its CommandOutputs.
+*/
+func RunCommand(c Commander, buffer *bytes.Buffer, command string) (string, error) {
+	var recorded int
+	outputer, ok := c.(interface{ CommandOutputs() []CommandOutput })
+	if ok {
+		recorded = len(outputer.CommandOutputs())
+	}
+
+	offset := buffer.Len()
+	err := c.RunCommands(strings.NewReader(command + "\n"))
+	output := buffer.String()[offset:]
+	buffer.Truncate(offset)
+
+	if ok && len(outputer.CommandOutputs()) > recorded {
+		var outputs []string
+		for _, o := range outputer.CommandOutputs()[recorded:] {
+			outputs = append(outputs, o.Output)
+		}
+		output = strings.Join(outputs, "\n")
+	}
+
+	return output, err
+}
+
+/* isEnabledPrompt returns true, if the line is the enabled prompt */
+func (ro *Router) isEnabledPrompt(line string) bool {
+	if ro.PromptMatches != nil {
+		return ro.PromptMatches.MatchString(line)
+	}
+	return line == ro.SSHEnabledPrompt
+}
+
+/*
+CleanOutput removes carriage returns, the echoed command, maybe behind the
+prompt, and the prompt after the output of a command.
+*/
+func (ro *Router) CleanOutput(command, output string) string {
+	lines := strings.Split(strings.Replace(output, "\r", "", -1), "\n")
+
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+
+	if len(lines) > 0 && command != "" {
+		echo := strings.TrimSpace(lines[0])
+		if ro.SSHEnabledPrompt != "" {
+			echo = strings.TrimSpace(strings.TrimPrefix(echo, ro.SSHEnabledPrompt))
+		}
+		if echo == command {
+			lines = lines[1:]
+		}
+	}
+
+	if n := len(lines); n > 0 && ro.SSHEnabledPrompt != "" && ro.isEnabledPrompt(strings.TrimSpace(lines[n-1])) {
+		lines = lines[:n-1]
+	}
+
+	return strings.TrimRight(strings.Join(lines, "\n"), " \n")
+}
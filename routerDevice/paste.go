@@ -0,0 +1,224 @@
+package router
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+/* Severities for the output of a configuration statement */
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+	SeverityInfo    = "info"
+)
+
+/* Policies that decide, when a configuration paste stops */
+const (
+	PolicyAbort              = "abort"
+	PolicyContinue           = "continue"
+	PolicyAbortIgnoreWarning = "abort-on-error-ignore-warning"
+)
+
+/*Classifier maps output, that matches Pattern, to a severity */
+type Classifier struct {
+	Severity string
+	Pattern  *regexp.Regexp
+}
+
+/*LineResult is the outcome of a single configuration statement */
+type LineResult struct {
+	Line      int
+	Statement string
+	Severity  string
+	Message   string
+	Applied   bool
+}
+
+var severityRank = map[string]int{"": 0, SeverityInfo: 1, SeverityWarning: 2, SeverityError: 3}
+
+/*
+ParseClassifier parses a classification rule "severity: regex", e.g.
+"info: ^Info:" or "warning: already exists". The severity is error, warning or info.
+*/
+func ParseClassifier(rule string) (Classifier, error) {
+	var c Classifier
+	var err error
+
+	colon := strings.Index(rule, ":")
+	if colon < 0 {
+		return c, fmt.Errorf("Classify rule %q needs the form 'severity: pattern'", rule)
+	}
+
+	c.Severity = strings.ToLower(strings.TrimSpace(rule[:colon]))
+	if severityRank[c.Severity] == 0 {
+		return c, fmt.Errorf("Classify rule %q has no valid severity, use %s, %s or %s", rule, SeverityError, SeverityWarning, SeverityInfo)
+	}
+
+	pattern := strings.TrimSpace(rule[colon+1:])
+	if pattern == "" {
+		return c, fmt.Errorf("Classify rule %q has no pattern", rule)
+	}
+
+	if c.Pattern, err = regexp.Compile(pattern); err != nil {
+		return c, fmt.Errorf("Classify rule %q has no valid pattern: %s", rule, err)
+	}
+
+	return c, nil
+}
+
+/*ParseClassifiers parses a list of classification rules */
+func ParseClassifiers(rules []string) ([]Classifier, error) {
+	var classifiers []Classifier
+
+	for _, rule := range rules {
+		c, err := ParseClassifier(rule)
+		if err != nil {
+			return nil, err
+		}
+		classifiers = append(classifiers, c)
+	}
+
+	return classifiers, nil
+}
+
+/*
+Classify returns the highest severity and the matching output line for the
+output of a statement. The first matching rule decides the severity of a line,
+the rules are checked before the Classifiers of the router. Without Classifiers
+every ErrorMatches hit is an error.
+*/
+func (ro *Router) Classify(output string, rules ...Classifier) (severity, message string) {
+	classifiers := ro.Classifiers
+	if len(classifiers) == 0 && ro.ErrorMatches != nil {
+		classifiers = []Classifier{{Severity: SeverityError, Pattern: ro.ErrorMatches}}
+	}
+	classifiers = append(rules[:len(rules):len(rules)], classifiers...)
+
+	for _, line := range strings.Split(strings.Replace(output, "\r", "", -1), "\n") {
+		for _, c := range classifiers {
+			if !c.Pattern.MatchString(line) {
+				continue
+			}
+			if severityRank[c.Severity] > severityRank[severity] {
+				severity, message = c.Severity, strings.TrimSpace(line)
+			}
+			break
+		}
+	}
+
+	return
+}
+
+/* stops returns true, if the policy aborts the paste on this severity */
+func stops(policy, severity string) bool {
+	switch policy {
+	case PolicyContinue:
+		return false
+	case PolicyAbortIgnoreWarning:
+		return severity == SeverityError
+	}
+	return severity == SeverityError || severity == SeverityWarning
+}
+
+/*CheckPolicy returns an error for an unknown error policy */
+func CheckPolicy(policy string) error {
+	switch policy {
+	case "", PolicyAbort, PolicyContinue, PolicyAbortIgnoreWarning:
+		return nil
+	}
+	return fmt.Errorf("Unknown error policy %s, use %s, %s or %s", policy, PolicyAbort, PolicyContinue, PolicyAbortIgnoreWarning)
+}
+
+/*PasteResults returns the per-line results of the last configuration paste */
+func (ro *Router) PasteResults() []LineResult {
+	return ro.Results
+}
+
+/*
+PasteConfigurationResults sends the configuration line-by-line and classifies
+the output of every statement. The ErrorPolicy of the host decides, if the paste
+stops on an error or warning, the results are kept for PasteResults.
+*/
+func (ro *Router) PasteConfigurationResults(rtc RunTimeConfig, configuration io.Reader) ([]LineResult, error) {
+	var line, failed int
+
+	ro.Results = nil
+
+	if err := CheckPolicy(rtc.ErrorPolicy); err != nil {
+		return nil, err
+	}
+
+	rules, err := ParseClassifiers(rtc.Classify)
+	if err != nil {
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(configuration)
+	for scanner.Scan() {
+		line++
+		if strings.HasPrefix(scanner.Text(), "#") {
+			continue
+		}
+
+		if err := ro.Write(rtc, scanner.Text()+"\n"); err != nil {
+			return ro.Results, err
+		}
+
+		result := LineResult{Line: line, Statement: scanner.Text(), Applied: true}
+
+		/* Wait till config prompt returns or not ? */
+		if !rtc.SpeedMode {
+			val, err := ro.ReadTillConfigPromptSection(rtc)
+			if err != nil {
+				return ro.Results, err
+			}
+			if rtc.Debug {
+				fmt.Fprintf(rtc.W, "Captured %s\n", val)
+			}
+			result.Severity, result.Message = ro.Classify(val, rules...)
+			result.Applied = result.Severity != SeverityError
+		}
+		ro.Results = append(ro.Results, result)
+
+		if result.Severity == SeverityError {
+			failed++
+		}
+
+		if stops(rtc.ErrorPolicy, result.Severity) {
+			return ro.Results, Errorf(ErrConfigRejected, rtc.Hostname, "Invalid configuration statement: %s ", scanner.Text())
+		}
+		/* a stream prints whole lines only, so it gets a line per statement */
+		if rtc.Stream != nil {
+			fmt.Fprintf(rtc.W, "+ %s\n", scanner.Text())
+		} else {
+			fmt.Fprint(rtc.W, "+")
+		}
+	}
+	if rtc.Stream == nil {
+		fmt.Fprint(rtc.W, "\n")
+	}
+
+	if err := scanner.Err(); err != nil {
+		return ro.Results, err
+	}
+
+	if failed > 0 {
+		return ro.Results, Errorf(ErrConfigRejected, rtc.Hostname, "%d configuration statements failed", failed)
+	}
+
+	return ro.Results, nil
+}
+
+/*AppliedStatements returns the statements of all results, that were accepted by the device */
+func AppliedStatements(results []LineResult) []string {
+	var applied []string
+	for _, r := range results {
+		if r.Applied {
+			applied = append(applied, r.Statement)
+		}
+	}
+	return applied
+}
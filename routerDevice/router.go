@@ -8,6 +8,7 @@ import (
 	"os"
 	"regexp"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/ipcjk/mlxsh/libhost"
@@ -21,6 +22,8 @@ type RunTimeConfig struct {
 	libhost.HostConfig
 	Debug           bool
 	W               io.Writer
+	/* Stream gets the device output as it is read, e.g. for -stream, streamed command output is not written to W again */
+	Stream          io.Writer
 	ConnectionAddr  string
 	SSHClientConfig *ssh.ClientConfig
 	Hostkey         ssh.PublicKey
@@ -42,8 +45,24 @@ type Router struct {
 	SSHConfigPrompt, SSHEnabledPrompt, SSHUnprivilegedPrompt string
 	SSHConfigPromptPre                                       string
 
+	/* PromptMatches finds the enabled prompt at the end of the output, if it changes, e.g. with the menu path of RouterOS */
+	PromptMatches *regexp.Regexp
+
 	/* ErrorMatches is a regex to scan for error messages in the configuration terminal */
 	ErrorMatches *regexp.Regexp
+	/* ExecErrorMatches is a regex to scan for error messages of commands in exec mode */
+	ExecErrorMatches *regexp.Regexp
+	/* Classifiers sort configuration output into errors, warnings and infos, the first match decides a line, ErrorMatches is used if empty */
+	Classifiers []Classifier
+	/* Results of the last configuration paste */
+	Results []LineResult
+	/* Outputs of all exec commands of the connection */
+	Outputs []CommandOutput
+
+	/* Answers are built-in answer rules for questions of exec commands */
+	Answers []AnswerRule
+	/* QuestionMatches detects unanswered questions on the last output line, DefaultQuestionMatches is used if empty */
+	QuestionMatches *regexp.Regexp
 
 	/* Command-Rewriter for general commands, e.g. 'sc:show_log => show logging' */
 	CommandRewrite map[string]string
@@ -60,12 +79,11 @@ type Router struct {
 /* All kind of "generic" routines, than can be used directly or indirectly by our routers */
 
 /*SetupSSH will create a tcp
-connection and open a remote shell, optional a full
+connection to the address of the runtime config and open a remote shell, optional a full
 pseudo terminal */
-func (ro *Router) SetupSSH(addr string, clientConfig *ssh.ClientConfig, requestPty bool) (err error) {
+func (ro *Router) SetupSSH(rtc RunTimeConfig, requestPty bool) (err error) {
 
-	ro.SSHConnection, err = ssh.Dial("tcp", addr, clientConfig)
-	if err != nil {
+	if err = ro.DialSSH(rtc); err != nil {
 		return err
 	}
 
@@ -98,25 +116,49 @@ func (ro *Router) SetupSSH(addr string, clientConfig *ssh.ClientConfig, requestP
 
 		/* We request a "dumb"-terminal, so we got rid of any control characters and colors */
 		if err := ro.SSHSession.RequestPty("dumb", 80, 40, modes); err != nil {
-			return fmt.Errorf("request for pseudo terminal failed: %s", err)
+			return Errorf(ErrDial, rtc.Hostname, "request for pseudo terminal failed: %w", err)
 		}
 	}
 
 	/* Request a shell */
 	err = ro.SSHSession.Shell()
 	if err != nil {
-		return fmt.Errorf("request for shell failed: %s", err)
+		return Errorf(ErrDial, rtc.Hostname, "request for shell failed: %w", err)
 	}
 
 	return
 }
 
+/*DialSSH will only create the tcp connection without opening a shell, sessions
+can be opened later by the caller, e.g. one exec channel per command */
+func (ro *Router) DialSSH(rtc RunTimeConfig) (err error) {
+	if ro.SSHConnection, err = ssh.Dial("tcp", rtc.ConnectionAddr, rtc.SSHClientConfig); err != nil {
+		return dialError(rtc.Hostname, err)
+	}
+	return
+}
+
 /*ReadTill is our main function for reading data from the input SSH-channel and will
 read from the input reader, till it finds a given string, else it will run into timeout
 and close the SSH channel and session
 */
 func (ro *Router) ReadTill(rtc RunTimeConfig, search []string) (string, error) {
+	return ro.ReadTillFunc(rtc, search[0], func(lineBuf string) bool {
+		for x := range search {
+			if strings.Contains(lineBuf, search[x]) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+/*ReadTillFunc works like ReadTill, but reads till found returns true for the
+read data, description names the awaited string in debug output and timeouts
+*/
+func (ro *Router) ReadTillFunc(rtc RunTimeConfig, description string, found func(string) bool) (string, error) {
 	var lineBuf string
+	var timedOut int32
 	shortBuf := make([]byte, 512)
 	foundToken := make(chan struct{}, 0)
 	defer close(foundToken)
@@ -129,8 +171,9 @@ WaitInput:
 			case <-(time.After(rtc.ReadTimeout)):
 				if rtc.Debug {
 					fmt.Fprint(rtc.W, "Timed out waiting for incoming buffer")
-					fmt.Fprintf(rtc.W, "Waited for %s %d", search[0], len(search[0]))
+					fmt.Fprintf(rtc.W, "Waited for %s %d", description, len(description))
 				}
+				atomic.StoreInt32(&timedOut, 1)
 				ro.Close()
 			case <-foundToken:
 				return
@@ -139,18 +182,21 @@ WaitInput:
 		var err error
 		var n int
 		if n, err = io.ReadAtLeast(ro.SSHStdoutPipe, shortBuf, 1); err != nil {
-			if err != io.EOF {
+			if atomic.LoadInt32(&timedOut) == 1 {
+				return "", Errorf(ErrTimeout, rtc.Hostname, "Timed out after %s waiting for %q", rtc.ReadTimeout, strings.TrimSpace(description))
+			} else if err != io.EOF {
 				return "", err
 			} else if err == io.EOF {
 				return "", err
 			}
 		}
 		foundToken <- struct{}{}
+		if rtc.Stream != nil {
+			rtc.Stream.Write(shortBuf[:n])
+		}
 		lineBuf += string(shortBuf[:n])
-		for x := range search {
-			if strings.Contains(lineBuf, search[x]) {
-				break WaitInput
-			}
+		if found(lineBuf) {
+			break WaitInput
 		}
 	}
 	return string(lineBuf), nil
@@ -209,7 +255,15 @@ func GenerateDefaults(config *RunTimeConfig) {
 
 /*ReadTillEnabledPrompt internal calls ReadTill, looking for the SSH enabled prompt string */
 func (ro *Router) ReadTillEnabledPrompt(rtc RunTimeConfig) (string, error) {
-	return ro.ReadTill(rtc, []string{ro.SSHEnabledPrompt})
+	return ro.ReadTillFunc(rtc, ro.SSHEnabledPrompt, ro.atEnabledPrompt)
+}
+
+/* atEnabledPrompt returns true, if the output contains the enabled prompt or PromptMatches matches it */
+func (ro *Router) atEnabledPrompt(output string) bool {
+	if ro.PromptMatches != nil {
+		return ro.PromptMatches.MatchString(output)
+	}
+	return strings.Contains(output, ro.SSHEnabledPrompt)
 }
 
 /*ReadTillConfigPrompt internal calls ReadTill, looking for the SSH configuration prompt string */
@@ -230,7 +284,7 @@ the SSH input stream
 func (ro *Router) Write(rtc RunTimeConfig, command string) error {
 	_, err := ro.SSHStdinPipe.Write([]byte(command))
 	if err != nil {
-		return fmt.Errorf("Cant write to the ssh connection %s", err)
+		return fmt.Errorf("Cant write to the ssh connection %w", err)
 	}
 
 	if rtc.Debug {
@@ -244,33 +298,7 @@ func (ro *Router) Write(rtc RunTimeConfig, command string) error {
 reader line-by-line and inject configuration statements
 */
 func (ro *Router) PasteConfiguration(rtc RunTimeConfig, configuration io.Reader) (err error) {
-	scanner := bufio.NewScanner(configuration)
-	for scanner.Scan() {
-		if strings.HasPrefix(scanner.Text(), "#") {
-			continue
-		}
-
-		if err := ro.Write(rtc, scanner.Text()+"\n"); err != nil {
-			return err
-		}
-
-		/* Wait till config prompt returns or not ? */
-		if !rtc.SpeedMode {
-			val, err := ro.ReadTillConfigPromptSection(rtc)
-			if err != nil {
-				return err
-			}
-			if rtc.Debug {
-				fmt.Fprintf(rtc.W, "Captured %s\n", val)
-			}
-			if ro.ErrorMatches != nil && ro.ErrorMatches.MatchString(val) {
-				return fmt.Errorf("Invalid configuration statement: %s ", scanner.Text())
-			}
-		}
-		fmt.Fprint(rtc.W, "+")
-	}
-	fmt.Fprint(rtc.W, "\n")
-
+	_, err = ro.PasteConfigurationResults(rtc, configuration)
 	return
 }
 
@@ -283,7 +311,7 @@ func (ro *Router) GetPromptMode(rtc RunTimeConfig) error {
 
 	mode, err := ro.ReadTill(rtc, []string{ro.SSHConfigPrompt, ro.SSHEnabledPrompt})
 	if err != nil {
-		return fmt.Errorf("Cant find command line mode: %s", err)
+		return Errorf(ErrPromptDetect, rtc.Hostname, "Cant find command line mode: %w", err)
 	}
 
 	mode = strings.TrimSpace(mode)
@@ -301,34 +329,140 @@ func (ro *Router) GetPromptMode(rtc RunTimeConfig) error {
 }
 
 /*RunCommands will run commands inside the devices exec- or privileged mode.
-Command will be read from a io.reader. */
+Command will be read from a io.reader. Questions of the device are answered by
+the answer rules of the router, the host and mlxsh_answer lines in the commands. */
 func (ro *Router) RunCommands(rtc RunTimeConfig, commands io.Reader) (err error) {
+	var failed []string
+
+	rules, err := ro.AnswerRules(rtc)
+	if err != nil {
+		return err
+	}
 
 	scanner := bufio.NewScanner(commands)
 	for scanner.Scan() {
 		line := strings.TrimSpace(scanner.Text())
 
-		/* Does the command start with mlxsh_? Then guess it is a command with replacement characters?*/
-		if strings.HasPrefix(line, "mlxsh_") {
-			/* stupid, but works, loop all rewrites and replace all patterns */
-			for k, v := range ro.CommandRewrite {
-				line = strings.ReplaceAll(line, k, v)
+		/* answer rules in the commands apply to all following commands */
+		if strings.HasPrefix(line, AnswerDirective) {
+			rule, err := ParseAnswerRule(strings.TrimPrefix(line, AnswerDirective))
+			if err != nil {
+				return err
 			}
+			rules = append([]AnswerRule{rule}, rules...)
+			continue
 		}
 
-		if err := ro.Write(rtc, line+"\n"); err != nil {
-			return err
+		line = ro.RewriteCommand(line)
+
+		val, err := ro.RunCommand(rtc, line, rules)
+		if rtc.Stream == nil {
+			fmt.Fprintf(rtc.W, "%s\n", val)
 		}
-		val, err := ro.ReadTillEnabledPrompt(rtc)
 		if err != nil && err != io.EOF {
+			ro.RecordOutput(line, val, err)
 			return err
 		}
-		fmt.Fprintf(rtc.W, "%s\n", val)
+
+		if ro.ExecErrorMatches != nil && ro.ExecErrorMatches.MatchString(val) {
+			err = Errorf(ErrCommandFailed, rtc.Hostname, "Command failed: %s", line)
+			ro.RecordOutput(line, val, err)
+			if rtc.ErrorPolicy != PolicyContinue {
+				return err
+			}
+			failed = append(failed, line)
+			continue
+		}
+
+		ro.RecordOutput(line, val, nil)
+	}
+
+	if len(failed) > 0 {
+		return Errorf(ErrCommandFailed, rtc.Hostname, "%d commands failed: %s", len(failed), strings.Join(failed, ", "))
 	}
 
 	return err
 }
 
+/*RewriteCommand replaces the mlxsh_ macros of a command line with the devices commands */
+func (ro *Router) RewriteCommand(line string) string {
+	/* Does the command start with mlxsh_? Then guess it is a command with replacement characters?*/
+	if strings.HasPrefix(line, "mlxsh_") {
+		/* stupid, but works, loop all rewrites and replace all patterns */
+		for k, v := range ro.CommandRewrite {
+			line = strings.ReplaceAll(line, k, v)
+		}
+	}
+	return line
+}
+
+/*DryRunMode writes mode transitions or dialog steps for a dry-run, nothing is sent */
+func (ro *Router) DryRunMode(rtc RunTimeConfig, commands ...string) {
+	for _, command := range commands {
+		fmt.Fprintf(rtc.W, "[mode] %s\n", command)
+	}
+}
+
+/*DryRunConnect writes the connection, that Connect would open, for a dry-run */
+func (ro *Router) DryRunConnect(rtc RunTimeConfig) {
+	fmt.Fprintf(rtc.W, "[connect] %s@%s\n", rtc.Username, rtc.ConnectionAddr)
+}
+
+/*DryRunCommands writes the commands, that RunCommands would send, with the mlxsh_ macros replaced */
+func (ro *Router) DryRunCommands(rtc RunTimeConfig, commands io.Reader) error {
+	scanner := bufio.NewScanner(commands)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, AnswerDirective) {
+			fmt.Fprintf(rtc.W, "[answer] %s\n", strings.TrimSpace(strings.TrimPrefix(line, AnswerDirective)))
+			continue
+		}
+		fmt.Fprintf(rtc.W, "> %s\n", ro.RewriteCommand(line))
+	}
+	return scanner.Err()
+}
+
+/*DryRunConfiguration writes the statements, that PasteConfiguration would send */
+func (ro *Router) DryRunConfiguration(rtc RunTimeConfig, configuration io.Reader) error {
+	scanner := bufio.NewScanner(configuration)
+	for scanner.Scan() {
+		if strings.HasPrefix(scanner.Text(), "#") {
+			continue
+		}
+		fmt.Fprintf(rtc.W, "+ %s\n", scanner.Text())
+	}
+	return scanner.Err()
+}
+
+/*
+DryRunStepper is implemented by router modules, that write the login, exec,
+paste and commit of a dry-run one by one, e.g. for the steps of a playbook
+*/
+type DryRunStepper interface {
+	DryRunLogin()
+	DryRunExec(commands io.Reader) error
+	DryRunPaste(configuration io.Reader) error
+	DryRunCommit() error
+}
+
+/*DryRunSteps writes the login and the exec or paste and commit of a single run */
+func DryRunSteps(d DryRunStepper, execMode bool, input io.Reader) error {
+	d.DryRunLogin()
+
+	if execMode {
+		return d.DryRunExec(input)
+	}
+
+	if err := d.DryRunPaste(input); err != nil {
+		return err
+	}
+
+	return d.DryRunCommit()
+}
+
 /*DetectPrompt will try to detect the initial prompt and from this information will build a map of
 future possible prompts, e.g. the configuration prompt. */
 func (ro *Router) DetectPrompt(rtc RunTimeConfig, prompt string) error {
@@ -339,11 +473,11 @@ func (ro *Router) DetectPrompt(rtc RunTimeConfig, prompt string) error {
 		ro.PromptMode = "sshEnabled"
 		ro.SSHEnabledPrompt = strings.TrimSpace(myPrompt)
 	} else {
-		return fmt.Errorf("Cant run regexp for prompt detection, weird! Found: %s", myPrompt)
+		return Errorf(ErrPromptDetect, rtc.Hostname, "Cant run regexp for prompt detection, weird! Found: %s", myPrompt)
 	}
 
-	ro.SSHConfigPrompt = strings.Replace(ro.SSHEnabledPrompt, ro.PromptReplacements["SSHConfigPrompt"][0], ro.PromptReplacements["SSHConfigPrompt"][1], 1)
-	ro.SSHConfigPromptPre = strings.Replace(ro.SSHEnabledPrompt, ro.PromptReplacements["SSHConfigPromptPre"][0], ro.PromptReplacements["SSHConfigPromptPre"][1], 1)
+	ro.SSHConfigPrompt = replacePrompt(ro.SSHEnabledPrompt, ro.PromptReplacements["SSHConfigPrompt"])
+	ro.SSHConfigPromptPre = replacePrompt(ro.SSHEnabledPrompt, ro.PromptReplacements["SSHConfigPromptPre"])
 
 	ro.PromptModes["sshEnabled"] = ro.SSHEnabledPrompt
 	ro.PromptModes["sshConfig"] = ro.SSHConfigPrompt
@@ -356,13 +490,21 @@ func (ro *Router) DetectPrompt(rtc RunTimeConfig, prompt string) error {
 	}
 
 	if ro.SSHEnabledPrompt == "" {
-		return fmt.Errorf("Cant detect any prompt")
+		return Errorf(ErrPromptDetect, rtc.Hostname, "Cant detect any prompt")
 	}
 
 	return nil
 
 }
 
+/* replacePrompt applies pairs of old and new strings on a prompt, e.g. {"<", "[", ">", "]"} */
+func replacePrompt(prompt string, replacements []string) string {
+	for x := 0; x+1 < len(replacements); x += 2 {
+		prompt = strings.Replace(prompt, replacements[x], replacements[x+1], 1)
+	}
+	return prompt
+}
+
 /*Close will close the SSH-session and the SSH-tcp-connection */
 func (ro *Router) Close() {
 	if ro.SSHSession != nil {
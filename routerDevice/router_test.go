@@ -1,11 +1,23 @@
 package router_test
 
 import (
+	"bufio"
 	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"github.com/ipcjk/mlxsh/libcheck"
 	"github.com/ipcjk/mlxsh/libhost"
 	"github.com/ipcjk/mlxsh/routerDevice"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"io/ioutil"
+	"net"
 	"regexp"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestCreatePseudoRouter(t *testing.T) {
@@ -62,3 +74,477 @@ func CreatePseudoRunTimeConfig(t *testing.T) {
 	}
 
 }
+
+/*
+fakeTerminal starts a fake device, that answers every line written to stdin
+with the reply of script on stdout, the pipes are closed after the test
+*/
+func fakeTerminal(t *testing.T, script func(line string) string) (io.WriteCloser, io.Reader) {
+	deviceReader, stdin := io.Pipe()
+	stdout, deviceWriter := io.Pipe()
+
+	t.Cleanup(func() {
+		stdin.Close()
+		deviceWriter.Close()
+	})
+
+	go func() {
+		scanner := bufio.NewScanner(deviceReader)
+		for scanner.Scan() {
+			io.WriteString(deviceWriter, script(scanner.Text()))
+		}
+	}()
+
+	return stdin, stdout
+}
+
+/* pasteRouter returns a router, that talks to a fake configuration terminal */
+func pasteRouter(t *testing.T, policy string) (*router.Router, router.RunTimeConfig) {
+	stdin, stdout := fakeTerminal(t, func(line string) string {
+		var output string
+		switch {
+		case strings.Contains(line, "bogus"):
+			output = "Invalid input -> bogus\n"
+		case strings.Contains(line, "vlan"):
+			output = "Warning: vlan already exists\n"
+		case strings.Contains(line, "interface"):
+			output = "Info: interface is down\n"
+		}
+		return line + "\n" + output + "rt1(config)#"
+	})
+
+	ro := &router.Router{
+		SSHConfigPromptPre: "rt1(config",
+		SSHStdinPipe:       stdin,
+		SSHStdoutPipe:      stdout,
+		ErrorMatches:       regexp.MustCompile(`(?i)(Invalid input|Warning)`),
+		Classifiers: []router.Classifier{
+			{Severity: router.SeverityError, Pattern: regexp.MustCompile(`(?i)Invalid input`)},
+			{Severity: router.SeverityWarning, Pattern: regexp.MustCompile(`(?i)Warning`)},
+			{Severity: router.SeverityInfo, Pattern: regexp.MustCompile(`(?i)Info`)},
+		},
+	}
+
+	rtc := router.RunTimeConfig{
+		HostConfig: libhost.HostConfig{ErrorPolicy: policy, ReadTimeout: time.Second * 5},
+		W:          new(bytes.Buffer)}
+
+	return ro, rtc
+}
+
+func TestPastePolicies(t *testing.T) {
+	configuration := "hostname rt1\nvlan 10\n# comment\nbogus statement\ninterface ethernet 1/1\n"
+
+	var policies = []struct {
+		policy     string
+		statements int
+		failed     bool
+	}{
+		{router.PolicyAbort, 2, true},
+		{router.PolicyAbortIgnoreWarning, 3, true},
+		{router.PolicyContinue, 4, true},
+	}
+
+	for _, p := range policies {
+		ro, rtc := pasteRouter(t, p.policy)
+
+		results, err := ro.PasteConfigurationResults(rtc, strings.NewReader(configuration))
+		if (err != nil) != p.failed {
+			t.Errorf("%s: unexpected error result: %v", p.policy, err)
+		}
+
+		if len(results) != p.statements {
+			t.Errorf("%s: expected %d results, got %d", p.policy, p.statements, len(results))
+		}
+	}
+
+	ro, rtc := pasteRouter(t, router.PolicyContinue)
+	results, _ := ro.PasteConfigurationResults(rtc, strings.NewReader(configuration))
+
+	expected := []router.LineResult{
+		{Line: 1, Statement: "hostname rt1", Applied: true},
+		{Line: 2, Statement: "vlan 10", Severity: router.SeverityWarning, Message: "Warning: vlan already exists", Applied: true},
+		{Line: 4, Statement: "bogus statement", Severity: router.SeverityError, Message: "Invalid input -> bogus", Applied: false},
+		{Line: 5, Statement: "interface ethernet 1/1", Severity: router.SeverityInfo, Message: "Info: interface is down", Applied: true},
+	}
+
+	for x := range expected {
+		if results[x] != expected[x] {
+			t.Errorf("Wrong result for line %d: %+v", expected[x].Line, results[x])
+		}
+	}
+
+	if applied := router.AppliedStatements(results); len(applied) != 3 {
+		t.Errorf("Wrong applied statements: %v", applied)
+	}
+
+	if err := router.CheckPolicy("ignore"); err == nil {
+		t.Error("Unknown policy accepted")
+	}
+}
+
+func TestPasteClassifyRules(t *testing.T) {
+	configuration := "hostname rt1\nvlan 10\nbogus statement\ninterface ethernet 1/1\n"
+
+	ro, rtc := pasteRouter(t, router.PolicyAbort)
+	rtc.Classify = []string{"info: vlan already exists", "Error: ^Info: interface"}
+
+	results, err := ro.PasteConfigurationResults(rtc, strings.NewReader(configuration))
+	if router.Kind(err) != router.ErrConfigRejected {
+		t.Errorf("Bogus statement should stop the paste, got %v", err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("Downgraded warning should not stop the paste, got %d results", len(results))
+	}
+	if results[1].Severity != router.SeverityInfo || results[1].Message != "Warning: vlan already exists" {
+		t.Errorf("Host rule did not classify the warning as info: %+v", results[1])
+	}
+
+	ro, rtc = pasteRouter(t, router.PolicyContinue)
+	rtc.Classify = []string{"Error: ^Info: interface"}
+	if results, _ = ro.PasteConfigurationResults(rtc, strings.NewReader(configuration)); results[3].Severity != router.SeverityError {
+		t.Errorf("Host rule did not classify the info as error: %+v", results[3])
+	}
+
+	for _, rule := range []string{"notice: down", "warning:", "error: ([", "no severity"} {
+		if _, err := router.ParseClassifier(rule); err == nil {
+			t.Errorf("Broken classify rule %q accepted", rule)
+		}
+	}
+
+	ro, rtc = pasteRouter(t, router.PolicyContinue)
+	rtc.Classify = []string{"critical: bogus"}
+	if _, err := ro.PasteConfigurationResults(rtc, strings.NewReader(configuration)); err == nil {
+		t.Error("Paste with broken classify rule started")
+	}
+}
+
+/* execRouter returns a router, that talks to a fake exec terminal */
+func execRouter(t *testing.T, policy string) (*router.Router, router.RunTimeConfig) {
+	stdin, stdout := fakeTerminal(t, func(line string) string {
+		var output = "output of " + line + "\n"
+		if strings.Contains(line, "bpg") {
+			output = "Invalid input -> bpg sum\nType ? for a list\n"
+		}
+		if strings.HasPrefix(line, "show ip route") {
+			output = "No routes found\n"
+		}
+		return line + "\n" + output + "SSH@rt1#"
+	})
+
+	ro := &router.Router{
+		SSHEnabledPrompt: "SSH@rt1#",
+		SSHStdinPipe:     stdin,
+		SSHStdoutPipe:    stdout,
+		ExecErrorMatches: regexp.MustCompile(`(?m)^\s*Invalid input -> `),
+	}
+
+	rtc := router.RunTimeConfig{
+		HostConfig: libhost.HostConfig{ErrorPolicy: policy, ReadTimeout: time.Second * 5},
+		W:          new(bytes.Buffer)}
+
+	return ro, rtc
+}
+
+func TestRunCommandsErrors(t *testing.T) {
+	commands := "sh ip bpg sum\nshow version\n"
+
+	ro, rtc := execRouter(t, "")
+	if err := ro.RunCommands(rtc, strings.NewReader(commands)); !errors.Is(err, router.ErrCommandFailed) {
+		t.Errorf("Invalid command was not detected as failed command: %v", err)
+	}
+	if strings.Contains(rtc.W.(*bytes.Buffer).String(), "output of show version") {
+		t.Error("Commands after the failed command were executed")
+	}
+
+	ro, rtc = execRouter(t, router.PolicyContinue)
+	err := ro.RunCommands(rtc, strings.NewReader(commands))
+	if !errors.Is(err, router.ErrCommandFailed) || !strings.Contains(err.Error(), "sh ip bpg sum") {
+		t.Errorf("Failed command not reported: %v", err)
+	}
+	if !strings.Contains(rtc.W.(*bytes.Buffer).String(), "output of show version") {
+		t.Error("Commands after the failed command were not executed")
+	}
+
+	outputs := ro.CommandOutputs()
+	if len(outputs) != 2 || outputs[0].Err == nil || outputs[1].Err != nil {
+		t.Fatalf("Wrong command outputs: %+v", outputs)
+	}
+	if outputs[1].Command != "show version" || outputs[1].Output != "output of show version" {
+		t.Errorf("Output of show version not kept separately: %+v", outputs[1])
+	}
+
+	ro, rtc = execRouter(t, "")
+	if err := ro.RunCommands(rtc, strings.NewReader("show version\n")); err != nil {
+		t.Errorf("Valid command failed: %s", err)
+	}
+}
+
+/* execDevice runs the commands of a router with its runtime config, like a router module */
+type execDevice struct {
+	*router.Router
+	rtc router.RunTimeConfig
+}
+
+func (d execDevice) RunCommands(commands io.Reader) error {
+	return d.Router.RunCommands(d.rtc, commands)
+}
+
+func TestRunCommandCheck(t *testing.T) {
+	ro, rtc := execRouter(t, "")
+	buffer := rtc.W.(*bytes.Buffer)
+	buffer.WriteString("banner\n")
+
+	/* the pattern is only in the echoed command */
+	check := libcheck.Check{Name: "default route", Command: "show ip route 0.0.0.0/0", Match: `0\.0\.0\.0/0`, Expect: "present"}
+	if err := check.Compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	output, err := router.RunCommand(execDevice{ro, rtc}, buffer, check.Command)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output != "No routes found" {
+		t.Errorf("Output has echo or prompt: %q", output)
+	}
+	if buffer.String() != "banner\n" {
+		t.Errorf("Raw output not cut from the buffer: %q", buffer.String())
+	}
+	if result := libcheck.Evaluate(check, output); result.Passed {
+		t.Errorf("Check passed on the echoed command: %+v", result)
+	}
+}
+
+/* answerRouter returns a router, that talks to a fake exec terminal with questions */
+func answerRouter(t *testing.T, answers ...string) (*router.Router, router.RunTimeConfig) {
+	var pending string
+
+	stdin, stdout := fakeTerminal(t, func(line string) string {
+		switch {
+		case pending == "reload" && line != "y":
+			pending = ""
+			return line + "\naborted\nSSH@rt1#"
+		case pending != "":
+			done := pending
+			pending = ""
+			return line + "\n" + done + " done\nSSH@rt1#"
+		case strings.HasPrefix(line, "reload"):
+			pending = "reload"
+			return line + "\nAre you sure? (y/n) "
+		case strings.HasPrefix(line, "clear"):
+			pending = "clear"
+			return line + "\nProceed with clearing [confirm]"
+		default:
+			return line + "\noutput of " + line + "\nSSH@rt1#"
+		}
+	})
+
+	ro := &router.Router{
+		SSHEnabledPrompt: "SSH@rt1#",
+		SSHStdinPipe:     stdin,
+		SSHStdoutPipe:    stdout,
+	}
+
+	rtc := router.RunTimeConfig{
+		HostConfig: libhost.HostConfig{Answers: answers, ReadTimeout: time.Second * 5},
+		W:          new(bytes.Buffer)}
+
+	return ro, rtc
+}
+
+func TestRunCommandsAnswers(t *testing.T) {
+	ro, rtc := answerRouter(t, `reload => \(y/n\) -> y`)
+	if err := ro.RunCommands(rtc, strings.NewReader("reload\nshow version\n")); err != nil {
+		t.Errorf("Answered question failed: %s", err)
+	}
+	if output := rtc.W.(*bytes.Buffer).String(); !strings.Contains(output, "reload done") || !strings.Contains(output, "output of show version") {
+		t.Errorf("Wrong output after answer: %s", output)
+	}
+
+	ro, rtc = answerRouter(t)
+	start := time.Now()
+	err := ro.RunCommands(rtc, strings.NewReader("clear ip bgp neighbor all\nshow version\n"))
+	if err == nil || !strings.Contains(err.Error(), "no answer rule") {
+		t.Errorf("Unanswered question not detected: %v", err)
+	}
+	if time.Since(start) > time.Second*4 {
+		t.Error("Unanswered question waited for the read timeout")
+	}
+
+	ro, rtc = answerRouter(t, `reload => \(y/n\) -> n`)
+	script := "mlxsh_answer \\[confirm\\] ->\nclear ip bgp neighbor all\nreload\n"
+	if err := ro.RunCommands(rtc, strings.NewReader(script)); err != nil {
+		t.Errorf("Answer rule from the commands failed: %s", err)
+	}
+	if output := rtc.W.(*bytes.Buffer).String(); !strings.Contains(output, "clear done") || !strings.Contains(output, "aborted") {
+		t.Errorf("Wrong output for script answers: %s", output)
+	}
+
+	for _, rule := range []string{"(y/n) y", "-> y", "reload => ( -> y", "( => y/n -> y"} {
+		if _, err := router.ParseAnswerRule(rule); err == nil {
+			t.Errorf("Invalid answer rule accepted: %s", rule)
+		}
+	}
+}
+
+func TestCleanOutput(t *testing.T) {
+	ro := router.Router{SSHEnabledPrompt: "SSH@rt1#"}
+
+	var outputs = []struct {
+		command  string
+		output   string
+		expected string
+	}{
+		{"sh ip cache", "sh ip cache\r\nTotal entries: 3\r\nSSH@rt1#", "Total entries: 3"},
+		{"sh ipv6 cache", "\r\nSSH@rt1#sh ipv6 cache\r\nTotal entries: 0\r\n\r\nSSH@rt1#", "Total entries: 0"},
+		{"show clock", "10:42:01 GMT+02 Mon Oct 19 2026\nSSH@rt1#", "10:42:01 GMT+02 Mon Oct 19 2026"},
+		{"show version", "show version\nSSH@rt1#", ""},
+	}
+
+	for _, o := range outputs {
+		if cleaned := ro.CleanOutput(o.command, o.output); cleaned != o.expected {
+			t.Errorf("%s: expected %q, got %q", o.command, o.expected, cleaned)
+		}
+	}
+}
+
+func TestErrorKinds(t *testing.T) {
+	timeout := router.Errorf(router.ErrTimeout, "rt1", "Timed out after %s", time.Second)
+	err := router.Errorf(router.ErrPromptDetect, "rt1", "Cant find configure prompt: %w", timeout)
+
+	if !errors.Is(err, router.ErrPromptDetect) || !errors.Is(err, router.ErrTimeout) {
+		t.Errorf("Kind or cause not found: %v", err)
+	}
+	if errors.Is(err, router.ErrAuth) {
+		t.Error("Wrong kind found")
+	}
+	if router.Kind(err) != router.ErrPromptDetect {
+		t.Errorf("Wrong kind of the outermost error: %v", router.Kind(err))
+	}
+	if err.Error() != "Cant find configure prompt: rt1: Timed out after 1s" {
+		t.Errorf("Wrong message: %s", err)
+	}
+
+	ro := &router.Router{PromptDetect: `[@?\.\d\w-]+> ?$`, PromptModes: make(map[string]string)}
+	rtc := router.RunTimeConfig{HostConfig: libhost.HostConfig{Hostname: "rt1"}}
+	if err := ro.DetectPrompt(rtc, "login failed"); !errors.Is(err, router.ErrPromptDetect) {
+		t.Errorf("Missing prompt not classified: %v", err)
+	}
+}
+
+/* denyServer starts a local ssh server, that rejects every login */
+func denyServer(t *testing.T) (string, int) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			return nil, errors.New("denied")
+		},
+	}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		conn, err := listener.Accept()
+		listener.Close()
+		if err != nil {
+			return
+		}
+		ssh.NewServerConn(conn, config)
+		conn.Close()
+	}()
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	sshPort, _ := strconv.Atoi(port)
+	return host, sshPort
+}
+
+func TestDialErrors(t *testing.T) {
+	ip, port := denyServer(t)
+
+	rtc := router.RunTimeConfig{HostConfig: libhost.HostConfig{Hostname: "rt1", SSHIP: ip, SSHPort: port, Username: "user", Password: "wrong"}}
+	router.GenerateDefaults(&rtc)
+
+	var e *router.Error
+	err := new(router.Router).DialSSH(rtc)
+	if !errors.Is(err, router.ErrAuth) || !errors.As(err, &e) || e.Host != "rt1" {
+		t.Errorf("Rejected login not classified for rt1: %v", err)
+	}
+
+	/* the listener is closed after the first connection */
+	err = new(router.Router).SetupSSH(rtc, false)
+	if !errors.Is(err, router.ErrDial) || !errors.As(err, &e) || e.Host != "rt1" {
+		t.Errorf("Refused connection not classified for rt1: %v", err)
+	}
+}
+
+/* chanWriter sends every write to the channel */
+type chanWriter chan string
+
+func (c chanWriter) Write(p []byte) (int, error) {
+	c <- string(p)
+	return len(p), nil
+}
+
+func TestStreamWhileReading(t *testing.T) {
+	deviceReader, stdin := io.Pipe()
+	stdout, deviceWriter := io.Pipe()
+	defer stdin.Close()
+	defer deviceWriter.Close()
+	go io.Copy(ioutil.Discard, deviceReader)
+
+	streamed := make(chan string, 10)
+	w := new(bytes.Buffer)
+	ro := &router.Router{SSHEnabledPrompt: "SSH@rt1#", SSHStdinPipe: stdin, SSHStdoutPipe: stdout}
+	rtc := router.RunTimeConfig{HostConfig: libhost.HostConfig{ReadTimeout: time.Second * 5}, W: w, Stream: chanWriter(streamed)}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- ro.RunCommands(rtc, strings.NewReader("show logging\n"))
+	}()
+
+	/* the first line of a long output has to arrive before the prompt */
+	io.WriteString(deviceWriter, "show logging\nLink down on ethernet 1/1\n")
+	select {
+	case line := <-streamed:
+		if !strings.Contains(line, "Link down") {
+			t.Errorf("Wrong streamed output: %q", line)
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatal("Output not streamed before the command finished")
+	}
+
+	io.WriteString(deviceWriter, "SSH@rt1#")
+	if err := <-done; err != nil {
+		t.Fatalf("Command failed: %s", err)
+	}
+
+	if strings.Contains(w.String(), "Link down") {
+		t.Errorf("Streamed output written again: %q", w.String())
+	}
+}
+
+func TestStreamPasteProgress(t *testing.T) {
+	ro, rtc := pasteRouter(t, router.PolicyContinue)
+	rtc.Stream = new(bytes.Buffer)
+
+	if _, err := ro.PasteConfigurationResults(rtc, strings.NewReader("hostname rt1\nvlan 10\n")); err != nil {
+		t.Fatalf("Paste failed: %s", err)
+	}
+
+	if progress := rtc.W.(*bytes.Buffer).String(); progress != "+ hostname rt1\n+ vlan 10\n" {
+		t.Errorf("Paste progress is not line by line: %q", progress)
+	}
+}
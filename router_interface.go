@@ -1,6 +1,10 @@
 package main
 
-import "io"
+import (
+	"io"
+
+	"github.com/ipcjk/mlxsh/routerDevice"
+)
 
 /*RouterInt is the minimum interface that a router module should have */
 type RouterInt interface {
@@ -11,3 +15,28 @@ type RouterInt interface {
 	PasteConfiguration(io.Reader) error
 	RunCommands(io.Reader) error
 }
+
+/*DryRunner is implemented by router modules, that can print their command stream without connecting */
+type DryRunner interface {
+	DryRun(execMode bool, input io.Reader) error
+}
+
+/*Rollbacker is implemented by router modules, that can undo the last committed change */
+type Rollbacker interface {
+	Rollback() error
+}
+
+/*ConfirmChecker is implemented by router modules, that can run checks before they confirm a commit */
+type ConfirmChecker interface {
+	SetConfirmCheck(check func() error)
+}
+
+/*PasteResulter is implemented by router modules, that classify every pasted configuration statement */
+type PasteResulter interface {
+	PasteResults() []router.LineResult
+}
+
+/*CommandOutputer is implemented by router modules, that keep the output of every exec command */
+type CommandOutputer interface {
+	CommandOutputs() []router.CommandOutput
+}
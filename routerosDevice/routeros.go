@@ -0,0 +1,169 @@
+package routerosDevice
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ipcjk/mlxsh/routerDevice"
+)
+
+type routerosDevice struct {
+	RTC router.RunTimeConfig
+	router.Router
+}
+
+/*
+RouterOSDevice returns a new
+routerosDevice object, has a init struct of type Router.RunTimeConfig
+*/
+func RouterOSDevice(Config router.RunTimeConfig) *routerosDevice {
+	var configureErrors = `(?i)(failure:|syntax error|bad command name|expected end of command|input does not match any value)`
+	var execErrors = `(?m)^\s*(bad command name|syntax error|expected end of command|failure: )`
+
+	/* Fill our config with defaults for ssh and timesouts */
+	router.GenerateDefaults(&Config)
+
+	/* The +ct suffix disables colors and terminal auto detection on the MikroTik console */
+	if !strings.Contains(Config.SSHClientConfig.User, "+") {
+		Config.SSHClientConfig.User += "+ct"
+	}
+
+	return &routerosDevice{
+		RTC: Config,
+		Router: router.Router{
+			CommandRewrite: map[string]string{
+				"mlxsh_log":       "/log print",
+				"mlxsh_audit":     "/log print where topics~\"system\"",
+				"mlxsh_chassis":   "/system resource print",
+				"mlxsh_route":     "/ip route print",
+				"mlxsh_route6":    "/ipv6 route print",
+				"mlxsh_route_sum": "/ip route print count-only",
+				"mlxsh_bgp":       "/routing bgp peer print status",
+				"mlxsh_bgpn":      "/routing bgp peer print detail",
+				"mlxsh_vlans":     "/interface vlan print",
+			},
+			PromptModes:        make(map[string]string),
+			ErrorMatches:       regexp.MustCompile(configureErrors),
+			ExecErrorMatches:   regexp.MustCompile(execErrors),
+			PromptDetect:       `\[[^\[\]\s]+@[^\[\]]+\] ?> ?$`,
+			PromptReadTriggers: []string{"] >"},
+			/* RouterOS has no configuration mode, every path prompt starts with [user@host] */
+			PromptReplacements: map[string][]string{
+				"SSHConfigPrompt":    {"] >", "] >"},
+				"SSHConfigPromptPre": {"] >", "]"}},
+		}}
+}
+
+func (b *routerosDevice) Connect() (err error) {
+	if err = b.SetupSSH(b.RTC, true); err != nil {
+		return err
+	}
+
+	/* RouterOS uses `[user@host] > ` for prompt */
+	prompt, err := b.Router.ReadTill(b.RTC, b.PromptReadTriggers)
+	if err != nil {
+		return err
+	}
+
+	if err := b.DetectSetPrompt(prompt); err != nil {
+		return fmt.Errorf("detect prompt: %w", err)
+	}
+
+	if err = b.GetPromptMode(b.RTC); err != nil {
+		return
+	}
+
+	return
+}
+
+func (b *routerosDevice) DetectSetPrompt(prompt string) error {
+	if err := b.DetectPrompt(b.RTC, prompt); err != nil {
+		return err
+	}
+
+	/* commands like /ip address change the menu path, the prompt becomes [user@host] /ip address> */
+	b.PromptMatches = regexp.MustCompile(regexp.QuoteMeta(b.SSHConfigPromptPre) + ` ?(/[^>\r\n]*)?>\s*$`)
+	return nil
+}
+
+func (b *routerosDevice) ConfigureTerminalMode() error {
+	/* RouterOS has no configuration mode, every line carries its own /path */
+	if b.RTC.Debug {
+		fmt.Fprint(b.RTC.W, "Configuration mode on")
+	}
+	return nil
+}
+
+func (b *routerosDevice) exportBackup() error {
+	fileName := fmt.Sprintf("mlxsh-%s", time.Now().Format("20060102-150405"))
+
+	if err := b.Write(b.RTC, "/export file="+fileName+"\n"); err != nil {
+		return err
+	}
+
+	val, err := b.ReadTillEnabledPrompt(b.RTC)
+	if err != nil {
+		return fmt.Errorf("Cant export backup: %w", err)
+	}
+
+	if b.ErrorMatches.MatchString(val) {
+		return fmt.Errorf("Cant export backup: %s", strings.TrimSpace(val))
+	}
+
+	if b.RTC.Debug {
+		fmt.Fprintf(b.RTC.W, "Exported backup to %s.rsc\n", fileName)
+	}
+
+	return nil
+}
+
+func (b *routerosDevice) CommitConfiguration() (err error) {
+	/* RouterOS applies changes immediately, there is nothing to commit */
+	if b.RTC.BackupConfig {
+		return b.exportBackup()
+	}
+	return
+}
+
+func (b *routerosDevice) PasteConfiguration(configuration io.Reader) (err error) {
+	return b.Router.PasteConfiguration(b.RTC, configuration)
+}
+
+func (b *routerosDevice) RunCommands(commands io.Reader) (err error) {
+	return b.Router.RunCommands(b.RTC, commands)
+}
+
+/*DryRun writes the command stream for exec or config mode without connecting */
+func (b *routerosDevice) DryRun(execMode bool, input io.Reader) error {
+	return router.DryRunSteps(b, execMode, input)
+}
+
+/*DryRunExec writes the commands, that RunCommands would send */
+func (b *routerosDevice) DryRunExec(commands io.Reader) error {
+	return b.DryRunCommands(b.RTC, commands)
+}
+
+/*DryRunLogin writes the connection */
+func (b *routerosDevice) DryRunLogin() {
+	b.DryRunConnect(b.RTC)
+}
+
+/*DryRunPaste writes the statements */
+func (b *routerosDevice) DryRunPaste(configuration io.Reader) error {
+	return b.DryRunConfiguration(b.RTC, configuration)
+}
+
+/*DryRunCommit writes the backup export, RouterOS applies changes immediately */
+func (b *routerosDevice) DryRunCommit() error {
+	if b.RTC.BackupConfig {
+		b.DryRunMode(b.RTC, "/export file=mlxsh-<timestamp>")
+	}
+	return nil
+}
+
+func (b *routerosDevice) Close() {
+	b.Router.Close()
+}
@@ -0,0 +1,148 @@
+package routerosDevice_test
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/ipcjk/mlxsh/libhost"
+	"github.com/ipcjk/mlxsh/routerDevice"
+	"github.com/ipcjk/mlxsh/routerosDevice"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRouterOSConstructor(t *testing.T) {
+	var Config = libhost.HostConfig{
+		DeviceType: "routeros",
+		Hostname:   "localhost",
+		Username:   "myuser",
+		Password:   "mypassword",
+	}
+
+	singleRouter := routerosDevice.RouterOSDevice(router.RunTimeConfig{HostConfig: Config, Debug: true, W: new(bytes.Buffer)})
+
+	if singleRouter == nil {
+		t.Error("Cant create routeros object")
+	}
+
+	if singleRouter.RTC.SSHPort != 22 {
+		t.Error("Wrong SSH-Port in default settings")
+	}
+
+	if Config.WriteTimeout != time.Second*0 {
+		t.Error("Wrong writetimeout in default settings")
+	}
+
+	if singleRouter.RTC.Username != "myuser" || singleRouter.RTC.Password != "mypassword" {
+		t.Error("Cant match user or password")
+	}
+
+	if singleRouter.RTC.SSHClientConfig.User != "myuser+ct" {
+		t.Errorf("Login user is missing the +ct suffix: %s", singleRouter.RTC.SSHClientConfig.User)
+	}
+
+}
+
+func TestSSHConnect(t *testing.T) {
+	var Config = libhost.HostConfig{
+		DeviceType: "routeros",
+		Hostname:   "localhost",
+		Username:   "user",
+		Password:   "password",
+		SSHPort:    9131,
+	}
+
+	singleRouter := routerosDevice.RouterOSDevice(router.RunTimeConfig{HostConfig: Config, Debug: true, W: new(bytes.Buffer)})
+
+	if singleRouter == nil {
+		t.Error("Cant create routeros object")
+	}
+
+	if err := singleRouter.Connect(); err == nil {
+		t.Error("Logged into localhost with default settings, this cant be true!")
+	}
+
+}
+
+func TestDetectPrompt(t *testing.T) {
+	var Config = libhost.HostConfig{
+		DeviceType: "mikrotik",
+		Hostname:   "cpe-1",
+		Username:   "admin",
+		Password:   "password",
+	}
+
+	singleRouter := routerosDevice.RouterOSDevice(router.RunTimeConfig{HostConfig: Config, Debug: true, W: new(bytes.Buffer)})
+
+	if err := singleRouter.DetectSetPrompt("  MikroTik RouterOS 6.48 (c) 1999-2020\r\n\r\n[admin@cpe-1] > "); err != nil {
+		t.Errorf("Cant detect prompt! :%s", err)
+	}
+
+	if singleRouter.SSHEnabledPrompt != "[admin@cpe-1] >" {
+		t.Errorf("Wrong enabled prompt: %s", singleRouter.SSHEnabledPrompt)
+	}
+
+	if singleRouter.SSHConfigPromptPre != "[admin@cpe-1]" {
+		t.Errorf("Wrong configuration section prompt: %s", singleRouter.SSHConfigPromptPre)
+	}
+
+	if !singleRouter.ErrorMatches.MatchString("failure: already have such address") {
+		t.Error("Failure output not detected as error")
+	}
+
+}
+
+func TestMenuPathPrompt(t *testing.T) {
+	deviceReader, stdin := io.Pipe()
+	stdout, deviceWriter := io.Pipe()
+	defer stdin.Close()
+	defer deviceWriter.Close()
+
+	/* the device echoes the command and answers with the prompt of the new menu path */
+	go func() {
+		var path string
+		scanner := bufio.NewScanner(deviceReader)
+		for scanner.Scan() {
+			var output string
+			switch scanner.Text() {
+			case "/ip address":
+				path = "/ip address"
+			case "print":
+				output = " # ADDRESS            NETWORK         INTERFACE\r\n 0 192.0.2.1/24       192.0.2.0       ether1\r\n"
+			case "/":
+				path = ""
+			}
+			io.WriteString(deviceWriter, scanner.Text()+"\r\n"+output+"[admin@cpe-1] "+path+"> ")
+		}
+	}()
+
+	singleRouter := routerosDevice.RouterOSDevice(router.RunTimeConfig{
+		HostConfig: libhost.HostConfig{Hostname: "cpe-1", Username: "admin"}, W: new(bytes.Buffer)})
+	singleRouter.SSHStdinPipe = stdin
+	singleRouter.SSHStdoutPipe = stdout
+
+	if err := singleRouter.DetectSetPrompt("[admin@cpe-1] > "); err != nil {
+		t.Fatalf("Cant detect prompt! :%s", err)
+	}
+
+	/* a missed prompt blocks the read of the pipe, so give up after a while */
+	done := make(chan error, 1)
+	go func() {
+		done <- singleRouter.RunCommands(strings.NewReader("/ip address\nprint\n/\n"))
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Commands in a menu path failed: %s", err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("Prompt with menu path not detected")
+	}
+
+	outputs := singleRouter.CommandOutputs()
+	if len(outputs) != 3 || outputs[1].Command != "print" || !strings.HasSuffix(outputs[1].Output, "ether1") {
+		t.Errorf("Wrong output in the menu path: %+v", outputs)
+	}
+}
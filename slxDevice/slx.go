@@ -3,6 +3,7 @@ package slxDevice
 import (
 	"fmt"
 	"io"
+	"regexp"
 	"time"
 
 	"github.com/ipcjk/mlxsh/routerDevice"
@@ -18,6 +19,7 @@ VdxDevice returns a new
 vdxDevice object, has a init struct of type VdxConfig
 */
 func SlxDevice(Config router.RunTimeConfig) *slxDevice {
+	var execErrors = `(?m)^\s*(syntax error: |% Error|% Invalid input)`
 
 	/* Fill our config with defaults for ssh and timesouts */
 	router.GenerateDefaults(&Config)
@@ -42,24 +44,29 @@ func SlxDevice(Config router.RunTimeConfig) *slxDevice {
 				"mlxsh_vlans":      "show vlan brief",
 			},
 			PromptModes:        make(map[string]string),
+			ExecErrorMatches:   regexp.MustCompile(execErrors),
 			PromptDetect:       `[@?\.\d\w-]+# ?$`,
 			PromptReadTriggers: []string{"# "},
 			PromptReplacements: map[string][]string{
 				"SSHConfigPrompt":    {"#", "(config)#"},
 				"SSHConfigPromptPre": {"#", "(conf"},
-			}}}
+			},
+			Answers: []router.AnswerRule{{
+				Command:  regexp.MustCompile(`^copy running-config startup-config`),
+				Prompt:   regexp.MustCompile(`continue\? \[y/n\]`),
+				Response: "y"}}}}
 }
 
 func (b *slxDevice) Connect() (err error) {
 
-	if err = b.SetupSSH(b.RTC.ConnectionAddr, b.RTC.SSHClientConfig, true); err != nil {
+	if err = b.SetupSSH(b.RTC, true); err != nil {
 		return err
 	}
 
 	prompt, err := b.Router.ReadTill(b.RTC, b.PromptReadTriggers)
 
 	if err := b.DetectSetPrompt(prompt); err != nil {
-		return fmt.Errorf("detect prompt: %s", err)
+		return fmt.Errorf("detect prompt: %w", err)
 	}
 
 	if _, err = b.skipPageDisplayMode(); err != nil {
@@ -80,7 +87,7 @@ func (b *slxDevice) DetectSetPrompt(prompt string) error {
 func (b *slxDevice) write(command string) error {
 	_, err := b.SSHStdinPipe.Write([]byte(command))
 	if err != nil {
-		return fmt.Errorf("cant write to the ssh connection %s", err)
+		return fmt.Errorf("cant write to the ssh connection %w", err)
 	}
 
 	if b.RTC.Debug {
@@ -97,7 +104,7 @@ func (b *slxDevice) ConfigureTerminalMode() error {
 
 	_, err := b.ReadTill(b.RTC, []string{"(config)#"})
 	if err != nil {
-		return fmt.Errorf("cant find configure prompt: %s", err)
+		return router.Errorf(router.ErrPromptDetect, b.RTC.Hostname, "cant find configure prompt: %w", err)
 	}
 
 	if b.RTC.Debug {
@@ -109,7 +116,7 @@ func (b *slxDevice) ConfigureTerminalMode() error {
 
 func (b *slxDevice) ExecPrivilegedMode(command string) error {
 	if err := b.SwitchMode("sshEnabled"); err != nil {
-		return fmt.Errorf("cant switch to privileged mode: %s", err)
+		return fmt.Errorf("cant switch to privileged mode: %w", err)
 	}
 
 	if err := b.Write(b.RTC, command+"\n"); err != nil {
@@ -117,14 +124,14 @@ func (b *slxDevice) ExecPrivilegedMode(command string) error {
 	}
 	_, err := b.ReadTillEnabledPrompt(b.RTC)
 	if err != nil {
-		return fmt.Errorf("cant find  privileged mode: %s", err)
+		return router.Errorf(router.ErrPromptDetect, b.RTC.Hostname, "cant find  privileged mode: %w", err)
 	}
 	return nil
 }
 
 func (b *slxDevice) skipPageDisplayMode() (string, error) {
 	if err := b.SwitchMode("sshEnabled"); err != nil {
-		return "", fmt.Errorf("cant switch to enabled mode to execute terminal-length: %s", err)
+		return "", fmt.Errorf("cant switch to enabled mode to execute terminal-length: %w", err)
 	}
 
 	if err := b.Write(b.RTC, "terminal length 0\r\n"); err != nil {
@@ -170,22 +177,10 @@ func (b *slxDevice) CommitConfiguration() (err error) {
 		return err
 	}
 
-	if err := b.Write(b.RTC, "copy running-config startup-config\n"); err != nil {
-		return err
-	}
-
-	_, err = b.ReadTill(b.RTC, []string{"continue? [y/n]"})
+	/* the copy asks to continue, the built-in answer rule confirms */
+	_, err = b.RunCommand(b.RTC, "copy running-config startup-config", b.Answers)
 	if err != nil {
-		return fmt.Errorf("commit not completed or not successful: %s", err)
-	}
-
-	if err := b.Write(b.RTC, "y\n"); err != nil {
-		return err
-	}
-
-	_, err = b.ReadTillEnabledPrompt(b.RTC)
-	if err != nil {
-		return fmt.Errorf("cant find  back to privileged mode: %s", err)
+		return router.Errorf(router.ErrCommitFailed, b.RTC.Hostname, "commit not completed or not successful: %w", err)
 	}
 
 	/* give the SLX 1 or 2 seconds to settle down */
@@ -204,12 +199,40 @@ func (b *slxDevice) PasteConfiguration(configuration io.Reader) (err error) {
 
 func (b *slxDevice) RunCommands(commands io.Reader) (err error) {
 	if err = b.SwitchMode("sshEnabled"); err != nil {
-		return fmt.Errorf("cant switch to privileged mode: %s", err)
+		return fmt.Errorf("cant switch to privileged mode: %w", err)
 	}
 
 	return b.Router.RunCommands(b.RTC, commands)
 }
 
+/*DryRun writes the command stream for exec or config mode without connecting */
+func (b *slxDevice) DryRun(execMode bool, input io.Reader) error {
+	return router.DryRunSteps(b, execMode, input)
+}
+
+/*DryRunExec writes the commands, that RunCommands would send */
+func (b *slxDevice) DryRunExec(commands io.Reader) error {
+	return b.DryRunCommands(b.RTC, commands)
+}
+
+/*DryRunLogin writes the connection and the terminal settings */
+func (b *slxDevice) DryRunLogin() {
+	b.DryRunConnect(b.RTC)
+	b.DryRunMode(b.RTC, "terminal length 0")
+}
+
+/*DryRunPaste writes the configuration mode and the statements */
+func (b *slxDevice) DryRunPaste(configuration io.Reader) error {
+	b.DryRunMode(b.RTC, "conf t")
+	return b.DryRunConfiguration(b.RTC, configuration)
+}
+
+/*DryRunCommit writes the copy of the running-config into the startup-config */
+func (b *slxDevice) DryRunCommit() error {
+	b.DryRunMode(b.RTC, "exit configuration-mode", "copy running-config startup-config", "y")
+	return nil
+}
+
 func (b *slxDevice) Close() {
 	b.Router.Close()
 }
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/ipcjk/mlxsh/libcheck"
+	"github.com/ipcjk/mlxsh/routerDevice"
+)
+
+/* Exit codes of a run, log.Fatal on a configuration error exits with 1 */
+const (
+	exitOK      = 0
+	exitConfig  = 1
+	exitPartial = 2
+	exitFailed  = 3
+	exitAuth    = 4
+)
+
+/* runSummary counts the hosts of a run by status and the failed hosts by error class */
+type runSummary struct {
+	ok, failed, skipped int
+	classes             map[string]int
+}
+
+var summary = runSummary{classes: make(map[string]int)}
+
+/* add counts the result of a host */
+func (s *runSummary) add(elems chanHost) {
+	switch {
+	case elems.skipped:
+		s.skipped++
+	case elems.err != nil:
+		s.failed++
+		s.classes[errorClass(elems.err)]++
+	default:
+		s.ok++
+	}
+}
+
+/*
+exitCode returns 0 if all hosts are ok, 4 if all failed hosts could not
+authenticate, 3 if all hosts failed and 2 if some failed or were skipped.
+*/
+func (s runSummary) exitCode() int {
+	switch {
+	case s.failed == 0 && s.skipped == 0:
+		return exitOK
+	case s.failed > 0 && s.classes["auth"] == s.failed:
+		return exitAuth
+	case s.ok == 0 && s.skipped == 0:
+		return exitFailed
+	default:
+		return exitPartial
+	}
+}
+
+/* errorClasses name the kinds of errors of the router modules in the summary */
+var errorClasses = map[error]string{
+	router.ErrAuth:           "auth",
+	router.ErrHostKey:        "hostkey",
+	router.ErrDial:           "dial",
+	router.ErrPromptDetect:   "prompt",
+	router.ErrTimeout:        "timeout",
+	router.ErrConfigRejected: "rejected",
+	router.ErrCommitFailed:   "commit",
+	router.ErrCommandFailed:  "command",
+}
+
+/*
+errorClass sorts the error of a host by the kind of its router error, failed checks
+are check. A timeout is wrapped by the kind of the step, that waited, e.g. prompt.
+*/
+func errorClass(err error) string {
+	if errors.Is(err, router.ErrTimeout) {
+		return errorClasses[router.ErrTimeout]
+	}
+
+	if class, ok := errorClasses[router.Kind(err)]; ok {
+		return class
+	}
+
+	if errors.Is(err, libcheck.ErrFailed) {
+		return "check"
+	}
+
+	return "other"
+}
+
+/* printSummary prints the number of hosts per status and error class */
+func printSummary() {
+	var table bytes.Buffer
+	var classes []string
+
+	w := tabwriter.NewWriter(&table, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "=== summary: %d hosts\n", summary.ok+summary.failed+summary.skipped)
+	fmt.Fprintf(w, "ok\t%d\n", summary.ok)
+	fmt.Fprintf(w, "failed\t%d\n", summary.failed)
+	fmt.Fprintf(w, "skipped\t%d\n", summary.skipped)
+
+	for class := range summary.classes {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+
+	for _, class := range classes {
+		fmt.Fprintf(w, "  %s\t%d\n", class, summary.classes[class])
+	}
+	w.Flush()
+
+	notice("%s", table.String())
+}
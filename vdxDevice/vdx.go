@@ -3,6 +3,7 @@ package vdxDevice
 import (
 	"fmt"
 	"io"
+	"regexp"
 	"time"
 
 	"github.com/ipcjk/mlxsh/routerDevice"
@@ -18,6 +19,7 @@ VdxDevice returns a new
 vdxDevice object, has a init struct of type VdxConfig
 */
 func VdxDevice(Config router.RunTimeConfig) *vdxDevice {
+	var execErrors = `(?m)^\s*(syntax error: |% Error|% Invalid input)`
 
 	/* Fill our config with defaults for ssh and timesouts */
 	router.GenerateDefaults(&Config)
@@ -26,6 +28,7 @@ func VdxDevice(Config router.RunTimeConfig) *vdxDevice {
 		RTC: Config,
 		Router: router.Router{
 			PromptModes:        make(map[string]string),
+			ExecErrorMatches:   regexp.MustCompile(execErrors),
 			PromptDetect:       `[@?\.\d\w-]+# ?$`,
 			PromptReadTriggers: []string{"# "},
 			PromptReplacements: map[string][]string{
@@ -36,14 +39,14 @@ func VdxDevice(Config router.RunTimeConfig) *vdxDevice {
 
 func (b *vdxDevice) Connect() (err error) {
 
-	if err = b.SetupSSH(b.RTC.ConnectionAddr, b.RTC.SSHClientConfig, true); err != nil {
+	if err = b.SetupSSH(b.RTC, true); err != nil {
 		return err
 	}
 
 	prompt, err := b.Router.ReadTill(b.RTC, b.PromptReadTriggers)
 
 	if err := b.DetectSetPrompt(prompt); err != nil {
-		return fmt.Errorf("detect prompt: %s", err)
+		return fmt.Errorf("detect prompt: %w", err)
 	}
 
 	if _, err = b.skipPageDisplayMode(); err != nil {
@@ -64,7 +67,7 @@ func (b *vdxDevice) DetectSetPrompt(prompt string) error {
 func (b *vdxDevice) write(command string) error {
 	_, err := b.SSHStdinPipe.Write([]byte(command))
 	if err != nil {
-		return fmt.Errorf("can't write to the ssh connection %s", err)
+		return fmt.Errorf("can't write to the ssh connection %w", err)
 	}
 
 	if b.RTC.Debug {
@@ -81,7 +84,7 @@ func (b *vdxDevice) ConfigureTerminalMode() error {
 
 	_, err := b.ReadTill(b.RTC, []string{"(config)#"})
 	if err != nil {
-		return fmt.Errorf("can't find configure prompt: %s", err)
+		return router.Errorf(router.ErrPromptDetect, b.RTC.Hostname, "can't find configure prompt: %w", err)
 	}
 
 	if b.RTC.Debug {
@@ -92,7 +95,7 @@ func (b *vdxDevice) ConfigureTerminalMode() error {
 
 func (b *vdxDevice) ExecPrivilegedMode(command string) error {
 	if err := b.SwitchMode("sshEnabled"); err != nil {
-		return fmt.Errorf("can't switch to privileged mode: %s", err)
+		return fmt.Errorf("can't switch to privileged mode: %w", err)
 	}
 
 	if err := b.Write(b.RTC, command+"\n"); err != nil {
@@ -100,14 +103,14 @@ func (b *vdxDevice) ExecPrivilegedMode(command string) error {
 	}
 	_, err := b.ReadTillEnabledPrompt(b.RTC)
 	if err != nil {
-		return fmt.Errorf("can't find  privileged mode: %s", err)
+		return router.Errorf(router.ErrPromptDetect, b.RTC.Hostname, "can't find  privileged mode: %w", err)
 	}
 	return nil
 }
 
 func (b *vdxDevice) skipPageDisplayMode() (string, error) {
 	if err := b.SwitchMode("sshEnabled"); err != nil {
-		return "", fmt.Errorf("can't switch to enabled mode to execute terminal-length: %s", err)
+		return "", fmt.Errorf("can't switch to enabled mode to execute terminal-length: %w", err)
 	}
 
 	if err := b.Write(b.RTC, "terminal length 0\r\n"); err != nil {
@@ -163,12 +166,39 @@ func (b *vdxDevice) PasteConfiguration(configuration io.Reader) (err error) {
 
 func (b *vdxDevice) RunCommands(commands io.Reader) (err error) {
 	if err = b.SwitchMode("sshEnabled"); err != nil {
-		return fmt.Errorf("can't switch to privileged mode: %s", err)
+		return fmt.Errorf("can't switch to privileged mode: %w", err)
 	}
 
 	return b.Router.RunCommands(b.RTC, commands)
 }
 
+/*DryRun writes the command stream for exec or config mode without connecting */
+func (b *vdxDevice) DryRun(execMode bool, input io.Reader) error {
+	return router.DryRunSteps(b, execMode, input)
+}
+
+/*DryRunExec writes the commands, that RunCommands would send */
+func (b *vdxDevice) DryRunExec(commands io.Reader) error {
+	return b.DryRunCommands(b.RTC, commands)
+}
+
+/*DryRunLogin writes the connection and the terminal settings */
+func (b *vdxDevice) DryRunLogin() {
+	b.DryRunConnect(b.RTC)
+	b.DryRunMode(b.RTC, "terminal length 0")
+}
+
+/*DryRunPaste writes the configuration mode and the statements */
+func (b *vdxDevice) DryRunPaste(configuration io.Reader) error {
+	b.DryRunMode(b.RTC, "conf t")
+	return b.DryRunConfiguration(b.RTC, configuration)
+}
+
+/*DryRunCommit writes nothing, CommitConfiguration does not send anything */
+func (b *vdxDevice) DryRunCommit() error {
+	return nil
+}
+
 func (b *vdxDevice) Close() {
 	b.Router.Close()
 }